@@ -31,6 +31,7 @@ var (
 		&CreateCommand{},
 		&VersionCommand{},
 		&CompileCommand{},
+		&ExportLogsCommand{},
 	}
 )
 
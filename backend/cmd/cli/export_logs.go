@@ -0,0 +1,98 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"robinplatform.dev/internal/identity"
+	"robinplatform.dev/internal/process"
+)
+
+type stringListFlag []string
+
+func (list *stringListFlag) String() string {
+	return strings.Join(*list, ",")
+}
+
+func (list *stringListFlag) Set(value string) error {
+	*list = append(*list, value)
+	return nil
+}
+
+type ExportLogsCommand struct {
+	outputPath string
+	format     process.LogArchiveFormat
+	categories stringListFlag
+	processIds []process.ProcessId
+}
+
+func (cmd *ExportLogsCommand) Name() string {
+	return "export-logs"
+}
+
+func (cmd *ExportLogsCommand) Description() string {
+	return "Packages the logs of robin's processes into an archive"
+}
+
+func (*ExportLogsCommand) ShortUsage() string {
+	return "export-logs [options] [{category}#{key} ...]"
+}
+
+func (cmd *ExportLogsCommand) Parse(flagSet *flag.FlagSet, args []string) error {
+	var format string
+	flagSet.StringVar(&cmd.outputPath, "o", "", "The path to write the archive to (defaults to a timestamped file in the current directory)")
+	flagSet.StringVar(&format, "format", string(process.LogArchiveTarGz), "The archive format, either tar.gz or zip")
+	flagSet.Var(&cmd.categories, "category", "A category of processes to include, can be given multiple times")
+
+	if err := flagSet.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	cmd.format, err = process.ParseLogArchiveFormat(format)
+	if err != nil {
+		return err
+	}
+
+	for _, rawId := range flagSet.Args() {
+		id, err := identity.Parse(rawId)
+		if err != nil {
+			return err
+		}
+
+		cmd.processIds = append(cmd.processIds, process.ProcessId(id))
+	}
+
+	if cmd.outputPath == "" {
+		cmd.outputPath = fmt.Sprintf("robin-logs-%s.%s", time.Now().Format("20060102-150405"), cmd.format)
+	}
+
+	return nil
+}
+
+func (cmd *ExportLogsCommand) Run() error {
+	file, err := os.Create(cmd.outputPath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer file.Close()
+
+	err = process.Manager.WriteLogArchive(file, process.LogArchiveOptions{
+		ProcessIds: cmd.processIds,
+		Categories: cmd.categories,
+		Format:     cmd.format,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to export logs: %w", err)
+	}
+
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+
+	fmt.Printf("Wrote logs to %s\n", cmd.outputPath)
+	return nil
+}
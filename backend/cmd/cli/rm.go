@@ -1,14 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/http"
 	"path/filepath"
+	"syscall"
 
+	"robinplatform.dev/internal/compilerServer"
 	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/server"
 )
 
 type RemoveCommand struct {
+	port       int
 	targetApps []string
 }
 
@@ -25,6 +34,8 @@ func (*RemoveCommand) ShortUsage() string {
 }
 
 func (cmd *RemoveCommand) Parse(flags *flag.FlagSet, args []string) error {
+	flags.IntVar(&cmd.port, "port", server.DefaultPort, "The port of the running robin server")
+
 	if err := flags.Parse(args); err != nil {
 		return err
 	}
@@ -105,5 +116,47 @@ func (cmd *RemoveCommand) Run() error {
 		return fmt.Errorf("failed to save project config: %w", err)
 	}
 
+	// Clean up any processes and durable topics of the removed apps. The server owns them,
+	// so it does the cleanup, and it removes them on startup if it isn't running right now.
+	for appId := range rmTargetIds {
+		err := cmd.removeAppData(appId)
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			fmt.Printf("Robin isn't running, the data of removed apps will be cleaned up when it starts\n")
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to remove data of app %s: %w", appId, err)
+		}
+	}
+
+	return nil
+}
+
+func (cmd *RemoveCommand) removeAppData(appId string) error {
+	body, err := json.Marshal(map[string]string{"appId": appId})
+	if err != nil {
+		return err
+	}
+
+	url := fmt.Sprintf("http://localhost:%d/api/internal/rpc/RemoveAppData", cmd.port)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	// Internal methods are only for robin itself, which the CLI proves with robin's own token
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Robin-App-Token", compilerServer.AppToken(""))
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(res.Body)
+		return fmt.Errorf("robin responded with status %d: %s", res.StatusCode, message)
+	}
+
 	return nil
 }
@@ -28,7 +28,7 @@ func (cmd *StartCommand) Description() string {
 }
 
 func (cmd *StartCommand) Parse(flagSet *flag.FlagSet, args []string) error {
-	flagSet.IntVar(&cmd.port, "port", 9010, "The port to listen on")
+	flagSet.IntVar(&cmd.port, "port", server.DefaultPort, "The port to listen on")
 	flagSet.StringVar(&cmd.bindAddress, "bind", "[::1]", "The address to bind to")
 	flagSet.BoolVar(&cmd.enablePprof, "pprof", false, "Enable pprof endpoints")
 
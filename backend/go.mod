@@ -8,6 +8,8 @@ require (
 	github.com/julienschmidt/httprouter v1.3.0
 	github.com/mitranim/gow v0.0.0-20230208153212-36c8536a96b8
 	github.com/nxadm/tail v1.4.8
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
+	golang.org/x/sys v0.3.0
 	gopkg.in/natefinch/lumberjack.v2 v2.2.1
 )
 
@@ -15,6 +17,5 @@ require (
 	github.com/fsnotify/fsnotify v1.4.9 // indirect
 	github.com/mitranim/gg v0.0.13 // indirect
 	github.com/rjeczalik/notify v0.9.2 // indirect
-	golang.org/x/sys v0.3.0 // indirect
 	gopkg.in/tomb.v1 v1.0.0-20141024135613-dd632973f1e7 // indirect
 )
@@ -0,0 +1,612 @@
+// Package bridge mirrors pubsub topics between robin instances running on the same machine,
+// e.g. one per project, so that apps in one project can follow what's happening in another.
+//
+// Two instances are connected by a session. The instance that dials sends a hello frame with
+// the accepting instance's secret, listing the prefixes it wants to receive (imports) and send (exports), and the other instance
+// answers with its own hello, which only grants the parts of those prefixes that its own config
+// accepts. After that, each side forwards the messages published under the prefixes it exports,
+// and publishes the messages it receives to its own registry.
+//
+// Every forwarded message carries the list of instances it has passed through, in
+// `pubsub.Message.Via`. A message is never sent to an instance that's already in that list,
+// so instances can be connected in any shape, including cycles, without messages looping. In a
+// cycle, a message can still reach an instance along more than one path, and is then delivered
+// once per path.
+package bridge
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
+)
+
+var logger log.Logger = log.New("bridge")
+
+const protocolVersion = 1
+
+// Messages that have passed through this many instances aren't forwarded any further
+const maxHops = 8
+
+// The size of the buffer that exported messages wait in before they're sent to a peer. If
+// the peer can't keep up, the oldest messages are dropped instead of slowing down publishers.
+const exportBufferSize = 1024
+
+const (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+// Topics that are created for messages from peers are closed once they've been idle
+// this long, so that a peer can't make this instance hold on to topics forever
+const bridgedTopicIdleTTL = 5 * time.Minute
+
+// App topics are only delivered to topics that already exist, since they're created
+// by their apps, with options and access rules that a peer doesn't know about.
+const appTopicsCategory = "/app-topics"
+
+var (
+	ErrInvalidPrefix   = errors.New("invalid bridge prefix")
+	ErrHandshakeFailed = errors.New("bridge handshake failed")
+	ErrConnectedToSelf = errors.New("bridge connected to its own instance")
+)
+
+type frameKind string
+
+const (
+	frameHello   frameKind = "hello"
+	frameMessage frameKind = "message"
+	frameError   frameKind = "error"
+)
+
+type frame struct {
+	Kind frameKind `json:"kind"`
+
+	// Set for hello frames
+	Version    int      `json:"version,omitempty"`
+	InstanceId string   `json:"instanceId,omitempty"`
+	Secret     string   `json:"secret,omitempty"`
+	Imports    []string `json:"imports,omitempty"`
+	Exports    []string `json:"exports,omitempty"`
+
+	// Set for message frames
+	TopicId *pubsub.TopicId `json:"topicId,omitempty"`
+	Data    any             `json:"data,omitempty"`
+	Via     []string        `json:"via,omitempty"`
+
+	// Set for error frames
+	Error string `json:"error,omitempty"`
+}
+
+type Bridge struct {
+	Registry *pubsub.Registry
+	// InstanceId identifies this instance to its peers, and has to be unique among them
+	InstanceId string
+	// Secret has to be sent by peers that connect to this instance. Peers are only accepted
+	// if it's set. It's also sent to the peers this instance connects to, unless they have
+	// their own secret configured.
+	Secret string
+	// Accept limits what peers that connect to this instance can mirror, with directions
+	// from this instance's point of view. Sessions only mirror the topics that are covered
+	// both by what the peer asks for and by these prefixes.
+	Accept []project.BridgeMirrorConfig
+}
+
+func New(registry *pubsub.Registry) (*Bridge, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+
+	return &Bridge{
+		Registry:   registry,
+		InstanceId: hex.EncodeToString(buf),
+	}, nil
+}
+
+// Validates and normalizes prefixes, removing prefixes that are already covered
+// by another one, so that each message is only forwarded once.
+func normalizePrefixes(prefixes []string) ([]string, error) {
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		prefix = "/" + strings.Trim(prefix, "/")
+		if prefix == "/" {
+			return nil, fmt.Errorf("%w: can't mirror every topic", ErrInvalidPrefix)
+		}
+
+		// Meta topics and request replies only make sense within a single instance
+		for _, local := range []string{"/topics", pubsub.RepliesCategory} {
+			if prefixCovers(local, prefix) || prefixCovers(prefix, local) {
+				return nil, fmt.Errorf("%w: topics in '%s' can't be mirrored", ErrInvalidPrefix, local)
+			}
+		}
+
+		normalized = append(normalized, prefix)
+	}
+
+	result := make([]string, 0, len(normalized))
+	for i, prefix := range normalized {
+		covered := false
+		for j, other := range normalized {
+			if i != j && prefixCovers(other, prefix) && (other != prefix || j < i) {
+				covered = true
+				break
+			}
+		}
+
+		if !covered {
+			result = append(result, prefix)
+		}
+	}
+
+	return result, nil
+}
+
+// Returns whether topics in `category` are under `prefix`
+func prefixCovers(prefix string, category string) bool {
+	return category == prefix || strings.HasPrefix(category, prefix+"/")
+}
+
+func matchesAny(prefixes []string, id pubsub.TopicId) bool {
+	for _, prefix := range prefixes {
+		if prefixCovers(prefix, id.Category) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the parts of `requested` that are also covered by `allowed`
+func intersectPrefixes(requested []string, allowed []string) []string {
+	result := []string{}
+	for _, prefix := range requested {
+		for _, other := range allowed {
+			if prefixCovers(other, prefix) {
+				result = append(result, prefix)
+			} else if prefixCovers(prefix, other) {
+				result = append(result, other)
+			}
+		}
+	}
+
+	// Both lists are already valid, so this only removes duplicates
+	result, _ = normalizePrefixes(result)
+	return result
+}
+
+// Splits a peer's mirror config into the prefixes to import from it and export to it
+func mirrorPrefixes(mirror []project.BridgeMirrorConfig) (imports []string, exports []string, err error) {
+	for _, item := range mirror {
+		switch item.Direction {
+		case project.BridgeImport:
+			imports = append(imports, item.Prefix)
+		case project.BridgeExport:
+			exports = append(exports, item.Prefix)
+		case project.BridgeBoth, "":
+			imports = append(imports, item.Prefix)
+			exports = append(exports, item.Prefix)
+		default:
+			return nil, nil, fmt.Errorf("%w: unknown direction '%s' for '%s'", ErrInvalidPrefix, item.Direction, item.Prefix)
+		}
+	}
+
+	if imports, err = normalizePrefixes(imports); err != nil {
+		return nil, nil, err
+	}
+	if exports, err = normalizePrefixes(exports); err != nil {
+		return nil, nil, err
+	}
+
+	return imports, exports, nil
+}
+
+// Runs the dialing side of a session, until the connection fails or the context is cancelled.
+// `onConnected` is called once the handshake succeeds.
+func (b *Bridge) dialSession(ctx context.Context, c conn, secret string, imports []string, exports []string, onConnected func()) error {
+	defer c.Close()
+
+	imports, err := normalizePrefixes(imports)
+	if err != nil {
+		return err
+	}
+	exports, err = normalizePrefixes(exports)
+	if err != nil {
+		return err
+	}
+
+	err = c.writeFrame(frame{
+		Kind:       frameHello,
+		Version:    protocolVersion,
+		InstanceId: b.InstanceId,
+		Secret:     secret,
+		Imports:    imports,
+		Exports:    exports,
+	})
+	if err != nil {
+		return err
+	}
+
+	var hello frame
+	if err := c.readFrame(&hello); err != nil {
+		return err
+	}
+
+	switch {
+	case hello.Kind == frameError:
+		return fmt.Errorf("%w: %s", ErrHandshakeFailed, hello.Error)
+	case hello.Kind != frameHello:
+		return fmt.Errorf("%w: expected hello, got '%s'", ErrHandshakeFailed, hello.Kind)
+	case hello.Version != protocolVersion:
+		return fmt.Errorf("%w: unsupported protocol version %d", ErrHandshakeFailed, hello.Version)
+	case hello.InstanceId == b.InstanceId:
+		return ErrConnectedToSelf
+	}
+
+	if onConnected != nil {
+		onConnected()
+	}
+
+	// The peer exports what we import, and imports what we export, but it might have
+	// only accepted part of what we asked for
+	imports = intersectPrefixes(imports, hello.Exports)
+	exports = intersectPrefixes(exports, hello.Imports)
+	return b.run(ctx, c, hello.InstanceId, imports, exports)
+}
+
+// Runs the accepting side of a session, until the connection fails or the context is cancelled.
+// The peer asks for what it wants mirrored, and gets the parts of it that `Accept` allows.
+func (b *Bridge) acceptSession(ctx context.Context, c conn) error {
+	defer c.Close()
+
+	var hello frame
+	if err := c.readFrame(&hello); err != nil {
+		return err
+	}
+
+	reject := func(err error) error {
+		c.writeFrame(frame{Kind: frameError, Error: err.Error()})
+		return err
+	}
+
+	switch {
+	case hello.Kind != frameHello:
+		return reject(fmt.Errorf("%w: expected hello, got '%s'", ErrHandshakeFailed, hello.Kind))
+	case !b.checkSecret(hello.Secret):
+		return reject(fmt.Errorf("%w: invalid secret", ErrHandshakeFailed))
+	case hello.Version != protocolVersion:
+		return reject(fmt.Errorf("%w: unsupported protocol version %d", ErrHandshakeFailed, hello.Version))
+	case hello.InstanceId == "":
+		return reject(fmt.Errorf("%w: missing instance ID", ErrHandshakeFailed))
+	case hello.InstanceId == b.InstanceId:
+		return reject(ErrConnectedToSelf)
+	}
+
+	// What the peer imports, we export
+	requestedExports, err := normalizePrefixes(hello.Imports)
+	if err != nil {
+		return reject(err)
+	}
+	requestedImports, err := normalizePrefixes(hello.Exports)
+	if err != nil {
+		return reject(err)
+	}
+
+	acceptImports, acceptExports, err := mirrorPrefixes(b.Accept)
+	if err != nil {
+		return reject(err)
+	}
+
+	imports := intersectPrefixes(requestedImports, acceptImports)
+	exports := intersectPrefixes(requestedExports, acceptExports)
+	if len(imports) == 0 && len(exports) == 0 {
+		return reject(fmt.Errorf("%w: none of the requested topics are accepted", ErrHandshakeFailed))
+	}
+
+	err = c.writeFrame(frame{
+		Kind:       frameHello,
+		Version:    protocolVersion,
+		InstanceId: b.InstanceId,
+		Imports:    imports,
+		Exports:    exports,
+	})
+	if err != nil {
+		return err
+	}
+
+	return b.run(ctx, c, hello.InstanceId, imports, exports)
+}
+
+// Checks a secret sent by a peer. Nothing matches if this instance has no secret.
+func (b *Bridge) checkSecret(secret string) bool {
+	return b.Secret != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(b.Secret)) == 1
+}
+
+// Mirrors topics over an established session
+func (b *Bridge) run(ctx context.Context, c conn, peerId string, imports []string, exports []string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logger.Print("Bridge connected", log.Ctx{
+		"peer":    peerId,
+		"imports": imports,
+		"exports": exports,
+	})
+
+	for _, prefix := range exports {
+		pattern, err := pubsub.ParseTopicPattern(pubsub.EscapeCategory(prefix) + "/**")
+		if err != nil {
+			return err
+		}
+
+		sub, err := pubsub.SubscribePattern(b.Registry, pattern, pubsub.SubscribeOptions{
+			Policy:     pubsub.BackpressureDropOldest,
+			BufferSize: exportBufferSize,
+		})
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+
+		go b.export(ctx, c, peerId, sub.Out, cancel)
+	}
+
+	// Unblock the reader once the session is over
+	go func() {
+		<-ctx.Done()
+		c.Close()
+	}()
+
+	for {
+		var f frame
+		if err := c.readFrame(&f); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		switch f.Kind {
+		case frameMessage:
+			b.receive(peerId, imports, f)
+		case frameError:
+			return fmt.Errorf("bridge peer failed: %s", f.Error)
+		default:
+			logger.Warn("Ignoring unsupported bridge frame", log.Ctx{
+				"peer": peerId,
+				"kind": f.Kind,
+			})
+		}
+	}
+}
+
+// Forwards messages to the peer, until the context is cancelled. The session is cancelled
+// if writing fails.
+func (b *Bridge) export(ctx context.Context, c conn, peerId string, messages <-chan pubsub.TaggedMessage, cancel func()) {
+	for {
+		select {
+		case message := <-messages:
+			if len(message.Via) >= maxHops || contains(message.Via, peerId) {
+				continue
+			}
+
+			// Local messages have no `Via`, so this instance becomes the first entry
+			via := append(append(make([]string, 0, len(message.Via)+1), message.Via...), b.InstanceId)
+
+			topicId := message.TopicId
+			err := c.writeFrame(frame{
+				Kind:    frameMessage,
+				TopicId: &topicId,
+				Data:    message.Data,
+				Via:     via,
+			})
+			if err != nil {
+				cancel()
+				return
+			}
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Publishes a message from the peer to the local registry. Messages have to match the
+// schema of the local topic, if it has one.
+func (b *Bridge) receive(peerId string, imports []string, f frame) {
+	if f.TopicId == nil || contains(f.Via, b.InstanceId) {
+		return
+	}
+
+	topicId := *f.TopicId
+	if !matchesAny(imports, topicId) {
+		logger.Warn("Dropping bridged message for a topic that isn't imported", log.Ctx{
+			"peer":    peerId,
+			"topicId": topicId.String(),
+		})
+		return
+	}
+
+	err := pubsub.PublishAny(b.Registry, topicId, f.Data, f.Via)
+	if errors.Is(err, pubsub.ErrTopicDoesntExist) && !prefixCovers(appTopicsCategory, topicId.Category) {
+		_, err = pubsub.CreateTopic[any](b.Registry, topicId, pubsub.TopicOptions{
+			IdleTTL: bridgedTopicIdleTTL,
+		})
+		if err == nil || errors.Is(err, pubsub.ErrTopicExists) {
+			err = pubsub.PublishAny(b.Registry, topicId, f.Data, f.Via)
+		}
+	}
+
+	if err != nil && !errors.Is(err, pubsub.ErrTopicDoesntExist) {
+		logger.Warn("Failed to publish bridged message", log.Ctx{
+			"peer":    peerId,
+			"topicId": topicId.String(),
+			"err":     err.Error(),
+		})
+	}
+}
+
+func contains(items []string, item string) bool {
+	for _, other := range items {
+		if other == item {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Connects to a peer and mirrors topics with it until the context is cancelled. Lost
+// connections are retried, waiting longer after each failure, up to 30 seconds.
+func (b *Bridge) Connect(ctx context.Context, peer project.BridgePeerConfig) error {
+	imports, exports, err := mirrorPrefixes(peer.Mirror)
+	if err != nil {
+		return err
+	}
+
+	secret := peer.Secret
+	if secret == "" {
+		secret = b.Secret
+	}
+	if secret == "" {
+		return fmt.Errorf("%w: no secret configured for the peer", ErrHandshakeFailed)
+	}
+
+	go func() {
+		delay := minReconnectDelay
+		for ctx.Err() == nil {
+			c, err := dial(peer.Address)
+			if err == nil {
+				err = b.dialSession(ctx, c, secret, imports, exports, func() {
+					delay = minReconnectDelay
+				})
+			}
+
+			if ctx.Err() != nil {
+				return
+			}
+
+			logger.Warn("Bridge connection lost, reconnecting", log.Ctx{
+				"address": peer.Address,
+				"delay":   delay.String(),
+				"err":     err.Error(),
+			})
+
+			select {
+			case <-time.After(delay):
+			case <-ctx.Done():
+				return
+			}
+
+			delay *= 2
+			if delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+		}
+	}()
+
+	return nil
+}
+
+// Accepts peers on a unix socket until the context is cancelled
+func (b *Bridge) Listen(ctx context.Context, path string) error {
+	// A socket left over from a previous run would make listening fail
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	go func() {
+		for {
+			netConn, err := listener.Accept()
+			if err != nil {
+				if ctx.Err() == nil {
+					logger.Err("Bridge listener failed", log.Ctx{
+						"path": path,
+						"err":  err.Error(),
+					})
+				}
+				return
+			}
+
+			go b.serve(ctx, newStreamConn(netConn))
+		}
+	}()
+
+	return nil
+}
+
+func (b *Bridge) serve(ctx context.Context, c conn) {
+	err := b.acceptSession(ctx, c)
+	if ctx.Err() == nil {
+		logger.Warn("Bridge peer disconnected", log.Ctx{
+			"err": err.Error(),
+		})
+	}
+}
+
+// Accepts a peer over a websocket, for the `/api/bridge` route. Peers don't send an
+// `Origin` header, so upgrades from web pages on other origins are rejected, and peers
+// that don't send this instance's secret are rejected during the handshake.
+func (b *Bridge) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	upgrader := websocket.Upgrader{}
+
+	ws, err := upgrader.Upgrade(res, req, nil)
+	if err != nil {
+		logger.Err("Failed to upgrade bridge websocket", log.Ctx{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	b.serve(req.Context(), &websocketConn{ws: ws})
+}
+
+// Starts listening and connecting to peers, as configured. The bridge runs until
+// the context is cancelled.
+func (b *Bridge) Start(ctx context.Context, config project.BridgeConfig) error {
+	if _, _, err := mirrorPrefixes(config.Accept); err != nil {
+		return fmt.Errorf("invalid bridge accept config: %w", err)
+	}
+	b.Accept = config.Accept
+	b.Secret = config.Secret
+
+	if config.Listen != "" {
+		if config.Secret == "" {
+			return fmt.Errorf("a bridge secret is required to listen on '%s'", config.Listen)
+		}
+
+		if err := b.Listen(ctx, config.Listen); err != nil {
+			return fmt.Errorf("failed to listen on '%s': %w", config.Listen, err)
+		}
+	}
+
+	for _, peer := range config.Peers {
+		if err := b.Connect(ctx, peer); err != nil {
+			return fmt.Errorf("invalid bridge peer '%s': %w", peer.Address, err)
+		}
+	}
+
+	return nil
+}
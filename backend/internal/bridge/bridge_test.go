@@ -0,0 +1,417 @@
+package bridge
+
+import (
+	"context"
+	"errors"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
+)
+
+const testSecret = "secret"
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func patternSubscriptions(registry *pubsub.Registry) int {
+	return registry.GetMetrics().Summary.PatternSubscriptions
+}
+
+// Connects two bridges over an in-memory connection, and waits until both are forwarding messages
+func connectPipe(t *testing.T, ctx context.Context, dialer *Bridge, acceptor *Bridge, imports []string, exports []string) {
+	t.Helper()
+
+	dialerSubs := patternSubscriptions(dialer.Registry) + len(exports)
+	acceptorSubs := patternSubscriptions(acceptor.Registry) + len(imports)
+
+	dialerConn, acceptorConn := net.Pipe()
+	go dialer.dialSession(ctx, newStreamConn(dialerConn), testSecret, imports, exports, nil)
+	go acceptor.acceptSession(ctx, newStreamConn(acceptorConn))
+
+	waitFor(t, "bridge to connect", func() bool {
+		return patternSubscriptions(dialer.Registry) == dialerSubs && patternSubscriptions(acceptor.Registry) == acceptorSubs
+	})
+}
+
+func subscribeAll(t *testing.T, registry *pubsub.Registry) pubsub.PatternSubscription {
+	t.Helper()
+
+	pattern, err := pubsub.ParseTopicPattern("/**")
+	if err != nil {
+		t.Fatalf("failed to parse pattern: %s", err)
+	}
+
+	sub, err := pubsub.SubscribePattern(registry, pattern, pubsub.SubscribeOptions{BufferSize: 64})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err)
+	}
+	t.Cleanup(sub.Unsubscribe)
+
+	return sub
+}
+
+func expectMessage(t *testing.T, sub pubsub.PatternSubscription, topicId pubsub.TopicId, data any, via []string) {
+	t.Helper()
+
+	select {
+	case message := <-sub.Out:
+		if message.TopicId != topicId || !reflect.DeepEqual(message.Data, data) || !reflect.DeepEqual(message.Via, via) {
+			t.Fatalf("expected %v on %s via %v, got %v on %s via %v", data, topicId.String(), via, message.Data, message.TopicId.String(), message.Via)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for %v on %s", data, topicId.String())
+	}
+}
+
+func expectNoMessage(t *testing.T, sub pubsub.PatternSubscription) {
+	t.Helper()
+
+	select {
+	case message := <-sub.Out:
+		t.Fatalf("expected no more messages, got %v on %s via %v", message.Data, message.TopicId.String(), message.Via)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBridgeMirrorsBothDirections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "a", Secret: testSecret}
+	b := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "b",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared"}},
+	}
+
+	shared := pubsub.TopicId{Category: "/shared/db", Key: "events"}
+	private := pubsub.TopicId{Category: "/private", Key: "events"}
+
+	topic, err := pubsub.CreateTopic[string](a.Registry, shared, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+	privateTopic, err := pubsub.CreateTopic[string](a.Registry, private, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+
+	subA := subscribeAll(t, a.Registry)
+	subB := subscribeAll(t, b.Registry)
+
+	connectPipe(t, ctx, a, b, []string{"/shared"}, []string{"/shared/"})
+
+	privateTopic.Publish("secret")
+	expectMessage(t, subA, private, "secret", nil)
+
+	topic.Publish("db restarted")
+	expectMessage(t, subA, shared, "db restarted", nil)
+	// B doesn't have the topic, so it gets created
+	expectMessage(t, subB, shared, "db restarted", []string{"a"})
+
+	if err := pubsub.PublishAny(b.Registry, shared, "ack", nil); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	expectMessage(t, subB, shared, "ack", nil)
+	expectMessage(t, subA, shared, "ack", []string{"b"})
+
+	// Neither message is sent back to where it came from
+	expectNoMessage(t, subA)
+	expectNoMessage(t, subB)
+
+	if _, ok := b.Registry.GetTopicInfo().Info[private.String()]; ok {
+		t.Fatalf("topic that isn't exported was mirrored")
+	}
+}
+
+func TestBridgeForwardsAcrossInstances(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "a",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared", Direction: project.BridgeExport}},
+	}
+	b := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "b", Secret: testSecret}
+	c := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "c",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared"}},
+	}
+
+	subA := subscribeAll(t, a.Registry)
+	subC := subscribeAll(t, c.Registry)
+
+	// A only sends to B, which mirrors both ways with C
+	connectPipe(t, ctx, b, a, []string{"/shared"}, nil)
+	connectPipe(t, ctx, b, c, []string{"/shared"}, []string{"/shared"})
+
+	topicId := pubsub.TopicId{Category: "/shared", Key: "events"}
+	topic, err := pubsub.CreateTopic[map[string]any](a.Registry, topicId, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+
+	topic.Publish(map[string]any{"kind": "restart"})
+	expectMessage(t, subA, topicId, map[string]any{"kind": "restart"}, nil)
+	expectMessage(t, subC, topicId, map[string]any{"kind": "restart"}, []string{"a", "b"})
+
+	// C's messages reach B, but A doesn't import anything
+	if err := pubsub.PublishAny(c.Registry, topicId, map[string]any{"kind": "ack"}, nil); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	expectMessage(t, subC, topicId, map[string]any{"kind": "ack"}, nil)
+
+	expectNoMessage(t, subA)
+	expectNoMessage(t, subC)
+}
+
+func TestBridgeLimitsAcceptedPrefixes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "a", Secret: testSecret}
+	b := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "b",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared/db", Direction: project.BridgeImport}},
+	}
+
+	subA := subscribeAll(t, a.Registry)
+	subB := subscribeAll(t, b.Registry)
+
+	// A asks to mirror all of `/shared` both ways, but B only accepts messages for `/shared/db`
+	subs := patternSubscriptions(a.Registry) + 1
+	dialerConn, acceptorConn := net.Pipe()
+	go a.dialSession(ctx, newStreamConn(dialerConn), testSecret, []string{"/shared"}, []string{"/shared"}, nil)
+	go b.acceptSession(ctx, newStreamConn(acceptorConn))
+
+	waitFor(t, "bridge to connect", func() bool {
+		return patternSubscriptions(a.Registry) == subs
+	})
+
+	db := pubsub.TopicId{Category: "/shared/db", Key: "events"}
+	other := pubsub.TopicId{Category: "/shared/other", Key: "events"}
+	for _, topicId := range []pubsub.TopicId{db, other} {
+		if _, err := pubsub.CreateTopic[string](a.Registry, topicId, pubsub.TopicOptions{}); err != nil {
+			t.Fatalf("failed to create topic: %s", err)
+		}
+		if _, err := pubsub.CreateTopic[string](b.Registry, topicId, pubsub.TopicOptions{}); err != nil {
+			t.Fatalf("failed to create topic: %s", err)
+		}
+	}
+
+	if err := pubsub.PublishAny(a.Registry, other, "ignored", nil); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	if err := pubsub.PublishAny(a.Registry, db, "db restarted", nil); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	expectMessage(t, subA, other, "ignored", nil)
+	expectMessage(t, subA, db, "db restarted", nil)
+	expectMessage(t, subB, db, "db restarted", []string{"a"})
+
+	// B doesn't export anything to A
+	if err := pubsub.PublishAny(b.Registry, db, "ack", nil); err != nil {
+		t.Fatalf("failed to publish: %s", err)
+	}
+	expectMessage(t, subB, db, "ack", nil)
+
+	expectNoMessage(t, subA)
+	expectNoMessage(t, subB)
+}
+
+func TestBridgeValidatesReceivedMessages(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "a", Secret: testSecret}
+	b := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "b",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared", Direction: project.BridgeImport}},
+	}
+
+	typed := pubsub.TopicId{Category: "/shared", Key: "typed"}
+	untyped := pubsub.TopicId{Category: "/shared", Key: "untyped"}
+
+	_, err := pubsub.CreateTopic[any](b.Registry, typed, pubsub.TopicOptions{
+		Schema: []byte(`{"type": "string"}`),
+	})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+	topic, err := pubsub.CreateTopic[any](a.Registry, typed, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+	untypedTopic, err := pubsub.CreateTopic[any](a.Registry, untyped, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+
+	subB := subscribeAll(t, b.Registry)
+	connectPipe(t, ctx, a, b, nil, []string{"/shared"})
+
+	// B's topic only takes strings, so the number is dropped
+	topic.Publish(1)
+	topic.Publish("ok")
+	expectMessage(t, subB, typed, "ok", []string{"a"})
+
+	// Topics that B creates for A's messages close once they're idle
+	untypedTopic.Publish("created")
+	expectMessage(t, subB, untyped, "created", []string{"a"})
+
+	info, ok := b.Registry.GetTopicInfo().Info[untyped.String()]
+	if !ok || info.IdleTTL != bridgedTopicIdleTTL {
+		t.Fatalf("expected the bridged topic to have an idle TTL of %s, got %+v", bridgedTopicIdleTTL, info)
+	}
+
+	expectNoMessage(t, subB)
+}
+
+func TestBridgeRejectsUnacceptedSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "a", Secret: testSecret}
+	b := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "b", Secret: testSecret}
+
+	dialerConn, acceptorConn := net.Pipe()
+	go b.acceptSession(ctx, newStreamConn(acceptorConn))
+
+	err := a.dialSession(ctx, newStreamConn(dialerConn), testSecret, []string{"/shared"}, []string{"/shared"}, nil)
+	if !errors.Is(err, ErrHandshakeFailed) {
+		t.Fatalf("expected the session to be rejected, got %v", err)
+	}
+}
+
+func TestBridgeRejectsInvalidSecrets(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a := &Bridge{Registry: &pubsub.Registry{}, InstanceId: "a"}
+	b := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "b",
+		Secret:     testSecret,
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared"}},
+	}
+	// Without a secret of its own, an instance doesn't accept anyone
+	c := &Bridge{
+		Registry:   &pubsub.Registry{},
+		InstanceId: "c",
+		Accept:     []project.BridgeMirrorConfig{{Prefix: "/shared"}},
+	}
+
+	testCases := []struct {
+		acceptor *Bridge
+		secret   string
+	}{
+		{acceptor: b, secret: ""},
+		{acceptor: b, secret: "wrong"},
+		{acceptor: c, secret: ""},
+	}
+
+	for _, testCase := range testCases {
+		dialerConn, acceptorConn := net.Pipe()
+		go testCase.acceptor.acceptSession(ctx, newStreamConn(acceptorConn))
+
+		err := a.dialSession(ctx, newStreamConn(dialerConn), testCase.secret, []string{"/shared"}, []string{"/shared"}, nil)
+		if !errors.Is(err, ErrHandshakeFailed) {
+			t.Errorf("expected %s to reject secret '%s', got %v", testCase.acceptor.InstanceId, testCase.secret, err)
+		}
+	}
+
+	if patternSubscriptions(b.Registry) != 0 || patternSubscriptions(c.Registry) != 0 {
+		t.Fatalf("expected rejected peers not to get any topics")
+	}
+}
+
+func TestBridgeRejectsInvalidPrefixes(t *testing.T) {
+	for _, prefix := range []string{"/", "/topics", "/replies/abc", ""} {
+		_, _, err := mirrorPrefixes([]project.BridgeMirrorConfig{{Prefix: prefix}})
+		if err == nil {
+			t.Errorf("expected prefix '%s' to be rejected", prefix)
+		}
+	}
+
+	imports, exports, err := mirrorPrefixes([]project.BridgeMirrorConfig{
+		{Prefix: "/logs/app/", Direction: project.BridgeImport},
+		{Prefix: "/logs", Direction: project.BridgeBoth},
+		{Prefix: "/events", Direction: project.BridgeExport},
+	})
+	if err != nil {
+		t.Fatalf("failed to parse mirror config: %s", err)
+	}
+
+	if !reflect.DeepEqual(imports, []string{"/logs"}) || !reflect.DeepEqual(exports, []string{"/logs", "/events"}) {
+		t.Fatalf("unexpected prefixes, imports: %v, exports: %v", imports, exports)
+	}
+}
+
+func TestBridgeReconnects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	a, err := New(&pubsub.Registry{})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %s", err)
+	}
+	b, err := New(&pubsub.Registry{})
+	if err != nil {
+		t.Fatalf("failed to create bridge: %s", err)
+	}
+
+	a.Accept = []project.BridgeMirrorConfig{{Prefix: "/shared", Direction: project.BridgeExport}}
+	a.Secret = testSecret
+	subB := subscribeAll(t, b.Registry)
+
+	// B starts dialing before A listens, so the first attempt fails
+	socketPath := filepath.Join(t.TempDir(), "bridge.sock")
+	err = b.Connect(ctx, project.BridgePeerConfig{
+		Address: "unix://" + socketPath,
+		Secret:  testSecret,
+		Mirror:  []project.BridgeMirrorConfig{{Prefix: "/shared", Direction: project.BridgeImport}},
+	})
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+
+	if err := a.Listen(ctx, socketPath); err != nil {
+		t.Fatalf("failed to listen: %s", err)
+	}
+
+	waitFor(t, "bridge to reconnect", func() bool {
+		return patternSubscriptions(a.Registry) == 1
+	})
+
+	topicId := pubsub.TopicId{Category: "/shared", Key: "events"}
+	topic, err := pubsub.CreateTopic[string](a.Registry, topicId, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatalf("failed to create topic: %s", err)
+	}
+
+	topic.Publish("db restarted")
+	expectMessage(t, subB, topicId, "db restarted", []string{a.InstanceId})
+}
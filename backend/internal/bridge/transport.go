@@ -0,0 +1,119 @@
+package bridge
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+// A connection to a peer, which sends and receives frames. Writes may happen concurrently,
+// but only one goroutine may read.
+type conn interface {
+	readFrame(f *frame) error
+	writeFrame(f frame) error
+	Close() error
+}
+
+type websocketConn struct {
+	ws *websocket.Conn
+	m  sync.Mutex
+}
+
+func (c *websocketConn) readFrame(f *frame) error {
+	return c.ws.ReadJSON(f)
+}
+
+func (c *websocketConn) writeFrame(f frame) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	return c.ws.WriteJSON(f)
+}
+
+func (c *websocketConn) Close() error {
+	return c.ws.Close()
+}
+
+// Sends frames as newline-delimited JSON, e.g. over a unix socket
+type streamConn struct {
+	conn    net.Conn
+	decoder *json.Decoder
+
+	m       sync.Mutex
+	writer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+func newStreamConn(netConn net.Conn) *streamConn {
+	writer := bufio.NewWriter(netConn)
+	return &streamConn{
+		conn:    netConn,
+		decoder: json.NewDecoder(bufio.NewReader(netConn)),
+		writer:  writer,
+		encoder: json.NewEncoder(writer),
+	}
+}
+
+func (c *streamConn) readFrame(f *frame) error {
+	return c.decoder.Decode(f)
+}
+
+func (c *streamConn) writeFrame(f frame) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if err := c.encoder.Encode(f); err != nil {
+		return err
+	}
+
+	return c.writer.Flush()
+}
+
+func (c *streamConn) Close() error {
+	return c.conn.Close()
+}
+
+// Connects to a peer's address, which is either the URL of a robin server or
+// `unix://` followed by the path of a socket.
+func dial(address string) (conn, error) {
+	parsed, err := url.Parse(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bridge address '%s': %w", address, err)
+	}
+
+	switch parsed.Scheme {
+	case "unix":
+		path := parsed.Path
+		if parsed.Host != "" {
+			// `unix://relative/path` puts the first segment in the host
+			path = parsed.Host + path
+		}
+
+		netConn, err := net.Dial("unix", path)
+		if err != nil {
+			return nil, err
+		}
+		return newStreamConn(netConn), nil
+
+	case "http", "https", "ws", "wss":
+		parsed.Scheme = strings.Replace(parsed.Scheme, "http", "ws", 1)
+		if parsed.Path == "" || parsed.Path == "/" {
+			parsed.Path = "/api/bridge"
+		}
+
+		ws, _, err := websocket.DefaultDialer.Dial(parsed.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return &websocketConn{ws: ws}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported bridge address '%s', expected a ws:// or unix:// address", address)
+	}
+}
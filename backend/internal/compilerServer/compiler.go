@@ -2,9 +2,11 @@ package compilerServer
 
 import (
 	"fmt"
+	"html"
 	"net/http"
 	"os"
 	"strconv"
+	"strings"
 	"sync"
 
 	"robinplatform.dev/internal/compile/compileClient"
@@ -58,6 +60,9 @@ type CompiledApp struct {
 
 	topicMux sync.Mutex
 	topicMap map[string]*pubsub.Topic[any]
+	// topicOptions holds the options each topic was created with, so that topics which
+	// expire get recreated with the same schema, retention and ACL
+	topicOptions map[string]pubsub.TopicOptions
 
 	// Html holds the HTML to be rendered on the client
 	Html string
@@ -74,8 +79,22 @@ type CompiledApp struct {
 
 func (compiler *Compiler) ResetAppCache(id string) {
 	compiler.mux.Lock()
+	app := compiler.apps[id]
 	delete(compiler.apps, id)
 	compiler.mux.Unlock()
+
+	// The topics are tracked by the cached app, so they would be orphaned otherwise
+	if app != nil {
+		app.closeTopics()
+	}
+}
+
+// Forgets an app that was removed from the project. Unlike `ResetAppCache`, this also
+// deletes the durable logs of the app's topics, since no instance of the app will ever
+// claim them again.
+func (compiler *Compiler) RemoveApp(id string) {
+	compiler.ResetAppCache(id)
+	pubsub.Topics.DiscardCategory(AppTopicCategory(id))
 }
 
 func (compiler *Compiler) GetApp(id string) (*CompiledApp, bool, error) {
@@ -105,6 +124,7 @@ func (compiler *Compiler) GetApp(id string) (*CompiledApp, bool, error) {
 		compiler:         compiler,
 		keepAliveRunning: new(int64),
 		topicMap:         make(map[string]*pubsub.Topic[any]),
+		topicOptions:     make(map[string]pubsub.TopicOptions),
 		builderMux:       &sync.RWMutex{},
 
 		Id:        id,
@@ -136,7 +156,10 @@ func (compiler *Compiler) RenderClient(id string, res http.ResponseWriter) error
 			res.Header().Set("X-Cache", "MISS")
 		}
 
-		res.Write([]byte(app.Html))
+		// The token is added when the page is served instead of being compiled into the app,
+		// since anything that can load the bundle could read it from there
+		tokenMeta := fmt.Sprintf(`<meta name="robin-app-token" content="%s" />`, html.EscapeString(AppToken(app.Id)))
+		res.Write([]byte(strings.Replace(app.Html, "</head>", tokenMeta+"</head>", 1)))
 	}
 
 	return nil
@@ -215,18 +238,51 @@ func (app *CompiledApp) GetTopic(topicId pubsub.TopicId) *pubsub.Topic[any] {
 	app.topicMux.Lock()
 	defer app.topicMux.Unlock()
 
-	return app.topicMap[topicId.String()]
+	topic := app.topicMap[topicId.String()]
+	if topic == nil || topic.IsClosed() {
+		return nil
+	}
+
+	return topic
 }
 
-func (app *CompiledApp) UpsertTopic(topicId pubsub.TopicId) (*pubsub.Topic[any], error) {
+// Returns the app's topic with the given ID, creating it if needed. The options are
+// only used when the topic gets created.
+func (app *CompiledApp) UpsertTopic(topicId pubsub.TopicId, opts pubsub.TopicOptions) (*pubsub.Topic[any], error) {
 	app.topicMux.Lock()
 	defer app.topicMux.Unlock()
 
-	if topic, found := app.topicMap[topicId.String()]; found {
+	if topic, found := app.topicMap[topicId.String()]; found && !topic.IsClosed() {
 		return topic, nil
 	}
 
-	topic, err := pubsub.CreateTopic[any](&pubsub.Topics, topicId)
+	app.topicOptions[topicId.String()] = opts
+	return app.createTopic(topicId, opts)
+}
+
+// Returns the app's topic with the given ID. Topics can be closed by expiring, in which
+// case they get recreated with the options they were created with. Topics that were never
+// created get created without any options.
+func (app *CompiledApp) ReopenTopic(topicId pubsub.TopicId) (*pubsub.Topic[any], error) {
+	app.topicMux.Lock()
+	defer app.topicMux.Unlock()
+
+	if topic, found := app.topicMap[topicId.String()]; found && !topic.IsClosed() {
+		return topic, nil
+	}
+
+	return app.createTopic(topicId, app.topicOptions[topicId.String()])
+}
+
+// Must be called with `topicMux` held
+func (app *CompiledApp) createTopic(topicId pubsub.TopicId, opts pubsub.TopicOptions) (*pubsub.Topic[any], error) {
+	acl, err := app.topicAcl(topicId)
+	if err != nil {
+		return nil, err
+	}
+	opts.Acl = acl
+
+	topic, err := pubsub.CreateTopic[any](&pubsub.Topics, topicId, opts)
 	if err != nil {
 		return nil, err
 	}
@@ -234,7 +290,61 @@ func (app *CompiledApp) UpsertTopic(topicId pubsub.TopicId) (*pubsub.Topic[any],
 	app.topicMap[topicId.String()] = topic
 
 	return topic, nil
+}
+
+// Closes all of the app's topics, including ones that were created by a previous
+// instance of the app. Durable topics keep their logs, so their messages are restored
+// when the app creates them again.
+func (app *CompiledApp) closeTopics() {
+	app.topicMux.Lock()
+	defer app.topicMux.Unlock()
 
+	app.topicMap = make(map[string]*pubsub.Topic[any])
+	app.topicOptions = make(map[string]pubsub.TopicOptions)
+	pubsub.Topics.CloseCategory(AppTopicCategory(app.Id))
+}
+
+// Builds the ACL of one of the app's topics from the `topicAccess` rules in its config
+func (app *CompiledApp) topicAcl(topicId pubsub.TopicId) (pubsub.TopicAcl, error) {
+	acl := pubsub.TopicAcl{Owner: app.Id}
+
+	config, err := app.GetConfig()
+	if err != nil {
+		return acl, fmt.Errorf("failed to load config of app '%s': %w", app.Id, err)
+	}
+
+	for _, rule := range config.TopicAccess {
+		categoryParts := []string{"app-topics", app.Id}
+		categoryParts = append(categoryParts, rule.Category...)
+		ruleCategory := identity.Category(categoryParts...)
+
+		if topicId.Category != ruleCategory && !strings.HasPrefix(topicId.Category, ruleCategory+"/") {
+			continue
+		}
+
+		acl.ReadPublic = acl.ReadPublic || rule.ReadPublic
+
+		for appId, permissions := range rule.Grants {
+			for _, permission := range permissions {
+				parsed, err := pubsub.ParsePermission(permission)
+				if err != nil {
+					return acl, err
+				}
+
+				if acl.Grants == nil {
+					acl.Grants = map[string][]pubsub.Permission{}
+				}
+				acl.Grants[appId] = append(acl.Grants[appId], parsed)
+			}
+		}
+	}
+
+	return acl, nil
+}
+
+// The category that all of an app's topics live under, i.e. `/app-topics/{app-id}`
+func AppTopicCategory(appId string) string {
+	return identity.Category("app-topics", appId)
 }
 
 func (app *CompiledApp) TopicId(category []string, key string) pubsub.TopicId {
@@ -245,3 +355,18 @@ func (app *CompiledApp) TopicId(category []string, key string) pubsub.TopicId {
 		Key:      key,
 	}
 }
+
+// The category of the processes spawned by an app, i.e. `/app/{app-id}`. This is not the
+// category of the app's daemon, which lives in `/app`.
+func AppProcessCategory(appId string) string {
+	return identity.Category("app", appId)
+}
+
+func (app *CompiledApp) AppProcessId(category []string, key string) process.ProcessId {
+	categoryParts := []string{"app", app.Id}
+	categoryParts = append(categoryParts, category...)
+	return process.ProcessId{
+		Category: identity.Category(categoryParts...),
+		Key:      key,
+	}
+}
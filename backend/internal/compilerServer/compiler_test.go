@@ -0,0 +1,124 @@
+package compilerServer
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
+)
+
+// The project path can only be set once per process, so every test shares one project
+var testProjectPath string
+
+func TestMain(m *testing.M) {
+	var err error
+	testProjectPath, err = os.MkdirTemp("", "robin-compiler-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	files := map[string]string{
+		"robin.json":         `{"name": "test", "apps": ["./app/robin.app.json"]}`,
+		"app/robin.app.json": `{"id": "durable-app", "name": "Durable app", "page": "page.tsx", "pageIcon": "💾"}`,
+	}
+	for path, contents := range files {
+		path = filepath.Join(testProjectPath, filepath.FromSlash(path))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(path, []byte(contents), 0644)
+		}
+	}
+	if err == nil {
+		_, err = project.SetProjectPath(testProjectPath)
+	}
+	if err == nil {
+		err = pubsub.Topics.RestoreDurableTopics(filepath.Join(testProjectPath, "topics"))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(testProjectPath)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testProjectPath)
+	os.Exit(code)
+}
+
+func readRetained(t *testing.T, topicId pubsub.TopicId) []any {
+	sub, err := pubsub.SubscribeAny(&pubsub.Topics, topicId, pubsub.SubscribeOptions{
+		Cursor: pubsub.Cursor{Last: 10},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	messages := []any{}
+	for {
+		select {
+		case message := <-sub.Out:
+			messages = append(messages, message.Data)
+		case <-time.After(50 * time.Millisecond):
+			return messages
+		}
+	}
+}
+
+func TestDurableAppTopicsSurviveRestarts(t *testing.T) {
+	compiler := &Compiler{}
+	defer compiler.RemoveApp("durable-app")
+
+	app, _, err := compiler.GetApp("durable-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	topicId := app.TopicId([]string{"events"}, "log")
+	opts := pubsub.TopicOptions{Durable: true}
+	topic, err := app.UpsertTopic(topicId, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic.Publish("before restart")
+
+	// Restarting the app resets its cache, which closes its topics
+	compiler.ResetAppCache(app.Id)
+	if !topic.IsClosed() {
+		t.Fatalf("expected the app's topics to be closed")
+	}
+
+	app, _, err = compiler.GetApp("durable-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.UpsertTopic(topicId, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := readRetained(t, topicId); len(messages) != 1 || messages[0] != "before restart" {
+		t.Fatalf("expected the durable message to survive a restart, got %v", messages)
+	}
+
+	// Removing the app is the only thing that deletes its durable messages
+	compiler.RemoveApp(app.Id)
+
+	app, _, err = compiler.GetApp("durable-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.UpsertTopic(topicId, opts); err != nil {
+		t.Fatal(err)
+	}
+
+	if messages := readRetained(t, topicId); len(messages) != 0 {
+		t.Fatalf("expected removing the app to delete its durable messages, got %v", messages)
+	}
+}
@@ -55,6 +55,9 @@ func (app *CompiledApp) keepAlive() {
 				logger.Warn("App server shutdown", log.Ctx{
 					"appId": app.Id,
 				})
+
+				// Nothing is going to publish to the app's topics anymore
+				app.closeTopics()
 				return
 			}
 		}
@@ -154,6 +157,13 @@ func (app *CompiledApp) setupCustomDaemon(appConfig project.RobinAppConfig, proc
 	processConfig.Command = appConfig.Daemon[0]
 	processConfig.Args = appConfig.Daemon[1:]
 
+	if appConfig.DaemonShell != nil {
+		processConfig.Shell = &process.ShellConfig{
+			Shell: appConfig.DaemonShell.Shell,
+			Login: appConfig.DaemonShell.Login,
+		}
+	}
+
 	return nil
 }
 
@@ -213,6 +223,7 @@ func (app *CompiledApp) StartServer() error {
 		WorkDir: appDir,
 		Env: map[string]string{
 			"ROBIN_APP_ID":       app.Id,
+			"ROBIN_APP_TOKEN":    AppToken(app.Id),
 			"ROBIN_PROCESS_TYPE": "daemon",
 			"ROBIN_PROJECT_PATH": projectPath,
 		},
@@ -302,6 +313,8 @@ func (app *CompiledApp) stopServer(w process.WHandle) error {
 	if err := w.Kill(app.ProcessId); err != nil && !errors.Is(err, process.ErrProcessNotFound) {
 		return err
 	}
+
+	app.closeTopics()
 	return nil
 }
 
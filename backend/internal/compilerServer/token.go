@@ -0,0 +1,79 @@
+package compilerServer
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"robinplatform.dev/internal/config"
+	"robinplatform.dev/internal/log"
+)
+
+// Apps identify themselves to robin with a token that robin gives them, instead of the app ID
+// they claim, which can't be trusted. Tokens are signed with a secret kept in robin's data
+// directory, so that daemons which outlive a restart of robin keep working.
+var (
+	tokenSecretOnce sync.Once
+	tokenSecret     []byte
+)
+
+func getTokenSecret() []byte {
+	tokenSecretOnce.Do(func() {
+		secretPath := filepath.Join(config.GetRobinPath(), "data", "app-token-secret")
+		if secret, err := os.ReadFile(secretPath); err == nil && len(secret) > 0 {
+			tokenSecret = secret
+			return
+		}
+
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			panic(err)
+		}
+		tokenSecret = secret
+
+		// Tokens still work without saving the secret, they just change when robin restarts
+		err := os.MkdirAll(filepath.Dir(secretPath), 0755)
+		if err == nil {
+			err = os.WriteFile(secretPath, secret, 0600)
+		}
+		if err != nil {
+			logger.Warn("Failed to save app token secret", log.Ctx{
+				"path": secretPath,
+				"err":  err.Error(),
+			})
+		}
+	})
+
+	return tokenSecret
+}
+
+func signAppId(appId string) string {
+	mac := hmac.New(sha256.New, getTokenSecret())
+	mac.Write([]byte(appId))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the token that identifies an app. Robin's own UI uses the token of the empty app ID.
+func AppToken(appId string) string {
+	return appId + "." + signAppId(appId)
+}
+
+// Returns the ID of the app that a token was issued to, which is empty for robin's own UI.
+func AppIdFromToken(token string) (string, bool) {
+	index := strings.LastIndexByte(token, '.')
+	if index < 0 {
+		return "", false
+	}
+
+	appId, signature := token[:index], token[index+1:]
+	if !hmac.Equal([]byte(signature), []byte(signAppId(appId))) {
+		return "", false
+	}
+
+	return appId, true
+}
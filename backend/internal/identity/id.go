@@ -16,6 +16,7 @@ type Id struct {
 	// - /app/{app-id} - the category for an app's spawned processes
 	// - /app - the category for the current project's spawned apps
 	// - /logs/{app-category} - logs for an app with a certain category
+	// - /processes - events from the process manager, like crash reports
 	// - /topics - meta category for information about topics
 	Category string `json:"category"`
 	// The identifier used to refer to an object. This is not cleaned, and has no
@@ -33,6 +34,20 @@ func (id Id) String() string {
 	)
 }
 
+// Parses an ID from the format returned by `Id.String()`.
+func Parse(id string) (Id, error) {
+	// Keys aren't escaped, so they can contain '#', but categories can't.
+	index := strings.IndexByte(id, '#')
+	if index < 0 {
+		return Id{}, fmt.Errorf("invalid id '%s': expected the format {category}#{key}", id)
+	}
+
+	return Id{
+		Category: id[:index],
+		Key:      id[index+1:],
+	}, nil
+}
+
 // Cleans inputs and then creates a category from them. If you have a valid category already,
 // ust path.Join to combine it with another category.
 func Category(ids ...string) string {
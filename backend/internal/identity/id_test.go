@@ -14,3 +14,20 @@ func TestCategoryFunc(t *testing.T) {
 	CategoryFuncTester(t, "/logs/apps/hello/world", "logs", "apps", "hello", "world")
 	CategoryFuncTester(t, "/logs/%2E%2E/apps/hello%2Fworld", "logs", "..", "apps", "hello/world")
 }
+
+func TestParse(t *testing.T) {
+	id := Id{Category: Category("app", "hello#world"), Key: "key#with#hashes"}
+
+	parsed, err := Parse(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if parsed != id {
+		t.Fatalf("failed to round trip id, got: %+v, expected: %+v", parsed, id)
+	}
+
+	if _, err := Parse("/no/key"); err == nil {
+		t.Fatalf("parsing an id without a key should have failed")
+	}
+}
@@ -0,0 +1,52 @@
+package model
+
+type ChangeKind string
+
+const (
+	ChangeInsert ChangeKind = "insert"
+	ChangeUpdate ChangeKind = "update"
+	ChangeDelete ChangeKind = "delete"
+)
+
+// StoreChange is published to `StoreOptions.Changes` after a change is saved. Updated rows
+// are published with their new values, and deleted rows with the values they had.
+type StoreChange[Model any] struct {
+	Kind ChangeKind `json:"kind"`
+	Rows []Model    `json:"rows"`
+}
+
+// Records the change that an operation makes. Deleted rows are read from `store.data`, so this
+// has to be called before the data is replaced. Requires the write lock.
+func (store *Store[Model]) recordChange(op storeOp[Model]) {
+	if store.changes == nil {
+		return
+	}
+
+	change := StoreChange[Model]{Rows: op.Rows}
+	switch op.Kind {
+	case opInsert:
+		change.Kind = ChangeInsert
+	case opSet:
+		change.Kind = ChangeUpdate
+	case opDelete:
+		change.Kind = ChangeDelete
+		change.Rows = make([]Model, 0, len(op.Indices))
+		for _, index := range op.Indices {
+			change.Rows = append(change.Rows, store.data[index])
+		}
+	}
+
+	store.pendingChanges = append(store.pendingChanges, change)
+}
+
+// Publishes the recorded changes once they're saved. Changes that are rolled back are
+// dropped before they get here. Requires the write lock, so that changes are published
+// in the order they were made.
+func (store *Store[Model]) publishChanges() {
+	pending := store.pendingChanges
+	store.pendingChanges = nil
+
+	for _, change := range pending {
+		store.changes.Publish(change)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package model
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+func lockFileHandle(file *os.File) error {
+	for {
+		err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX)
+		if !errors.Is(err, syscall.EINTR) {
+			return err
+		}
+	}
+}
+
+func unlockFileHandle(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,133 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"robinplatform.dev/internal/log"
+)
+
+// Migration upgrades a row from the previous version of a store's schema. Rows are passed
+// as they're encoded in JSON, since they might not fit in the current `Model` anymore.
+type Migration func(row map[string]any) (map[string]any, error)
+
+// Where the files are copied to before they're migrated from `version`
+func migrationBackupPath(path string, version int) string {
+	return fmt.Sprintf("%s.v%d.bak", path, version)
+}
+
+// Copies a file, if it exists
+func copyFile(from string, to string) error {
+	buf, err := os.ReadFile(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(to, buf)
+}
+
+// Runs the migrations after `version` on the rows
+func migrateRows(rows []json.RawMessage, version int, migrations []Migration) ([]json.RawMessage, error) {
+	out := make([]json.RawMessage, 0, len(rows))
+	for i, raw := range rows {
+		var row map[string]any
+		if err := json.Unmarshal(raw, &row); err != nil || row == nil {
+			return nil, fmt.Errorf("row %d isn't an object, so it can't be migrated", i)
+		}
+
+		for v := version; v < len(migrations); v++ {
+			var err error
+			row, err = migrations[v](row)
+			if err != nil {
+				return nil, fmt.Errorf("failed to migrate row %d to version %d: %w", i, v+1, err)
+			}
+		}
+
+		buf, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal migrated row %d: %w", i, err)
+		}
+		out = append(out, buf)
+	}
+
+	return out, nil
+}
+
+// Reads the files from scratch, migrating them if they were written with an older version
+// of the schema. Requires the write lock and the file lock.
+func (store *Store[Model]) reload() error {
+	raw := Store[json.RawMessage]{FilePath: store.FilePath}
+	if err := raw.loadSnapshot(); err != nil {
+		return err
+	}
+	if err := raw.replayLog(); err != nil {
+		return err
+	}
+
+	currentVersion := len(store.migrations)
+	fresh := raw.seq == 0 && raw.snapshotBytes == 0 && len(raw.data) == 0
+
+	rows := raw.data
+	migrated := false
+	switch {
+	case fresh:
+		// The version is recorded as soon as the store is created, so that the rows
+		// in the log aren't mistaken for rows from the first version later on
+		migrated = currentVersion > 0
+
+	case raw.version > currentVersion:
+		return fmt.Errorf("datastore has schema version %d, but only versions up to %d are supported; it was probably written by a newer version of robin", raw.version, currentVersion)
+
+	case raw.version < currentVersion:
+		// Migrated data is only written by compacting, so a backup of both files covers everything
+		backup := migrationBackupPath(store.FilePath, raw.version)
+		if err := copyFile(store.FilePath, backup); err != nil {
+			return fmt.Errorf("failed to back up datastore before migrating: %w", err)
+		}
+		if err := copyFile(logPath(store.FilePath), logPath(backup)); err != nil {
+			return fmt.Errorf("failed to back up datastore log before migrating: %w", err)
+		}
+
+		var err error
+		rows, err = migrateRows(rows, raw.version, store.migrations)
+		if err != nil {
+			return err
+		}
+
+		logger.Info("Migrated datastore", log.Ctx{
+			"path":   store.FilePath,
+			"from":   raw.version,
+			"to":     currentVersion,
+			"backup": backup,
+		})
+		migrated = true
+	}
+
+	data := make([]Model, len(rows))
+	for i, row := range rows {
+		if err := json.Unmarshal(row, &data[i]); err != nil {
+			return fmt.Errorf("failed to unmarshal datastore: %w", err)
+		}
+	}
+
+	store.data = data
+	store.version = currentVersion
+	store.seq = raw.seq
+	store.logEntries = raw.logEntries
+	store.logBytes = raw.logBytes
+	store.snapshotBytes = raw.snapshotBytes
+
+	if migrated {
+		if err := store.compact(); err != nil {
+			return err
+		}
+	}
+
+	store.rebuildIndexes()
+	store.recordFileState()
+	return nil
+}
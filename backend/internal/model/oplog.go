@@ -0,0 +1,228 @@
+package model
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"robinplatform.dev/internal/log"
+)
+
+// The log is compacted into the snapshot once it has at least this many entries, and has
+// grown bigger than the snapshot. This keeps the log from getting replayed over and over
+// for small stores, while bounding how much bigger than the data the files can get.
+const minCompactEntries = 100
+
+type opKind string
+
+const (
+	// Appends `Rows`
+	opInsert opKind = "insert"
+	// Removes the rows at `Indices`
+	opDelete opKind = "delete"
+	// Replaces the rows at `Indices` with the matching `Rows`
+	opSet opKind = "set"
+)
+
+// A single change to a store's data. Rows are referred to by their index, so an operation
+// only makes sense when applied to the data it was created from.
+type storeOp[Model any] struct {
+	Kind    opKind  `json:"op"`
+	Rows    []Model `json:"rows,omitempty"`
+	Indices []int   `json:"indices,omitempty"`
+
+	// The rows that a set replaces, if they were changed in place before the set was applied
+	prev []Model
+}
+
+func (op storeOp[Model]) apply(data []Model) ([]Model, error) {
+	for _, index := range op.Indices {
+		if index < 0 || index >= len(data) {
+			return nil, fmt.Errorf("row %d is out of range for %s with %d rows", index, op.Kind, len(data))
+		}
+	}
+
+	switch op.Kind {
+	case opInsert:
+		return append(data, op.Rows...), nil
+
+	case opDelete:
+		deleted := make(map[int]struct{}, len(op.Indices))
+		for _, index := range op.Indices {
+			deleted[index] = struct{}{}
+		}
+
+		out := make([]Model, 0, len(data)-len(deleted))
+		for i, row := range data {
+			if _, ok := deleted[i]; !ok {
+				out = append(out, row)
+			}
+		}
+		return out, nil
+
+	case opSet:
+		if len(op.Rows) != len(op.Indices) {
+			return nil, fmt.Errorf("set has %d rows for %d indices", len(op.Rows), len(op.Indices))
+		}
+
+		for i, index := range op.Indices {
+			data[index] = op.Rows[i]
+		}
+		return data, nil
+
+	default:
+		return nil, fmt.Errorf("unknown operation '%s'", op.Kind)
+	}
+}
+
+// A line of the log. Entries are numbered, so that entries that already made it into the
+// snapshot can be skipped, e.g. if robin crashed while compacting.
+type logEntry[Model any] struct {
+	Seq int64            `json:"seq"`
+	Ops []storeOp[Model] `json:"ops"`
+}
+
+// The compacted data. `Seq` is the last log entry that it includes, and `Version` is
+// the version of the schema that the data and the log were written with.
+type snapshot[Model any] struct {
+	Version int     `json:"version,omitempty"`
+	Seq     int64   `json:"seq"`
+	Data    []Model `json:"data"`
+}
+
+// Log entries are written as one JSON object per line, next to the snapshot
+func logPath(path string) string {
+	return path + ".log"
+}
+
+// Applies the entries in the log that come after the snapshot. Entries after one that
+// can't be applied are discarded, since they were based on data that's now missing.
+func (store *Store[Model]) replayLog() error {
+	file, err := os.Open(logPath(store.FilePath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open datastore log: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	var offset int64
+	var discardErr error
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			if len(bytes.TrimSpace(line)) > 0 {
+				discardErr = fmt.Errorf("last entry was only partially written")
+			}
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read datastore log: %w", err)
+		}
+
+		var entry logEntry[Model]
+		if err := json.Unmarshal(line, &entry); err != nil {
+			discardErr = err
+			break
+		}
+
+		if entry.Seq <= store.seq {
+			offset += int64(len(line))
+			continue
+		}
+
+		if entry.Seq != store.seq+1 {
+			discardErr = fmt.Errorf("expected entry %d, found %d", store.seq+1, entry.Seq)
+			break
+		}
+
+		data := store.data
+		for _, op := range entry.Ops {
+			if data, err = op.apply(data); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			discardErr = err
+			break
+		}
+
+		store.data = data
+		store.seq = entry.Seq
+		store.logEntries += 1
+		offset += int64(len(line))
+	}
+	store.logBytes = offset
+
+	if discardErr == nil {
+		return nil
+	}
+
+	logger.Warn("Discarding the unreadable end of a datastore log", log.Ctx{
+		"path": logPath(store.FilePath),
+		"err":  discardErr.Error(),
+	})
+
+	// Compacting drops the unreadable entries, so that new entries don't end up after them
+	return store.compact()
+}
+
+// Appends a log entry with the operations, compacting the log if it's grown big enough
+func (store *Store[Model]) persist(ops ...storeOp[Model]) error {
+	entry := logEntry[Model]{Seq: store.seq + 1, Ops: ops}
+	buf, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal datastore changes: %w", err)
+	}
+	buf = append(buf, '\n')
+
+	if err := appendFile(logPath(store.FilePath), buf, store.logBytes); err != nil {
+		return fmt.Errorf("failed to save datastore changes: %w", err)
+	}
+
+	store.seq = entry.Seq
+	store.logEntries += 1
+	store.logBytes += int64(len(buf))
+	store.logInfo = statFile(logPath(store.FilePath))
+
+	if store.logEntries >= minCompactEntries && store.logBytes >= store.snapshotBytes {
+		// The changes are already saved, so failing to compact isn't an error for the caller
+		if err := store.compact(); err != nil {
+			logger.Warn("Failed to compact datastore", log.Ctx{
+				"path": store.FilePath,
+				"err":  err.Error(),
+			})
+		}
+	}
+
+	return nil
+}
+
+// Writes all of the data to the snapshot and empties the log
+func (store *Store[Model]) compact() error {
+	buf, err := json.Marshal(snapshot[Model]{Version: store.version, Seq: store.seq, Data: store.data})
+	if err != nil {
+		return fmt.Errorf("failed to marshal datastore: %w", err)
+	}
+
+	if err := writeFileAtomic(store.FilePath, buf); err != nil {
+		return fmt.Errorf("failed to save datastore: %w", err)
+	}
+	store.snapshotBytes = int64(len(buf))
+
+	// If this fails, the entries are skipped when the log is replayed, since the
+	// snapshot already includes them
+	if err := os.Truncate(logPath(store.FilePath), 0); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to truncate datastore log: %w", err)
+	}
+	store.logEntries = 0
+	store.logBytes = 0
+	store.recordFileState()
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package model
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// The last version of the file that was successfully replaced by a newer one
+func backupPath(path string) string {
+	return path + ".bak"
+}
+
+// Where a file that couldn't be read is moved to, so that it can be inspected
+func corruptPath(path string) string {
+	return path + ".corrupt"
+}
+
+// Writes a file so that readers, including a robin that crashed mid-write and restarted,
+// either see the previous contents or the new ones, and never a partial write. The new
+// contents are written and synced to a temporary file, which then replaces the file.
+// The previous version is kept as a backup, in case the new one gets corrupted later.
+func writeFileAtomic(path string, buf []byte) error {
+	dir := filepath.Dir(path)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Temporary files are only readable by their owner by default
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if _, err := tmp.Write(buf); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Rename(path, backupPath(path)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to back up %s: %w", path, err)
+	}
+
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return err
+	}
+
+	syncDir(dir)
+	return nil
+}
+
+// Makes renames within a directory durable. This is best-effort, since directories can't be
+// synced on every platform, and the data itself has already been synced.
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	defer d.Close()
+
+	d.Sync()
+}
+
+// Removes temporary files left behind by writes that were interrupted
+func removeStaleTempFiles(path string) {
+	matches, err := filepath.Glob(filepath.Join(filepath.Dir(path), filepath.Base(path)+".tmp-*"))
+	if err != nil {
+		return
+	}
+
+	for _, match := range matches {
+		os.Remove(match)
+	}
+}
+
+// Appends to a file that's expected to be `size` bytes long, and syncs it. If the write
+// fails, the file is truncated back to its previous size, so that a partial write
+// doesn't end up in the middle of the file once later writes succeed.
+func appendFile(path string, buf []byte, size int64) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.Write(buf)
+	if err == nil {
+		err = file.Sync()
+	}
+	if err != nil {
+		file.Truncate(size)
+		file.Close()
+		return err
+	}
+
+	return file.Close()
+}
+
+// Stores lock this file while they read or write their data, so that robin processes
+// sharing a store don't interleave their writes
+func lockPath(path string) string {
+	return path + ".lock"
+}
+
+// An advisory lock on a file. It only keeps out other processes, and other stores in the
+// same process, that lock the same file.
+type fileLock struct {
+	file *os.File
+}
+
+// Waits until the file can be locked exclusively
+func lockFile(path string) (*fileLock, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFileHandle(file); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return &fileLock{file: file}, nil
+}
+
+func (lock *fileLock) unlock() {
+	if lock == nil {
+		return
+	}
+
+	unlockFileHandle(lock.file)
+	lock.file.Close()
+}
+
+// Returns nil if the file doesn't exist
+func statFile(path string) os.FileInfo {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil
+	}
+
+	return info
+}
+
+// Returns whether a file is unchanged. Replacing a file with a rename is
+// detected even if the new file has the same size and modification time.
+func sameFileState(a os.FileInfo, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	return os.SameFile(a, b) && a.Size() == b.Size() && a.ModTime().Equal(b.ModTime())
+}
@@ -0,0 +1,272 @@
+package model
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Query selects a subset of a store's rows with `FindAll`
+type Query[Model any] struct {
+	// Filter keeps the rows that it returns true for. Every row is kept if it's nil.
+	Filter func(row Model) bool
+	// Less sorts the rows. They're kept in the order they were inserted if it's nil.
+	Less func(a, b Model) bool
+	// Offset skips this many rows, after filtering and sorting
+	Offset int
+	// Limit caps the number of rows returned, if it's positive
+	Limit int
+}
+
+type storeIndex[Model any] struct {
+	key func(row Model) string
+	// The positions of the rows with each key, in ascending order
+	positions map[string][]int
+	// The key of the row at each position
+	keys []string
+}
+
+func (index *storeIndex[Model]) rebuild(data []Model) {
+	index.positions = make(map[string][]int, len(data))
+	index.keys = index.keys[:0]
+	index.inserted(data, 0)
+}
+
+// Adds the rows from position `from` onwards
+func (index *storeIndex[Model]) inserted(data []Model, from int) {
+	for i := from; i < len(data); i++ {
+		key := index.key(data[i])
+		index.keys = append(index.keys, key)
+		index.positions[key] = append(index.positions[key], i)
+	}
+}
+
+// Updates the key of the row at `position`
+func (index *storeIndex[Model]) set(position int, row Model) {
+	prev := index.keys[position]
+	key := index.key(row)
+	if key == prev {
+		return
+	}
+	index.keys[position] = key
+
+	positions := index.positions[prev]
+	at := sort.SearchInts(positions, position)
+	positions = append(positions[:at], positions[at+1:]...)
+	if len(positions) == 0 {
+		delete(index.positions, prev)
+	} else {
+		index.positions[prev] = positions
+	}
+
+	positions = index.positions[key]
+	at = sort.SearchInts(positions, position)
+	positions = append(positions, 0)
+	copy(positions[at+1:], positions[at:])
+	positions[at] = position
+	index.positions[key] = positions
+}
+
+// Applies an operation to the data, keeps the indexes up to date, and stages the operation
+// to be committed. Requires the write lock.
+func (store *Store[Model]) apply(op storeOp[Model]) error {
+	prev := store.data
+	prevLen := len(store.data)
+
+	var prevRows []Model
+	if op.Kind == opSet {
+		prevRows = op.prev
+		if prevRows == nil {
+			prevRows = make([]Model, 0, len(op.Indices))
+			for _, index := range op.Indices {
+				if index >= 0 && index < len(store.data) {
+					prevRows = append(prevRows, store.data[index])
+				}
+			}
+		}
+	}
+
+	data, err := op.apply(store.data)
+	if err != nil {
+		return err
+	}
+
+	// Deleted rows are still in the previous data at this point
+	store.recordChange(op)
+	store.data = data
+
+	switch op.Kind {
+	case opInsert:
+		store.undo = append(store.undo, func() {
+			store.data = store.data[:prevLen]
+		})
+	case opDelete:
+		// Deleting copies the rows that are kept, so the previous data is left as is
+		store.undo = append(store.undo, func() {
+			store.data = prev
+		})
+	case opSet:
+		store.undo = append(store.undo, func() {
+			for i, index := range op.Indices {
+				store.data[index] = prevRows[i]
+			}
+		})
+	}
+	store.staged = append(store.staged, op)
+
+	for _, index := range store.indexes {
+		switch op.Kind {
+		case opInsert:
+			index.inserted(store.data, prevLen)
+		case opDelete:
+			// Every row after a deleted one moves, so there's nothing to gain from updating in place
+			index.rebuild(store.data)
+		case opSet:
+			for i, position := range op.Indices {
+				index.set(position, op.Rows[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+func (store *Store[Model]) rebuildIndexes() {
+	for _, index := range store.indexes {
+		index.rebuild(store.data)
+	}
+}
+
+// Replaces every row that matches with the result of calling `mutator` on it, and
+// returns the number of rows that were updated. The mutator gets a copy of the row,
+// so rows that match are only written once the mutator is done with them.
+func (w *WHandle[Model]) Update(matcher func(row Model) bool, mutator func(row *Model)) (int, error) {
+	op := storeOp[Model]{Kind: opSet}
+	for i, row := range w.store.data {
+		if matcher(row) {
+			mutator(&row)
+			op.Indices = append(op.Indices, i)
+			op.Rows = append(op.Rows, row)
+		}
+	}
+
+	if len(op.Indices) == 0 {
+		return 0, nil
+	}
+
+	if err := w.store.apply(op); err != nil {
+		return 0, err
+	}
+
+	if err := w.store.commit(); err != nil {
+		return 0, err
+	}
+
+	return len(op.Indices), nil
+}
+
+// Replaces the first row that matches, or inserts the row if none do
+func (w *WHandle[Model]) Upsert(matcher func(row Model) bool, row Model) error {
+	op := storeOp[Model]{Kind: opInsert, Rows: []Model{row}}
+	for i, existing := range w.store.data {
+		if matcher(existing) {
+			op = storeOp[Model]{Kind: opSet, Rows: []Model{row}, Indices: []int{i}}
+			break
+		}
+	}
+
+	if err := w.store.apply(op); err != nil {
+		return err
+	}
+
+	return w.store.commit()
+}
+
+func (r *RHandle[Model]) FindAll(query Query[Model]) []Model {
+	out := make([]Model, 0)
+	for _, row := range r.store.data {
+		if query.Filter == nil || query.Filter(row) {
+			out = append(out, row)
+		}
+	}
+
+	if query.Less != nil {
+		sort.SliceStable(out, func(i, j int) bool {
+			return query.Less(out[i], out[j])
+		})
+	}
+
+	if query.Offset > 0 {
+		if query.Offset >= len(out) {
+			return out[:0]
+		}
+		out = out[query.Offset:]
+	}
+
+	if query.Limit > 0 && query.Limit < len(out) {
+		out = out[:query.Limit]
+	}
+
+	return out
+}
+
+func (w *WHandle[Model]) FindAll(query Query[Model]) []Model {
+	r := RHandle[Model]{w.store}
+	return r.FindAll(query)
+}
+
+func (store *Store[Model]) FindAll(query Query[Model]) []Model {
+	r := store.ReadHandle()
+	defer r.Close()
+
+	return r.FindAll(query)
+}
+
+func (store *Store[Model]) index(name string) *storeIndex[Model] {
+	index, ok := store.indexes[name]
+	if !ok {
+		// Indexes are declared up front, so this is a bug in the caller
+		panic(fmt.Sprintf("datastore %s has no index named '%s'", store.FilePath, name))
+	}
+
+	return index
+}
+
+// Returns the first row with the key in the index
+func (r *RHandle[Model]) FindByKey(index string, key string) (Model, bool) {
+	positions := r.store.index(index).positions[key]
+	if len(positions) == 0 {
+		var zero Model
+		return zero, false
+	}
+
+	return r.store.data[positions[0]], true
+}
+
+// Returns every row with the key in the index
+func (r *RHandle[Model]) FindAllByKey(index string, key string) []Model {
+	positions := r.store.index(index).positions[key]
+
+	out := make([]Model, 0, len(positions))
+	for _, position := range positions {
+		out = append(out, r.store.data[position])
+	}
+
+	return out
+}
+
+func (w *WHandle[Model]) FindByKey(index string, key string) (Model, bool) {
+	r := RHandle[Model]{w.store}
+	return r.FindByKey(index, key)
+}
+
+func (w *WHandle[Model]) FindAllByKey(index string, key string) []Model {
+	r := RHandle[Model]{w.store}
+	return r.FindAllByKey(index, key)
+}
+
+func (store *Store[Model]) FindByKey(index string, key string) (Model, bool) {
+	r := store.ReadHandle()
+	defer r.Close()
+
+	return r.FindByKey(index, key)
+}
@@ -1,24 +1,65 @@
 package model
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sync"
+
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/pubsub"
 )
 
+var logger log.Logger = log.New("model")
+
 // Store of information that gets loaded from disk on initialization and persisted to disk on write.
 // It provides the following promises:
 // - Modifications like insertions or mutations are persisted
-// - Data is persisted using JSON
-// - Data is ONLY read on initialization
+// - Data is persisted using JSON, as a snapshot and a log of the changes made since then
+// - Writes append the change to the log, which is compacted once it's as big as the snapshot
+// - Writes are atomic, so a crash never leaves a partially written change behind
+// - Failed writes are rolled back, and several writes can be made atomic with `Transaction`
+// - Writes lock the files, so that several robin processes can share a store
+// - Data is reloaded when another process has changed the files since they were last read
+// - The files record the version of the schema, and older files are migrated when they're read
 type Store[Model any] struct {
 	// FilePath is the path to the json file where the data should be stored.
 	FilePath string
 
 	data  []Model
 	rwMux *sync.RWMutex
+
+	// The last log entry that's been applied to `data`
+	seq int64
+	// The size of the files, used to decide when to compact
+	logEntries    int
+	logBytes      int64
+	snapshotBytes int64
+
+	// The state of the files when they were last read or written by this store
+	snapshotInfo os.FileInfo
+	logInfo      os.FileInfo
+
+	// Held from the time a write handle is created until it's closed
+	fileLock *fileLock
+
+	indexes map[string]*storeIndex[Model]
+
+	// The schema version is the number of migrations
+	migrations []Migration
+	version    int
+
+	changes        *pubsub.Topic[StoreChange[Model]]
+	pendingChanges []StoreChange[Model]
+
+	// Operations that have been applied but not committed yet, and how to undo them
+	staged  []storeOp[Model]
+	undo    []func()
+	txDepth int
 }
 
 type WHandle[Model any] struct {
@@ -37,36 +78,188 @@ func (store *Store[Model]) open() error {
 		return fmt.Errorf("failed to create datastore directory: %w", err)
 	}
 
-	buf, err := os.ReadFile(store.FilePath)
+	lock, err := lockFile(lockPath(store.FilePath))
+	if err != nil {
+		return fmt.Errorf("failed to lock datastore: %w", err)
+	}
+	defer lock.unlock()
+
+	removeStaleTempFiles(store.FilePath)
+
+	return store.reload()
+}
+
+// Remembers the state of the files after this store has read or written them
+func (store *Store[Model]) recordFileState() {
+	store.snapshotInfo = statFile(store.FilePath)
+	store.logInfo = statFile(logPath(store.FilePath))
+}
+
+// Returns whether another process has written to the files since this store last
+// read or wrote them
+func (store *Store[Model]) changedOnDisk() bool {
+	return !sameFileState(store.snapshotInfo, statFile(store.FilePath)) ||
+		!sameFileState(store.logInfo, statFile(logPath(store.FilePath)))
+}
+
+// Reloads the files if another process has changed them. Requires the write lock and the file lock.
+func (store *Store[Model]) catchUp() {
+	if !store.changedOnDisk() {
+		return
+	}
+
+	if err := store.reload(); err != nil {
+		logger.Warn("Failed to reload datastore after it was changed by another process", log.Ctx{
+			"path": store.FilePath,
+			"err":  err.Error(),
+		})
+	}
+}
+
+func (store *Store[Model]) loadSnapshot() error {
+	err := store.load(store.FilePath)
+	if err == nil {
+		return nil
+	}
+
 	if os.IsNotExist(err) {
+		// A crash between backing up the file and replacing it leaves only the backup behind
+		if err := store.load(backupPath(store.FilePath)); err == nil {
+			logger.Warn("Datastore was missing, restored it from its backup", log.Ctx{
+				"path": store.FilePath,
+			})
+		}
 		return nil
 	}
+
+	if _, isSyntaxErr := err.(*json.SyntaxError); !isSyntaxErr && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	// Keep the corrupt file around for inspection, without letting it replace the
+	// backup on the next write
+	if err := os.Rename(store.FilePath, corruptPath(store.FilePath)); err != nil {
+		return fmt.Errorf("failed to move corrupt datastore aside: %w", err)
+	}
+
+	if backupErr := store.load(backupPath(store.FilePath)); backupErr != nil {
+		store.data = nil
+		store.version = 0
+		store.seq = 0
+		logger.Warn("Datastore was corrupt and couldn't be restored from a backup, starting empty", log.Ctx{
+			"path":      store.FilePath,
+			"err":       err.Error(),
+			"backupErr": backupErr.Error(),
+		})
+		return nil
+	}
+
+	logger.Warn("Datastore was corrupt, restored it from its backup", log.Ctx{
+		"path": store.FilePath,
+		"err":  err.Error(),
+	})
+	return nil
+}
+
+// Reads the data from a file. Errors from reading the file are returned as is, so that
+// they can be checked with `os.IsNotExist`.
+func (store *Store[Model]) load(path string) error {
+	buf, err := os.ReadFile(path)
 	if err != nil {
-		return fmt.Errorf("failed to open datastore: %w", err)
+		return err
 	}
 
-	if err := json.Unmarshal(buf, &store.data); err != nil {
-		return fmt.Errorf("failed to unmarshal datastore: %w", err)
+	trimmed := bytes.TrimSpace(buf)
+	if len(trimmed) == 0 {
+		// Empty files are what `os.WriteFile` leaves behind when it's interrupted
+		return io.ErrUnexpectedEOF
 	}
 
+	var snap snapshot[Model]
+	if trimmed[0] == '[' {
+		// Stores used to be saved as a plain array, without a log
+		err = json.Unmarshal(buf, &snap.Data)
+	} else {
+		err = json.Unmarshal(buf, &snap)
+	}
+	if err != nil {
+		return err
+	}
+
+	store.data = snap.Data
+	store.version = snap.Version
+	store.seq = snap.Seq
+	store.snapshotBytes = int64(len(buf))
 	return nil
 }
 
+type StoreOptions[Model any] struct {
+	// Indexes are named functions that return a row's key, so that rows can be looked
+	// up by key in constant time with `FindByKey`. Keys don't have to be unique.
+	Indexes map[string]func(row Model) string
+
+	// Migrations upgrade rows that were written with older versions of the schema. The
+	// schema's version is the number of migrations, so migrations can only be appended.
+	// The files are backed up before they're migrated.
+	Migrations []Migration
+
+	// Changes is published to after every change that this store saves. Changes that
+	// other processes make to the files aren't published. Publishing happens while the
+	// store is locked, so subscribers that block slow down writes.
+	Changes *pubsub.Topic[StoreChange[Model]]
+}
+
 func NewStore[Model any](dbPath string) (Store[Model], error) {
+	return NewStoreWithOptions(dbPath, StoreOptions[Model]{})
+}
+
+func NewStoreWithOptions[Model any](dbPath string, opts StoreOptions[Model]) (Store[Model], error) {
 	store := Store[Model]{
-		FilePath: dbPath,
-		rwMux:    &sync.RWMutex{},
+		FilePath:   dbPath,
+		rwMux:      &sync.RWMutex{},
+		indexes:    make(map[string]*storeIndex[Model], len(opts.Indexes)),
+		migrations: opts.Migrations,
+		changes:    opts.Changes,
+	}
+	for name, key := range opts.Indexes {
+		store.indexes[name] = &storeIndex[Model]{key: key}
 	}
+
 	err := store.open()
 	return store, err
 }
 
+// Creates a write handle, which keeps other processes from writing to the store until it's
+// closed. Changes that other processes made before then are loaded first.
 func (store *Store[Model]) WriteHandle() WHandle[Model] {
 	store.rwMux.Lock()
+
+	lock, err := lockFile(lockPath(store.FilePath))
+	if err != nil {
+		// Writes still work without the lock, they just aren't safe from other processes
+		logger.Warn("Failed to lock datastore", log.Ctx{
+			"path": store.FilePath,
+			"err":  err.Error(),
+		})
+	}
+	store.fileLock = lock
+
+	store.catchUp()
 	return WHandle[Model]{store}
 }
 
+// Creates a read handle. If another process has changed the store, it's reloaded first.
 func (store *Store[Model]) ReadHandle() RHandle[Model] {
+	store.rwMux.RLock()
+	if !store.changedOnDisk() {
+		return RHandle[Model]{store}
+	}
+	store.rwMux.RUnlock()
+
+	// Reloading works like an empty write, so that it doesn't read a change while it's being written
+	w := store.WriteHandle()
+	w.Close()
+
 	store.rwMux.RLock()
 	return RHandle[Model]{store}
 }
@@ -79,6 +272,8 @@ func (w *WHandle[Model]) UncloseableReadHandle() RHandle[Model] {
 }
 
 func (w *WHandle[Model]) Close() {
+	w.store.fileLock.unlock()
+	w.store.fileLock = nil
 	w.store.rwMux.Unlock()
 }
 
@@ -87,9 +282,12 @@ func (r *RHandle[Model]) Close() {
 }
 
 func (w *WHandle[Model]) Insert(row Model) error {
-	w.store.data = append(w.store.data, row)
+	op := storeOp[Model]{Kind: opInsert, Rows: []Model{row}}
+	if err := w.store.apply(op); err != nil {
+		return err
+	}
 
-	return w.store.flush()
+	return w.store.commit()
 }
 
 func (w *WHandle[Model]) Find(matcher func(row Model) bool) (Model, bool) {
@@ -98,15 +296,22 @@ func (w *WHandle[Model]) Find(matcher func(row Model) bool) (Model, bool) {
 }
 
 func (w *WHandle[Model]) Delete(matcher func(row Model) bool) error {
-	data := make([]Model, 0, len(w.store.data))
-	for _, row := range w.store.data {
-		if !matcher(row) {
-			data = append(data, row)
+	op := storeOp[Model]{Kind: opDelete}
+	for i, row := range w.store.data {
+		if matcher(row) {
+			op.Indices = append(op.Indices, i)
 		}
 	}
-	w.store.data = data
 
-	return w.store.flush()
+	if len(op.Indices) == 0 {
+		return nil
+	}
+
+	if err := w.store.apply(op); err != nil {
+		return err
+	}
+
+	return w.store.commit()
 }
 
 func (r *RHandle[Model]) Find(matcher func(row Model) bool) (Model, bool) {
@@ -141,12 +346,34 @@ func (store *Store[Model]) ShallowCopyOutData() []Model {
 	return r.ShallowCopyOutData()
 }
 
+// Calls `f` on every row. Only the rows that `f` changes are written, which is
+// detected by comparing their JSON before and after.
 func (w *WHandle[Model]) ForEach(f func(*Model)) error {
+	op := storeOp[Model]{Kind: opSet}
 	for i := 0; i < len(w.store.data); i++ {
+		prev := w.store.data[i]
+		before, err := json.Marshal(prev)
+
 		f(&w.store.data[i])
+
+		after, afterErr := json.Marshal(w.store.data[i])
+		if err != nil || afterErr != nil || !bytes.Equal(before, after) {
+			op.Indices = append(op.Indices, i)
+			op.Rows = append(op.Rows, w.store.data[i])
+			op.prev = append(op.prev, prev)
+		}
+	}
+
+	if len(op.Indices) == 0 {
+		return nil
 	}
 
-	return w.store.flush()
+	// The rows were already changed in place, but their keys in the indexes weren't
+	if err := w.store.apply(op); err != nil {
+		return err
+	}
+
+	return w.store.commit()
 }
 
 func (store *Store[Model]) ForEachWriting(f func(*Model)) error {
@@ -157,16 +384,3 @@ func (store *Store[Model]) ForEachWriting(f func(*Model)) error {
 
 	return w.ForEach(f)
 }
-
-func (store *Store[_]) flush() error {
-	buf, err := json.Marshal(store.data)
-	if err != nil {
-		return fmt.Errorf("failed to marshal datastore: %w", err)
-	}
-
-	if err := os.WriteFile(store.FilePath, buf, 0666); err != nil {
-		return fmt.Errorf("failed to save datastore: %w", err)
-	}
-
-	return nil
-}
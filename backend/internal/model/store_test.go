@@ -1,8 +1,16 @@
 package model
 
 import (
+	"fmt"
+	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"robinplatform.dev/internal/pubsub"
 )
 
 func TestStoreWrite(t *testing.T) {
@@ -85,3 +93,567 @@ func TestStoreWrite(t *testing.T) {
 		w.Close()
 	}
 }
+
+func TestStoreRecoversFromCorruptFile(t *testing.T) {
+	type Data struct {
+		Id string
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test")
+
+	db, err := NewStore[Data](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Compacting after each insert leaves the second row in the snapshot, and the first in its backup
+	for _, id := range []string{"first", "second"} {
+		w := db.WriteHandle()
+		if err := w.Insert(Data{Id: id}); err != nil {
+			t.Fatal(err)
+		}
+		if err := db.compact(); err != nil {
+			t.Fatal(err)
+		}
+		w.Close()
+	}
+
+	// Simulate a write that got cut off halfway through
+	if err := os.WriteFile(path, []byte(`[{"Id":"fir`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewStore[Data](path)
+	if err != nil {
+		t.Fatalf("failed to recover from corrupt file: %s", err)
+	}
+
+	data := db.ShallowCopyOutData()
+	if len(data) != 1 || data[0].Id != "first" {
+		t.Fatalf("expected the backup to be restored, got %v", data)
+	}
+
+	if _, err := os.Stat(corruptPath(path)); err != nil {
+		t.Fatalf("corrupt file wasn't kept: %s", err)
+	}
+
+	// Without a backup, the store starts out empty
+	os.Remove(backupPath(path))
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewStore[Data](path)
+	if err != nil {
+		t.Fatalf("failed to recover from empty file: %s", err)
+	}
+
+	if data := db.ShallowCopyOutData(); len(data) != 0 {
+		t.Fatalf("expected an empty store, got %v", data)
+	}
+}
+
+func TestStoreLog(t *testing.T) {
+	type Data struct {
+		Id    string
+		Count int
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "test")
+
+	db, err := NewStore[Data](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.WriteHandle()
+	for i := 0; i < 10; i++ {
+		if err := w.Insert(Data{Id: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Delete(func(row Data) bool { return row.Id == "3" || row.Id == "7" }); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ForEach(func(row *Data) {
+		if row.Id == "5" {
+			row.Count = 5
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	expected := db.ShallowCopyOutData()
+	if len(expected) != 8 {
+		t.Fatalf("expected 8 rows, got %v", expected)
+	}
+
+	// Only changes are written, and nothing has been compacted yet
+	logBuf, err := os.ReadFile(logPath(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(string(logBuf), "\n"); lines != 12 {
+		t.Fatalf("expected 12 log entries, got %d", lines)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected no snapshot before compacting")
+	}
+
+	// A crash in the middle of appending leaves a partial entry behind, which gets discarded
+	if err := os.WriteFile(logPath(path), append(logBuf, []byte(`{"seq":13,"ops":[{"op":"ins`)...), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	db, err = NewStore[Data](path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %s", err)
+	}
+
+	if data := db.ShallowCopyOutData(); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("expected %v after replaying the log, got %v", expected, data)
+	}
+
+	// Writes after the discarded entry are kept
+	w = db.WriteHandle()
+	for i := 0; i < minCompactEntries; i++ {
+		if err := w.ForEach(func(row *Data) { row.Count += 1 }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	if db.logEntries >= minCompactEntries {
+		t.Fatalf("expected the log to be compacted, but it has %d entries", db.logEntries)
+	}
+
+	expected = db.ShallowCopyOutData()
+	db, err = NewStore[Data](path)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %s", err)
+	}
+
+	if data := db.ShallowCopyOutData(); !reflect.DeepEqual(data, expected) {
+		t.Fatalf("expected %v after compacting, got %v", expected, data)
+	}
+}
+
+func TestStoreSharedBetweenProcesses(t *testing.T) {
+	type Data struct {
+		Id string
+	}
+
+	path := filepath.Join(t.TempDir(), "test")
+
+	// Each store locks the file separately, the same way that two robin processes would
+	first, err := NewStore[Data](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewStore[Data](path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, store := range []*Store[Data]{&first, &second} {
+		store := store
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func(worker int) {
+				defer wg.Done()
+
+				for j := 0; j < 50; j++ {
+					w := store.WriteHandle()
+					if err := w.Insert(Data{Id: fmt.Sprintf("%p-%d-%d", store, worker, j)}); err != nil {
+						t.Error(err)
+					}
+					w.Close()
+				}
+			}(i)
+		}
+	}
+	wg.Wait()
+
+	// Both stores see every write, including writes that were compacted by the other store
+	for _, store := range []*Store[Data]{&first, &second} {
+		data := store.ShallowCopyOutData()
+		if len(data) != 400 {
+			t.Fatalf("expected 400 rows, got %d", len(data))
+		}
+
+		seen := make(map[string]bool, len(data))
+		for _, row := range data {
+			if seen[row.Id] {
+				t.Fatalf("row %s was written twice", row.Id)
+			}
+			seen[row.Id] = true
+		}
+	}
+
+	w := first.WriteHandle()
+	if err := w.Delete(func(row Data) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	if _, found := second.Find(func(row Data) bool { return true }); found {
+		t.Fatalf("found a row that was deleted by the other store")
+	}
+}
+
+func TestStoreQueries(t *testing.T) {
+	type Data struct {
+		Id    string
+		Group string
+		Count int
+	}
+
+	path := filepath.Join(t.TempDir(), "test")
+	opts := StoreOptions[Data]{
+		Indexes: map[string]func(row Data) string{
+			"id":    func(row Data) string { return row.Id },
+			"group": func(row Data) string { return row.Group },
+		},
+	}
+
+	db, err := NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ids := func(rows []Data) []string {
+		out := []string{}
+		for _, row := range rows {
+			out = append(out, row.Id)
+		}
+		return out
+	}
+
+	w := db.WriteHandle()
+	for i := 0; i < 6; i++ {
+		group := "even"
+		if i%2 == 1 {
+			group = "odd"
+		}
+		if err := w.Upsert(func(row Data) bool { return false }, Data{Id: fmt.Sprint(i), Group: group, Count: 10 - i}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updated, err := w.Update(func(row Data) bool { return row.Group == "odd" }, func(row *Data) { row.Count *= 10 })
+	if err != nil || updated != 3 {
+		t.Fatalf("expected 3 rows to be updated, got %d (err: %v)", updated, err)
+	}
+
+	if err := w.Upsert(func(row Data) bool { return row.Id == "2" }, Data{Id: "2", Group: "odd", Count: 0}); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Delete(func(row Data) bool { return row.Id == "0" }); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	query := Query[Data]{
+		Filter: func(row Data) bool { return row.Group == "odd" },
+		Less:   func(a, b Data) bool { return a.Count < b.Count },
+	}
+	if got := ids(db.FindAll(query)); !reflect.DeepEqual(got, []string{"2", "5", "3", "1"}) {
+		t.Fatalf("unexpected query result %v", got)
+	}
+
+	query.Offset = 1
+	query.Limit = 2
+	if got := ids(db.FindAll(query)); !reflect.DeepEqual(got, []string{"5", "3"}) {
+		t.Fatalf("unexpected paginated query result %v", got)
+	}
+
+	if got := ids(db.FindAll(Query[Data]{Offset: 10})); len(got) != 0 {
+		t.Fatalf("expected no rows past the end, got %v", got)
+	}
+
+	checkIndexes := func(db *Store[Data]) {
+		t.Helper()
+
+		r := db.ReadHandle()
+		defer r.Close()
+
+		if row, found := r.FindByKey("id", "3"); !found || row.Count != 70 {
+			t.Fatalf("expected to find row 3 by its ID, got %v (found: %v)", row, found)
+		}
+		if _, found := r.FindByKey("id", "0"); found {
+			t.Fatalf("found a deleted row by its ID")
+		}
+		if got := ids(r.FindAllByKey("group", "odd")); !reflect.DeepEqual(got, []string{"1", "2", "3", "5"}) {
+			t.Fatalf("unexpected rows in the odd group %v", got)
+		}
+		if got := ids(r.FindAllByKey("group", "even")); !reflect.DeepEqual(got, []string{"4"}) {
+			t.Fatalf("unexpected rows in the even group %v", got)
+		}
+	}
+
+	checkIndexes(&db)
+
+	// Indexes are rebuilt when the store is loaded
+	db, err = NewStoreWithOptions(path, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkIndexes(&db)
+}
+
+func TestStoreMigrations(t *testing.T) {
+	type DataV1 struct {
+		Id   string
+		Name string
+	}
+
+	type DataV2 struct {
+		Id       string
+		Greeting string
+	}
+
+	path := filepath.Join(t.TempDir(), "test")
+
+	v1 := []Migration{
+		func(row map[string]any) (map[string]any, error) {
+			row["Name"] = row["Id"]
+			return row, nil
+		},
+	}
+	v2 := append(v1, func(row map[string]any) (map[string]any, error) {
+		row["Greeting"] = fmt.Sprintf("hello %s", row["Name"])
+		delete(row, "Name")
+		return row, nil
+	})
+
+	// New stores start out at the latest version, so their rows aren't migrated again
+	db, err := NewStoreWithOptions(path, StoreOptions[DataV1]{Migrations: v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.WriteHandle()
+	for _, id := range []string{"a", "b"} {
+		if err := w.Insert(DataV1{Id: id, Name: "name " + id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	w.Close()
+
+	db, err = NewStoreWithOptions(path, StoreOptions[DataV1]{Migrations: v1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := db.ShallowCopyOutData(); !reflect.DeepEqual(data, []DataV1{{"a", "name a"}, {"b", "name b"}}) {
+		t.Fatalf("rows were changed without a new migration: %v", data)
+	}
+
+	upgraded, err := NewStoreWithOptions(path, StoreOptions[DataV2]{Migrations: v2})
+	if err != nil {
+		t.Fatalf("failed to migrate: %s", err)
+	}
+	if data := upgraded.ShallowCopyOutData(); !reflect.DeepEqual(data, []DataV2{{"a", "hello name a"}, {"b", "hello name b"}}) {
+		t.Fatalf("unexpected migrated rows: %v", data)
+	}
+
+	// The old files are kept, and the migrated data doesn't need to be migrated again
+	if _, err := os.Stat(migrationBackupPath(path, 1)); err != nil {
+		t.Fatalf("files weren't backed up before migrating: %s", err)
+	}
+
+	upgraded, err = NewStoreWithOptions(path, StoreOptions[DataV2]{Migrations: v2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data := upgraded.ShallowCopyOutData(); len(data) != 2 || data[0].Greeting != "hello name a" {
+		t.Fatalf("unexpected rows after reopening: %v", data)
+	}
+
+	// Older versions of robin can't read the new data
+	if _, err := NewStoreWithOptions(path, StoreOptions[DataV1]{Migrations: v1}); err == nil {
+		t.Fatalf("expected opening a newer store to fail")
+	}
+}
+
+func TestStoreChanges(t *testing.T) {
+	type Data struct {
+		Id    string
+		Count int
+	}
+
+	path := filepath.Join(t.TempDir(), "test")
+
+	registry := &pubsub.Registry{}
+	topic, err := pubsub.CreateTopic[StoreChange[Data]](registry, pubsub.TopicId{Category: "/test", Key: "changes"}, pubsub.TopicOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub, err := pubsub.Subscribe[StoreChange[Data]](registry, topic.Id, pubsub.SubscribeOptions{BufferSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Unsubscribe()
+
+	db, err := NewStoreWithOptions(path, StoreOptions[Data]{Changes: topic})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.WriteHandle()
+	w.Insert(Data{Id: "a"})
+	w.Insert(Data{Id: "b"})
+	w.Update(func(row Data) bool { return row.Id == "a" }, func(row *Data) { row.Count = 1 })
+	w.Delete(func(row Data) bool { return true })
+
+	// Changes that fail to save aren't published
+	if err := os.Mkdir(logPath(path)+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(logPath(path), logPath(path)+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(logPath(path)+".tmp", logPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(Data{Id: "c"}); err == nil {
+		t.Fatalf("expected insert to fail when the log can't be written")
+	}
+	w.Close()
+
+	expected := []StoreChange[Data]{
+		{Kind: ChangeInsert, Rows: []Data{{Id: "a"}}},
+		{Kind: ChangeInsert, Rows: []Data{{Id: "b"}}},
+		{Kind: ChangeUpdate, Rows: []Data{{Id: "a", Count: 1}}},
+		{Kind: ChangeDelete, Rows: []Data{{Id: "a", Count: 1}, {Id: "b"}}},
+	}
+	for _, change := range expected {
+		select {
+		case message := <-sub.Out:
+			if !reflect.DeepEqual(message.Data, change) {
+				t.Fatalf("expected change %v, got %v", change, message.Data)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for change %v", change)
+		}
+	}
+
+	select {
+	case message := <-sub.Out:
+		t.Fatalf("expected no more changes, got %v", message.Data)
+	default:
+	}
+}
+
+func TestStoreTransactions(t *testing.T) {
+	type Data struct {
+		Id    string
+		Count int
+	}
+
+	path := filepath.Join(t.TempDir(), "test")
+	db, err := NewStoreWithOptions(path, StoreOptions[Data]{
+		Indexes: map[string]func(row Data) string{
+			"id": func(row Data) string { return row.Id },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := db.WriteHandle()
+	defer w.Close()
+
+	// Everything in a transaction is saved as a single log entry
+	err = w.Transaction(func(tx *WHandle[Data]) error {
+		if err := tx.Insert(Data{Id: "a"}); err != nil {
+			return err
+		}
+		if err := tx.Insert(Data{Id: "b"}); err != nil {
+			return err
+		}
+		_, err := tx.Update(func(row Data) bool { return row.Id == "a" }, func(row *Data) { row.Count = 1 })
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.logEntries != 1 {
+		t.Fatalf("expected the transaction to be saved as 1 log entry, got %d", db.logEntries)
+	}
+
+	expected := []Data{{Id: "a", Count: 1}, {Id: "b"}}
+	check := func(when string) {
+		t.Helper()
+
+		if data := w.FindAll(Query[Data]{}); !reflect.DeepEqual(data, expected) {
+			t.Fatalf("expected %v %s, got %v", expected, when, data)
+		}
+		if _, found := w.FindByKey("id", "c"); found {
+			t.Fatalf("index wasn't rolled back %s", when)
+		}
+		if row, found := w.FindByKey("id", "a"); !found || row.Count != 1 {
+			t.Fatalf("index wasn't rolled back %s, got %v", when, row)
+		}
+	}
+
+	err = w.Transaction(func(tx *WHandle[Data]) error {
+		tx.Insert(Data{Id: "c"})
+		tx.Delete(func(row Data) bool { return row.Id == "a" })
+		tx.Upsert(func(row Data) bool { return row.Id == "b" }, Data{Id: "b", Count: 2})
+		return fmt.Errorf("nope")
+	})
+	if err == nil || err.Error() != "nope" {
+		t.Fatalf("expected the transaction's error, got %v", err)
+	}
+	check("after an error")
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("expected the panic to be passed on")
+			}
+		}()
+
+		w.Transaction(func(tx *WHandle[Data]) error {
+			tx.Insert(Data{Id: "c"})
+			tx.ForEach(func(row *Data) { row.Count += 10 })
+			panic("oh no")
+		})
+	}()
+	check("after a panic")
+
+	// A nested transaction that fails doesn't stop the outer one from being committed
+	err = w.Transaction(func(tx *WHandle[Data]) error {
+		tx.Insert(Data{Id: "d"})
+		tx.Transaction(func(tx *WHandle[Data]) error {
+			tx.Insert(Data{Id: "c"})
+			return fmt.Errorf("nope")
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = append(expected, Data{Id: "d"})
+	check("after a nested transaction")
+
+	// Writes that fail to save are rolled back
+	if err := os.Remove(logPath(path)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(logPath(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(Data{Id: "c"}); err == nil {
+		t.Fatalf("expected insert to fail when the log can't be written")
+	}
+	if _, err := w.Update(func(row Data) bool { return true }, func(row *Data) { row.Count = 100 }); err == nil {
+		t.Fatalf("expected update to fail when the log can't be written")
+	}
+	check("after failing to save")
+}
@@ -0,0 +1,86 @@
+package model
+
+// Changes are applied to the data as soon as they're made, so that reads see them, but
+// they're only saved once they're committed. Each change records how to undo it until then,
+// so that the data can be rolled back if saving fails.
+
+// The number of staged changes at some point, which can be rolled back to
+type txMark struct {
+	undo    int
+	staged  int
+	changes int
+}
+
+// Requires the write lock
+func (store *Store[Model]) mark() txMark {
+	return txMark{
+		undo:    len(store.undo),
+		staged:  len(store.staged),
+		changes: len(store.pendingChanges),
+	}
+}
+
+// Undoes the changes staged since the mark. Requires the write lock.
+func (store *Store[Model]) rollbackTo(mark txMark) {
+	for i := len(store.undo) - 1; i >= mark.undo; i-- {
+		store.undo[i]()
+	}
+
+	store.undo = store.undo[:mark.undo]
+	store.staged = store.staged[:mark.staged]
+	store.pendingChanges = store.pendingChanges[:mark.changes]
+
+	store.rebuildIndexes()
+}
+
+// Saves the staged changes as a single log entry, unless a transaction is still running.
+// If saving fails, the changes are rolled back. Requires the write lock.
+func (store *Store[Model]) commit() error {
+	if store.txDepth > 0 || len(store.staged) == 0 {
+		return nil
+	}
+
+	if err := store.persist(store.staged...); err != nil {
+		store.rollbackTo(txMark{})
+		return err
+	}
+
+	store.undo = nil
+	store.staged = nil
+	store.publishChanges()
+
+	return nil
+}
+
+// Transaction runs `f`, and saves the changes that it makes through `tx` all at once. If `f`
+// returns an error or panics, or saving fails, none of the changes are kept, and the data is
+// rolled back to what it was before.
+//
+// Rows that `ForEach` changed are restored to shallow copies of their previous values, so
+// changes to maps, slices or pointers inside of a row aren't undone.
+//
+// Transactions can be nested. If a nested transaction fails, only its own changes are rolled
+// back, and the outer transaction can still be committed.
+func (w *WHandle[Model]) Transaction(f func(tx *WHandle[Model]) error) error {
+	store := w.store
+	mark := store.mark()
+
+	store.txDepth += 1
+	defer func() {
+		if p := recover(); p != nil {
+			store.txDepth -= 1
+			store.rollbackTo(mark)
+			panic(p)
+		}
+	}()
+
+	err := f(w)
+	store.txDepth -= 1
+
+	if err != nil {
+		store.rollbackTo(mark)
+		return err
+	}
+
+	return store.commit()
+}
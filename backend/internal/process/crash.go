@@ -0,0 +1,183 @@
+package process
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/pubsub"
+)
+
+const (
+	// The number of lines at the end of a process's logs that get saved in its crash report
+	crashReportLogLines = 100
+	// The maximum number of bytes read from the end of a log file when looking for those lines
+	crashReportMaxLogBytes = 64 * 1024
+	// The maximum number of crash reports kept on disk. The oldest reports are discarded first.
+	maxCrashReports = 100
+)
+
+var (
+	// Every crash report is published to this topic after it is saved
+	CrashReportsTopicId = pubsub.TopicId{Category: "/processes", Key: "crash-reports"}
+)
+
+// A snapshot of a process that exited abnormally, i.e. with a non-zero exit code or
+// because of a signal that robin didn't send.
+type CrashReport struct {
+	ProcessId ProcessId     `json:"processId"`
+	Pid       int           `json:"pid"`
+	StartedAt time.Time     `json:"startedAt"`
+	ExitedAt  time.Time     `json:"exitedAt"`
+	Uptime    time.Duration `json:"uptime"`
+
+	// ExitCode is -1 if the process was terminated by a signal
+	ExitCode int `json:"exitCode"`
+	// ExitStatus is a human readable description of how the process exited,
+	// e.g. "exit status 1" or "signal: segmentation fault"
+	ExitStatus string `json:"exitStatus"`
+
+	// The last few lines of the process's logs, oldest first
+	Logs []string `json:"logs"`
+
+	Config ProcessConfig `json:"config"`
+}
+
+func (m *ProcessManager) recordCrash(config ProcessConfig, pid int, startedAt time.Time, state *os.ProcessState) {
+	exitedAt := time.Now()
+
+	// If the entry is gone or belongs to another PID, the process was killed through
+	// robin, which is not a crash.
+	r := m.ReadHandle()
+	proc, found := r.FindById(config.Id)
+	r.Close()
+
+	if !found || proc.Pid != pid {
+		return
+	}
+
+	logs, err := readLogTail(m.getLogFilePath(config.Id), crashReportLogLines)
+	if err != nil {
+		logger.Warn("Failed to read logs for crash report", log.Ctx{
+			"processId": config.Id,
+			"err":       err.Error(),
+		})
+	}
+
+	report := CrashReport{
+		ProcessId:  config.Id,
+		Pid:        pid,
+		StartedAt:  startedAt,
+		ExitedAt:   exitedAt,
+		Uptime:     exitedAt.Sub(startedAt),
+		ExitCode:   state.ExitCode(),
+		ExitStatus: state.String(),
+		Logs:       logs,
+		Config:     config,
+	}
+
+	logger.Warn("Process crashed", log.Ctx{
+		"processId":  report.ProcessId,
+		"pid":        report.Pid,
+		"exitStatus": report.ExitStatus,
+	})
+
+	if err := m.saveCrashReport(report); err != nil {
+		logger.Err("Failed to save crash report", log.Ctx{
+			"processId": config.Id,
+			"err":       err.Error(),
+		})
+	}
+
+	m.crashTopic.Publish(report)
+}
+
+func (m *ProcessManager) saveCrashReport(report CrashReport) error {
+	w := m.crashDb.WriteHandle()
+	defer w.Close()
+
+	if err := w.Insert(report); err != nil {
+		return err
+	}
+
+	r := w.UncloseableReadHandle()
+	reports := r.ShallowCopyOutData()
+	if len(reports) <= maxCrashReports {
+		return nil
+	}
+
+	// Reports are inserted in the order that processes exit, so anything
+	// before the cutoff is older than the reports we want to keep
+	cutoff := reports[len(reports)-maxCrashReports].ExitedAt
+	return w.Delete(func(row CrashReport) bool {
+		return row.ExitedAt.Before(cutoff)
+	})
+}
+
+// Returns the crash reports for the process with the given ID, oldest first. If the ID
+// is empty, the crash reports for all processes are returned.
+func (m *ProcessManager) GetCrashReports(id ProcessId) []CrashReport {
+	r := m.crashDb.ReadHandle()
+	defer r.Close()
+
+	reports := r.ShallowCopyOutData()
+	if id == (ProcessId{}) {
+		return reports
+	}
+
+	out := make([]CrashReport, 0, len(reports))
+	for _, report := range reports {
+		if report.ProcessId == id {
+			out = append(out, report)
+		}
+	}
+
+	return out
+}
+
+// Reads up to the last `count` lines of the file at `path`. Only the last
+// `crashReportMaxLogBytes` bytes of the file are considered.
+func readLogTail(path string, count int) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size() - crashReportMaxLogBytes
+	if offset < 0 {
+		offset = 0
+	}
+
+	buf := make([]byte, info.Size()-offset)
+	if _, err := file.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	// If we started reading in the middle of the file, the first line is probably partial
+	if offset > 0 {
+		if index := bytes.IndexByte(buf, '\n'); index >= 0 {
+			buf = buf[index+1:]
+		}
+	}
+
+	text := strings.TrimRight(string(buf), "\n")
+	if text == "" {
+		return []string{}, nil
+	}
+
+	lines := strings.Split(text, "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+
+	return lines, nil
+}
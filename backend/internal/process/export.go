@@ -0,0 +1,240 @@
+package process
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type LogArchiveFormat string
+
+const (
+	LogArchiveTarGz LogArchiveFormat = "tar.gz"
+	LogArchiveZip   LogArchiveFormat = "zip"
+)
+
+func ParseLogArchiveFormat(format string) (LogArchiveFormat, error) {
+	switch LogArchiveFormat(format) {
+	case "", LogArchiveTarGz:
+		return LogArchiveTarGz, nil
+	case LogArchiveZip:
+		return LogArchiveZip, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format '%s' (expected tar.gz or zip)", format)
+	}
+}
+
+type LogArchiveOptions struct {
+	// ProcessIds are the processes to include in the archive. If both this and `Categories`
+	// are empty, every process is included.
+	ProcessIds []ProcessId
+	// Categories are the categories to include in the archive, along with their sub-categories.
+	Categories []string
+
+	Format LogArchiveFormat
+}
+
+func (opts *LogArchiveOptions) includes(id ProcessId) bool {
+	if len(opts.ProcessIds) == 0 && len(opts.Categories) == 0 {
+		return true
+	}
+
+	for _, processId := range opts.ProcessIds {
+		if processId == id {
+			return true
+		}
+	}
+
+	for _, category := range opts.Categories {
+		if id.IsInCategory(category) {
+			return true
+		}
+	}
+
+	return false
+}
+
+type logArchiveManifest struct {
+	CreatedAt time.Time                 `json:"createdAt"`
+	Processes []logArchiveManifestEntry `json:"processes"`
+}
+
+type logArchiveManifestEntry struct {
+	Process
+	// Paths of this process's log files inside the archive, newest first
+	LogFiles []string `json:"logFiles"`
+}
+
+var secretNamePattern = regexp.MustCompile(`(?i)(secret|token|passw|pwd|key|auth|credential|cookie|session|private)`)
+
+const redactedValue = "[REDACTED]"
+
+// Matches `name=value` words in a shell command, e.g. `API_TOKEN=abc` or `--password="a b"`
+var shellAssignmentPattern = regexp.MustCompile(`([^\s=;&|'"]+)=('[^']*'|"[^"]*"|[^\s;&|]*)`)
+
+// Redacts env vars and `name=value` arguments whose names look like they hold secrets. Shell
+// commands are scripts that can set their own secrets, so the same goes for their words.
+// The process must already be a copy, since its env and args are modified in place.
+func redactProcess(proc *Process) {
+	if proc.Shell != nil {
+		proc.Command = shellAssignmentPattern.ReplaceAllStringFunc(proc.Command, func(word string) string {
+			name, _, _ := strings.Cut(word, "=")
+			if secretNamePattern.MatchString(name) {
+				return name + "=" + redactedValue
+			}
+			return word
+		})
+	}
+
+	for name := range proc.Env {
+		if secretNamePattern.MatchString(name) {
+			proc.Env[name] = redactedValue
+		}
+	}
+
+	for i, arg := range proc.Args {
+		name, _, found := strings.Cut(arg, "=")
+		if found && secretNamePattern.MatchString(name) {
+			proc.Args[i] = name + "=" + redactedValue
+		}
+	}
+}
+
+// Writes the log files (including backups) of the selected processes into an archive, along
+// with a `manifest.json` containing the process records with their secrets redacted.
+func (m *ProcessManager) WriteLogArchive(out io.Writer, opts LogArchiveOptions) error {
+	var archive archiveWriter
+	switch opts.Format {
+	case LogArchiveTarGz:
+		gz := gzip.NewWriter(out)
+		archive = &tarGzArchiveWriter{gz: gz, tw: tar.NewWriter(gz)}
+	case LogArchiveZip:
+		archive = &zipArchiveWriter{zw: zip.NewWriter(out)}
+	default:
+		return fmt.Errorf("unsupported archive format '%s'", opts.Format)
+	}
+
+	manifest := logArchiveManifest{
+		CreatedAt: time.Now(),
+		Processes: []logArchiveManifestEntry{},
+	}
+
+	for _, proc := range m.CopyOutData() {
+		if !opts.includes(proc.Id) {
+			continue
+		}
+
+		redactProcess(&proc)
+		entry := logArchiveManifestEntry{Process: proc, LogFiles: []string{}}
+
+		for _, logPath := range m.getLogFilePaths(proc.Id) {
+			relPath, err := filepath.Rel(m.processLogsFolderPath, logPath)
+			if err != nil {
+				return fmt.Errorf("failed to archive logs of %s: %w", proc.Id, err)
+			}
+
+			archivePath := path.Join("logs", filepath.ToSlash(relPath))
+			if err := writeFileToArchive(archive, archivePath, logPath); err != nil {
+				return fmt.Errorf("failed to archive logs of %s: %w", proc.Id, err)
+			}
+
+			entry.LogFiles = append(entry.LogFiles, archivePath)
+		}
+
+		manifest.Processes = append(manifest.Processes, entry)
+	}
+
+	buf, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("failed to marshal log archive manifest: %w", err)
+	}
+
+	if err := archive.WriteFile("manifest.json", manifest.CreatedAt, int64(len(buf)), bytes.NewReader(buf)); err != nil {
+		return fmt.Errorf("failed to write log archive manifest: %w", err)
+	}
+
+	return archive.Close()
+}
+
+func writeFileToArchive(archive archiveWriter, archivePath string, filePath string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+
+	// The file might still be growing if the process is alive, so we only copy as many bytes
+	// as there were when we started.
+	return archive.WriteFile(archivePath, info.ModTime(), info.Size(), io.LimitReader(file, info.Size()))
+}
+
+type archiveWriter interface {
+	WriteFile(name string, modTime time.Time, size int64, contents io.Reader) error
+	Close() error
+}
+
+type tarGzArchiveWriter struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+}
+
+func (archive *tarGzArchiveWriter) WriteFile(name string, modTime time.Time, size int64, contents io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: modTime,
+	}
+
+	if err := archive.tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	_, err := io.Copy(archive.tw, contents)
+	return err
+}
+
+func (archive *tarGzArchiveWriter) Close() error {
+	if err := archive.tw.Close(); err != nil {
+		return err
+	}
+
+	return archive.gz.Close()
+}
+
+type zipArchiveWriter struct {
+	zw *zip.Writer
+}
+
+func (archive *zipArchiveWriter) WriteFile(name string, modTime time.Time, size int64, contents io.Reader) error {
+	writer, err := archive.zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(writer, contents)
+	return err
+}
+
+func (archive *zipArchiveWriter) Close() error {
+	return archive.zw.Close()
+}
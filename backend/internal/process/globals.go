@@ -21,6 +21,11 @@ func init() {
 			"data",
 			"spawned-processes.db",
 		),
+		filepath.Join(
+			robinPath,
+			"data",
+			"process-crash-reports.db",
+		),
 	)
 
 	if err != nil {
@@ -1,6 +1,9 @@
 package process
 
-import "robinplatform.dev/internal/model"
+import (
+	"robinplatform.dev/internal/model"
+	"robinplatform.dev/internal/pubsub"
+)
 
 type RHandle struct {
 	m  *ProcessManager
@@ -70,6 +73,13 @@ func (m *ProcessManager) Remove(id ProcessId) error {
 	return w.Remove(id)
 }
 
+func (m *ProcessManager) RemoveCategory(category string) error {
+	w := m.WriteHandle()
+	defer w.Close()
+
+	return w.RemoveCategory(category)
+}
+
 func (m *ProcessManager) Kill(id ProcessId) error {
 	w := m.WriteHandle()
 	defer w.Close()
@@ -90,3 +100,17 @@ func (m *ProcessManager) Spawn(config ProcessConfig) (Process, error) {
 
 	return w.Spawn(config)
 }
+
+func (m *ProcessManager) SpawnWithLogs(config ProcessConfig, opts pubsub.SubscribeOptions) (Process, pubsub.Subscription[string], error) {
+	w := m.WriteHandle()
+	defer w.Close()
+
+	return w.SpawnWithLogs(config, opts)
+}
+
+func (m *ProcessManager) Restart(id ProcessId) (Process, error) {
+	w := m.WriteHandle()
+	defer w.Close()
+
+	return w.Restart(id)
+}
@@ -73,17 +73,17 @@ func (check *SerializableHealthCheck) UnmarshalJSON(data []byte) error {
 	switch obj.Type {
 	case "process":
 		c := ProcessHealthCheck{}
-		err = json.Unmarshal(data, &c)
+		err = unmarshalCheck(obj.Check, &c)
 		check.check = c
 
 	case "http":
 		c := HttpHealthCheck{}
-		err = json.Unmarshal(data, &c)
+		err = unmarshalCheck(obj.Check, &c)
 		check.check = c
 
 	case "tcp":
 		c := TcpHealthCheck{}
-		err = json.Unmarshal(data, &c)
+		err = unmarshalCheck(obj.Check, &c)
 		check.check = c
 
 	default:
@@ -98,7 +98,17 @@ func (check *SerializableHealthCheck) UnmarshalJSON(data []byte) error {
 
 }
 
-func (check *SerializableHealthCheck) MarshalJSON() ([]byte, error) {
+// The check itself is nested under the "check" key, and may be missing or null
+// for checks without any options.
+func unmarshalCheck(data json.RawMessage, out any) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (check SerializableHealthCheck) MarshalJSON() ([]byte, error) {
 	return json.Marshal(map[string]interface{}{
 		"type":  check.checkType,
 		"check": check.check,
@@ -1,7 +1,10 @@
 package process
 
 import (
+	"bufio"
 	"context"
+	"fmt"
+	"io"
 	"os"
 	"path"
 	"path/filepath"
@@ -11,11 +14,53 @@ import (
 	"robinplatform.dev/internal/pubsub"
 )
 
+// The number of previous log files that are kept for each process, as `{key}.log.1`, `{key}.log.2`, etc.
+const maxLogBackups = 3
+
 func (m *ProcessManager) getLogFilePath(id ProcessId) string {
 	processLogsPath := filepath.Join(m.processLogsFolderPath, filepath.FromSlash(id.Category), id.Key+".log")
 	return processLogsPath
 }
 
+// Returns the paths of the current log file of a process and its backups that exist
+// on disk, newest first.
+func (m *ProcessManager) getLogFilePaths(id ProcessId) []string {
+	logPath := m.getLogFilePath(id)
+
+	paths := make([]string, 0, maxLogBackups+1)
+	for i := 0; i <= maxLogBackups; i++ {
+		path := logPath
+		if i > 0 {
+			path = fmt.Sprintf("%s.%d", logPath, i)
+		}
+
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// Moves the log file of a process out of the way, so that a new process with the same
+// ID doesn't overwrite it. The oldest backup is discarded.
+func (m *ProcessManager) rotateLogFile(id ProcessId) error {
+	logPath := m.getLogFilePath(id)
+	if _, err := os.Stat(logPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	for i := maxLogBackups - 1; i >= 1; i-- {
+		from := fmt.Sprintf("%s.%d", logPath, i)
+		to := fmt.Sprintf("%s.%d", logPath, i+1)
+		if err := os.Rename(from, to); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return os.Rename(logPath, logPath+".1")
+}
+
 func (r *RHandle) GetLogFile(id ProcessId) (LogFileResult, error) {
 	proc, found := r.FindById(id)
 	if !found {
@@ -52,7 +97,7 @@ func (id ProcessId) LogsTopicId() pubsub.TopicId {
 func (manager *ProcessManager) logTopicForProcId(id ProcessId) (*pubsub.Topic[string], error) {
 	topicId := id.LogsTopicId()
 
-	topic, err := pubsub.CreateTopic[string](manager.registry, topicId)
+	topic, err := pubsub.CreateTopic[string](manager.registry, topicId, pubsub.TopicOptions{})
 	if err != nil {
 		logger.Err("error creating logging topic for process", log.Ctx{
 			"id":  id,
@@ -92,9 +137,17 @@ func (m *ProcessManager) pipeTailIntoTopic(process topicTailInfo) {
 
 	defer out.Cleanup()
 
+	// The offset in the file right after the last line that was published
+	var offset int64
+
 	for {
 		select {
 		case <-process.Context.Done():
+			// The process is dead, so the file won't grow anymore, but the tail might not have
+			// caught up with it yet. We stop the tail, and then publish whatever is left in the file
+			// ourselves, so that the last few lines of output don't get lost.
+			out.Stop()
+			m.publishRemainingLines(process, offset)
 			return
 
 		case line, ok := <-out.Lines:
@@ -110,6 +163,33 @@ func (m *ProcessManager) pipeTailIntoTopic(process topicTailInfo) {
 			}
 
 			process.logsTopic.Publish(line.Text)
+			offset = line.SeekInfo.Offset
 		}
 	}
 }
+
+func (m *ProcessManager) publishRemainingLines(process topicTailInfo, offset int64) {
+	file, err := os.Open(m.getLogFilePath(process.processId))
+	if err != nil {
+		logger.Debug("failed to open log file to publish remaining lines", log.Ctx{
+			"err": err.Error(),
+		})
+		return
+	}
+	defer file.Close()
+
+	// If the file is smaller than the offset, it was truncated by a new process with
+	// the same ID, so the rest of the output is already gone.
+	if info, err := file.Stat(); err != nil || info.Size() < offset {
+		return
+	}
+
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return
+	}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		process.logsTopic.Publish(scanner.Text())
+	}
+}
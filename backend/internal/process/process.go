@@ -2,11 +2,13 @@ package process
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"robinplatform.dev/internal/identity"
@@ -27,15 +29,57 @@ func (p ProcessId) String() string {
 	return (identity.Id)(p).String()
 }
 
+// Returns true if the process's category is the given category, or one of its sub-categories.
+func (p ProcessId) IsInCategory(category string) bool {
+	return p.Category == category || strings.HasPrefix(p.Category, strings.TrimSuffix(category, "/")+"/")
+}
+
+// Runs a command string through a shell, instead of executing it directly.
+type ShellConfig struct {
+	// The shell to run the command with, e.g. "sh" or "bash". This gets resolved
+	// to an absolute path using $PATH when the process is spawned.
+	Shell string `json:"shell"`
+	// Login runs the shell as a login shell, so that the user's profile gets loaded
+	Login bool `json:"login"`
+}
+
 type ProcessConfig struct {
-	Id      ProcessId
-	WorkDir string
-	Env     map[string]string
-	Command string
-	Args    []string
-	Port    int
+	Id      ProcessId         `json:"id"`
+	WorkDir string            `json:"workDir"`
+	Env     map[string]string `json:"env"`
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Port    int               `json:"port"`
+
+	// If set, `Command` is a command string that gets run by the shell, and `Args`
+	// are passed to it as positional parameters ($1, $2, ...).
+	Shell *ShellConfig `json:"shell,omitempty"`
+
+	HealthCheck health.HealthCheck `json:"healthCheck"`
+}
+
+// The health check is an interface, so it needs to be decoded through its serializable
+// form, otherwise the JSON decoder has no idea which implementation to use.
+func (cfg *ProcessConfig) UnmarshalJSON(data []byte) error {
+	type rawProcessConfig ProcessConfig
+
+	raw := struct {
+		*rawProcessConfig
+		HealthCheck *health.SerializableHealthCheck `json:"healthCheck"`
+	}{
+		rawProcessConfig: (*rawProcessConfig)(cfg),
+	}
 
-	HealthCheck health.HealthCheck
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	cfg.HealthCheck = nil
+	if raw.HealthCheck != nil {
+		cfg.HealthCheck = *raw.HealthCheck
+	}
+
+	return nil
 }
 
 type Process struct {
@@ -48,18 +92,21 @@ type Process struct {
 	Args      []string          `json:"args"`
 	Port      int               `json:"port"`
 
+	// Only set for processes running in shell mode, with the shell resolved to an absolute path
+	Shell *ShellConfig `json:"shell,omitempty"`
+
 	HealthCheck health.SerializableHealthCheck `json:"healthCheck"`
 
-	// NOTE: The fields below are only valid because
-	// the store doesn't re-load data from disk when the file is updated.
-	// They're not serializable, and get filled in at startup.
+	// NOTE: The fields below aren't serializable, and the store drops them whenever it
+	// reloads its data from disk. They're kept by the manager in `processRuntime`, and
+	// filled in by `attachRuntime` whenever a process is read from the store.
 
 	logsTopic *pubsub.Topic[string] `json:"-"`
 	Context   context.Context       `json:"-"` // This Context gets canceled when the process dies.
 	cancel    func()                `json:"-"` // Cancel the context
 }
 
-func waitForExit(process pollPidContext) {
+func (m *ProcessManager) waitForExit(process pollPidContext, config ProcessConfig, startedAt time.Time) {
 	proc, err := os.FindProcess(process.pid)
 	if err != nil {
 		logger.Debug("Failed to find process to wait on", log.Ctx{
@@ -69,22 +116,51 @@ func waitForExit(process pollPidContext) {
 		return
 	}
 
-	if exitCode, err := proc.Wait(); err != nil {
-		logger.Debug("Process exited with error", log.Ctx{
-			"process":  process,
-			"exitCode": exitCode,
-			"err":      err,
-		})
-	} else {
-		logger.Debug("Process exited", log.Ctx{
+	state, err := proc.Wait()
+	if err != nil {
+		logger.Debug("Failed to wait on process", log.Ctx{
 			"process": process,
+			"err":     err,
 		})
+		process.cancel()
+		return
 	}
 
+	logger.Debug("Process exited", log.Ctx{
+		"process": process,
+		"state":   state.String(),
+	})
+
+	// The context needs to be canceled before the crash report is created, since creating
+	// the report takes a lock on the database, and whoever is holding that lock might
+	// be waiting on this process to die.
 	process.cancel()
+
+	if !state.Success() {
+		m.recordCrash(config, process.pid, startedAt, state)
+	}
+}
+
+// Returns the config that can be used to spawn this process again.
+func (process *Process) config() ProcessConfig {
+	return ProcessConfig{
+		Id:          process.Id,
+		WorkDir:     process.WorkDir,
+		Env:         process.Env,
+		Command:     process.Command,
+		Args:        process.Args,
+		Port:        process.Port,
+		Shell:       process.Shell,
+		HealthCheck: process.HealthCheck,
+	}
 }
 
 func (process *Process) IsAlive() bool {
+	// Processes that weren't read through the manager don't have a context
+	if process.Context == nil {
+		return health.PidIsAlive(process.Pid)
+	}
+
 	select {
 	case <-process.Context.Done():
 		return false
@@ -98,6 +174,13 @@ type LogFileResult struct {
 	Counter int32  `json:"counter"` // TODO: bad name
 }
 
+// The name of the index of processes by their ID
+const processIdIndex = "id"
+
+// Inserted, updated and deleted processes are published to this topic, as
+// `model.StoreChange[Process]`, so that the process list can be followed without polling
+var ProcessChangesTopicId = pubsub.TopicId{Category: "/processes", Key: "changes"}
+
 func findById(id ProcessId) func(row Process) bool {
 	return func(row Process) bool {
 		return row.Id == id
@@ -143,9 +226,33 @@ func (cfg *ProcessConfig) fillEmptyValues() error {
 		cfg.HealthCheck = &health.ProcessHealthCheck{}
 	}
 
+	if cfg.Shell != nil {
+		shell := *cfg.Shell
+		if shell.Shell == "" {
+			shell.Shell = defaultShell
+		}
+
+		shellPath, err := exec.LookPath(shell.Shell)
+		if err != nil {
+			return fmt.Errorf("failed to find shell %s in $PATH: %w", shell.Shell, err)
+		}
+
+		shell.Shell = shellPath
+		cfg.Shell = &shell
+	}
+
 	return nil
 }
 
+// Returns the executable path and arguments used to actually start the process.
+func (cfg *ProcessConfig) argv() (string, []string) {
+	if cfg.Shell != nil {
+		return cfg.Shell.Shell, shellArgv(*cfg.Shell, cfg.Command, cfg.Args)
+	}
+
+	return cfg.Command, append([]string{cfg.Command}, cfg.Args...)
+}
+
 // This is essentially a global type, but it's set up as an instance for testing purposes.
 // Use `process.Manager` to manage processes.
 type ProcessManager struct {
@@ -154,6 +261,10 @@ type ProcessManager struct {
 	// Data persisted to disk about processes
 	db model.Store[Process]
 
+	// Crash reports for processes that exited abnormally
+	crashDb    model.Store[CrashReport]
+	crashTopic *pubsub.Topic[CrashReport]
+
 	registry *pubsub.Registry
 
 	// Context for long running operations, the parent
@@ -161,56 +272,123 @@ type ProcessManager struct {
 	ctx context.Context
 	// Cancel function for the context
 	cancel func()
+
+	runtimeMux sync.Mutex
+	runtime    map[ProcessId]*processRuntime
+}
+
+// The state of a running process that can't be persisted. It's kept outside of the process
+// db, since the db reloads its data from disk when another robin process changes it.
+type processRuntime struct {
+	pid       int
+	logsTopic *pubsub.Topic[string]
+	ctx       context.Context
+	cancel    func()
+}
+
+func (m *ProcessManager) setRuntime(id ProcessId, runtime *processRuntime) {
+	m.runtimeMux.Lock()
+	defer m.runtimeMux.Unlock()
+
+	m.runtime[id] = runtime
+}
+
+func (m *ProcessManager) removeRuntime(id ProcessId) {
+	m.runtimeMux.Lock()
+	defer m.runtimeMux.Unlock()
+
+	delete(m.runtime, id)
+}
+
+// Fills in the runtime fields of a process that was read from the db. Processes that were
+// spawned by another manager, e.g. in another robin process sharing the db, don't have any
+// runtime state yet, so their exit is found by polling.
+func (m *ProcessManager) attachRuntime(proc *Process) {
+	m.runtimeMux.Lock()
+	defer m.runtimeMux.Unlock()
+
+	runtime, found := m.runtime[proc.Id]
+	if !found || runtime.pid != proc.Pid {
+		runtime = &processRuntime{pid: proc.Pid}
+		runtime.ctx, runtime.cancel = context.WithCancel(m.ctx)
+
+		if health.PidIsAlive(proc.Pid) {
+			go pollForExit([]pollPidContext{{pid: proc.Pid, cancel: runtime.cancel}})
+		} else {
+			runtime.cancel()
+		}
+
+		m.runtime[proc.Id] = runtime
+	}
+
+	proc.logsTopic = runtime.logsTopic
+	proc.Context = runtime.ctx
+	proc.cancel = runtime.cancel
 }
 
-func NewProcessManager(registry *pubsub.Registry, logsPath string, dbPath string) (*ProcessManager, error) {
-	manager := &ProcessManager{}
+func NewProcessManager(registry *pubsub.Registry, logsPath string, dbPath string, crashReportsPath string) (*ProcessManager, error) {
+	manager := &ProcessManager{
+		runtime: make(map[ProcessId]*processRuntime),
+	}
+
+	changesTopic, err := pubsub.CreateTopic[model.StoreChange[Process]](registry, ProcessChangesTopicId, pubsub.TopicOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create process changes topic: %w", err)
+	}
 
-	var err error
-	manager.db, err = model.NewStore[Process](dbPath)
+	manager.db, err = model.NewStoreWithOptions(dbPath, model.StoreOptions[Process]{
+		Indexes: map[string]func(row Process) string{
+			processIdIndex: func(row Process) string { return row.Id.String() },
+		},
+		Changes: changesTopic,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create process database: %w", err)
 	}
 
+	manager.crashDb, err = model.NewStore[CrashReport](crashReportsPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crash report database: %w", err)
+	}
+
+	manager.crashTopic, err = pubsub.CreateTopic[CrashReport](registry, CrashReportsTopicId, pubsub.TopicOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create crash report topic: %w", err)
+	}
+
 	manager.processLogsFolderPath = logsPath
 	manager.registry = registry
 
 	manager.ctx, manager.cancel = context.WithCancel(context.Background())
 
 	procIds := make([]pollPidContext, 0)
-	var topicCreationErr error
-	err = manager.db.ForEachWriting(func(proc *Process) {
-		proc.Context, proc.cancel = context.WithCancel(manager.ctx)
+	for _, proc := range manager.db.ShallowCopyOutData() {
+		runtime := &processRuntime{pid: proc.Pid}
+		runtime.ctx, runtime.cancel = context.WithCancel(manager.ctx)
+		manager.runtime[proc.Id] = runtime
 
 		if !health.PidIsAlive(proc.Pid) {
-			proc.cancel()
-			return
+			runtime.cancel()
+			continue
 		}
 
 		procIds = append(procIds, pollPidContext{
 			pid:    proc.Pid,
-			cancel: proc.cancel,
+			cancel: runtime.cancel,
 		})
 
 		topic, err := manager.logTopicForProcId(proc.Id)
 		if err != nil {
-			topicCreationErr = err
-			return
+			return nil, err
 		}
 
-		proc.logsTopic = topic
+		runtime.logsTopic = topic
 
 		go manager.pipeTailIntoTopic(topicTailInfo{
 			processId: proc.Id,
 			logsTopic: topic,
-			Context:   proc.Context,
+			Context:   runtime.ctx,
 		})
-	})
-	if topicCreationErr != nil {
-		return nil, topicCreationErr
-	}
-	if err != nil {
-		return nil, err
 	}
 
 	// Hand off procIds to the goroutine
@@ -249,10 +427,11 @@ func pollForExit(processes []pollPidContext) {
 }
 
 func (r *RHandle) FindById(id ProcessId) (Process, bool) {
-	procEntry, found := r.db.Find(findById(id))
+	procEntry, found := r.db.FindByKey(processIdIndex, id.String())
 	if !found {
 		return Process{}, false
 	}
+	r.m.attachRuntime(&procEntry)
 	return procEntry, true
 }
 
@@ -268,19 +447,54 @@ func (proc *Process) IsHealthy() bool {
 	return proc.IsAlive() && proc.HealthCheck.Check(health.RunningProcessInfo{Pid: proc.Pid, Port: proc.Port})
 }
 
-// This reads the path variable to find the right executable.
+// This reads the path variable to find the right executable. In shell mode, the
+// shell is always resolved using the path variable, so this is the same as `Spawn`.
 func (w *WHandle) SpawnFromPathVar(config ProcessConfig) (Process, error) {
-	var err error
-	config.Command, err = exec.LookPath(config.Command)
-	if err != nil {
-		return Process{}, fmt.Errorf("failed to find command %s in $PATH: %w", config.Command, err)
+	if err := config.lookPath(); err != nil {
+		return Process{}, err
 	}
 
 	return w.Spawn(config)
 }
 
+// This is the same as `SpawnFromPathVar`, but it also subscribes to the process's logs before
+// any output gets published, so that none of it is missed.
+func (w *WHandle) SpawnWithLogs(config ProcessConfig, opts pubsub.SubscribeOptions) (Process, pubsub.Subscription[string], error) {
+	if err := config.lookPath(); err != nil {
+		return Process{}, pubsub.Subscription[string]{}, err
+	}
+
+	var sub pubsub.Subscription[string]
+	proc, err := w.spawn(config, func(topic *pubsub.Topic[string]) error {
+		var err error
+		sub, err = pubsub.Subscribe[string](w.Read.m.registry, topic.Id, opts)
+		return err
+	})
+
+	return proc, sub, err
+}
+
+func (cfg *ProcessConfig) lookPath() error {
+	if cfg.Shell != nil {
+		return nil
+	}
+
+	command, err := exec.LookPath(cfg.Command)
+	if err != nil {
+		return fmt.Errorf("failed to find command %s in $PATH: %w", cfg.Command, err)
+	}
+
+	cfg.Command = command
+	return nil
+}
+
 // This spawns a process using the given arguments and executable path.
 func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
+	return w.spawn(procConfig, nil)
+}
+
+// `beforeTail` runs after the logs topic is created, but before anything is published to it.
+func (w *WHandle) spawn(procConfig ProcessConfig, beforeTail func(topic *pubsub.Topic[string]) error) (Process, error) {
 	if err := procConfig.fillEmptyValues(); err != nil {
 		return Process{}, err
 	}
@@ -290,7 +504,7 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		return Process{}, err
 	}
 
-	prev, found := w.db.Find(findById(procConfig.Id))
+	prev, found := w.Read.FindById(procConfig.Id)
 	if found {
 		if prev.IsAlive() {
 			logger.Debug("Found previous process", log.Ctx{
@@ -300,11 +514,14 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 			return prev, processExists(procConfig.Id)
 		}
 
-		logger.Debug("Found previous dead process entry, deleting it", log.Ctx{
+		logger.Debug("Found previous dead process entry, replacing it", log.Ctx{
 			"processId": procConfig.Id,
 		})
-		if err := w.Remove(prev.Id); err != nil {
-			return Process{}, fmt.Errorf("failed to delete previous process: %w", err)
+
+		// The logs topic gets closed asynchronously after the process exits, so it might
+		// still be open, which would prevent us from creating a new one.
+		if prev.logsTopic != nil {
+			prev.logsTopic.Close()
 		}
 	}
 
@@ -325,6 +542,13 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		return Process{}, fmt.Errorf("failed to create process folder: %w", err)
 	}
 
+	if err := w.Read.m.rotateLogFile(procConfig.Id); err != nil {
+		logger.Warn("Failed to rotate process logs, they will be overwritten", log.Ctx{
+			"processId": procConfig.Id,
+			"err":       err.Error(),
+		})
+	}
+
 	// Don't close the file, instead pass it on to the tail goroutine later on
 	output, err := os.Create(processLogsPath)
 	if err != nil {
@@ -342,8 +566,8 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		attr.Env = append(attr.Env, key+"="+value)
 	}
 
-	argStrings := append([]string{procConfig.Command}, procConfig.Args...)
-	proc, err := os.StartProcess(procConfig.Command, argStrings, &attr)
+	executable, argStrings := procConfig.argv()
+	proc, err := os.StartProcess(executable, argStrings, &attr)
 	if err != nil {
 		return Process{}, err
 	}
@@ -355,6 +579,14 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		return Process{}, err
 	}
 
+	if beforeTail != nil {
+		if err := beforeTail(topic); err != nil {
+			_ = proc.Kill()
+			topic.Close()
+			return Process{}, err
+		}
+	}
+
 	ctx, cancel := context.WithCancel(w.Read.m.ctx)
 
 	entry := Process{
@@ -366,6 +598,7 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		Pid:         proc.Pid,
 		Env:         procConfig.Env,
 		Port:        procConfig.Port,
+		Shell:       procConfig.Shell,
 		HealthCheck: healthCheck,
 
 		logsTopic: topic,
@@ -373,6 +606,9 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		cancel:    cancel,
 	}
 
+	crashConfig := procConfig
+	crashConfig.HealthCheck = healthCheck
+
 	// Write output to file
 	go w.Read.m.pipeTailIntoTopic(topicTailInfo{
 		processId: entry.Id,
@@ -380,11 +616,18 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		Context:   entry.Context,
 	})
 
-	// Reap zombies
-	go waitForExit(pollPidContext{
+	w.Read.m.setRuntime(entry.Id, &processRuntime{
+		pid:       entry.Pid,
+		logsTopic: entry.logsTopic,
+		ctx:       entry.Context,
+		cancel:    entry.cancel,
+	})
+
+	// Reap zombies, and keep track of crashes
+	go w.Read.m.waitForExit(pollPidContext{
 		pid:    entry.Pid,
 		cancel: entry.cancel,
-	})
+	}, crashConfig, entry.StartedAt)
 
 	logger.Debug("Process created", log.Ctx{
 		"id":       entry.Id,
@@ -392,7 +635,7 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 		"logsPath": processLogsPath,
 	})
 
-	if err := w.db.Insert(entry); err != nil {
+	if err := w.db.Upsert(findById(entry.Id), entry); err != nil {
 		logger.Debug("Failed to insert process into database", log.Ctx{
 			"error": err.Error(),
 		})
@@ -412,9 +655,41 @@ func (w *WHandle) Spawn(procConfig ProcessConfig) (Process, error) {
 	return entry, nil
 }
 
+// How long `Restart` waits for the previous process to exit after killing it
+const restartTimeout = 5 * time.Second
+
+// Restart kills the process with the given id if it's alive, and then spawns it again
+// with the same configuration.
+func (w *WHandle) Restart(id ProcessId) (Process, error) {
+	prev, found := w.Read.FindById(id)
+	if !found {
+		return Process{}, processNotFound(id)
+	}
+
+	if prev.IsAlive() {
+		if err := w.Kill(id); err != nil {
+			return Process{}, err
+		}
+
+		select {
+		case <-prev.Context.Done():
+		case <-time.After(restartTimeout):
+			return Process{}, fmt.Errorf("process %s did not exit after being killed", id)
+		}
+	}
+
+	// The logs topic gets closed asynchronously after the process exits, so we close it
+	// here to make sure that the new process can create its own.
+	if prev.logsTopic != nil {
+		prev.logsTopic.Close()
+	}
+
+	return w.Spawn(prev.config())
+}
+
 // Remove will kill the process if it is alive, and then remove it from the database
 func (w *WHandle) Remove(id ProcessId) error {
-	procEntry, found := w.db.Find(findById(id))
+	procEntry, found := w.Read.FindById(id)
 	if !found {
 		return nil
 	}
@@ -429,6 +704,26 @@ func (w *WHandle) Remove(id ProcessId) error {
 		return fmt.Errorf("failed to delete process: %w", err)
 	}
 
+	w.Read.m.removeRuntime(id)
+	return nil
+}
+
+// RemoveCategory kills and removes every process in the given category, including
+// processes in its sub-categories.
+func (w *WHandle) RemoveCategory(category string) error {
+	var ids []ProcessId
+	for _, proc := range w.Read.db.ShallowCopyOutData() {
+		if proc.Id.IsInCategory(category) {
+			ids = append(ids, proc.Id)
+		}
+	}
+
+	for _, id := range ids {
+		if err := w.Remove(id); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -442,6 +737,7 @@ func (r *RHandle) CopyOutData() []Process {
 
 	for i := 0; i < len(data); i += 1 {
 		proc := &data[i]
+		r.m.attachRuntime(proc)
 
 		env := proc.Env
 		proc.Env = make(map[string]string, len(env))
@@ -7,22 +7,40 @@ import (
 	"syscall"
 )
 
+const defaultShell = "sh"
+
 func getProcessSysAttrs() *syscall.SysProcAttr {
 	return &syscall.SysProcAttr{
 		Setpgid: true,
 	}
 }
 
+func shellArgv(shell ShellConfig, command string, args []string) []string {
+	argv := []string{shell.Shell}
+	if shell.Login {
+		argv = append(argv, "-l")
+	}
+
+	// The argument after the command string becomes $0, and the rest are
+	// the positional parameters.
+	argv = append(argv, "-c", command, shell.Shell)
+	return append(argv, args...)
+}
+
 // Kill will kill the process with the given id (not PID), and remove it from
 // the internal database.
 func (w *WHandle) Kill(id ProcessId) error {
-	procEntry, found := w.db.Find(findById(id))
+	procEntry, found := w.db.FindByKey(processIdIndex, id.String())
 	if !found {
 		return processNotFound(id)
 	}
 
+	// Every process is spawned as the leader of its own process group, so we signal the
+	// whole group. Otherwise, children of the process (e.g. the commands run by a shell)
+	// would outlive it.
+	//
 	// We will not treat ESRCH as an error, since it means the process is already dead.
-	if err := syscall.Kill(procEntry.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+	if err := syscall.Kill(-procEntry.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
 		return fmt.Errorf("failed to kill process: %w", err)
 	}
 
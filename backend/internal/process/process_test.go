@@ -1,8 +1,19 @@
 package process
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"robinplatform.dev/internal/process/health"
 	"robinplatform.dev/internal/pubsub"
@@ -11,9 +22,10 @@ import (
 func TestSpawnProcess(t *testing.T) {
 	dir := t.TempDir()
 	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
 
 	topics := &pubsub.Registry{}
-	manager, err := NewProcessManager(topics, dir, dbFile)
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
 	if err != nil {
 		t.Fatalf("error loading DB: %s", err.Error())
 	}
@@ -55,9 +67,10 @@ func TestSpawnProcess(t *testing.T) {
 func TestSpawnDead(t *testing.T) {
 	dir := t.TempDir()
 	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
 
 	topics := &pubsub.Registry{}
-	manager, err := NewProcessManager(topics, dir, dbFile)
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
 	if err != nil {
 		t.Fatalf("error loading DB: %s", err.Error())
 	}
@@ -91,9 +104,10 @@ func TestSpawnDead(t *testing.T) {
 func TestSpawnedBeforeManagerStarted(t *testing.T) {
 	dir := t.TempDir()
 	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
 
 	topicsA := &pubsub.Registry{}
-	managerA, err := NewProcessManager(topicsA, dir, dbFile)
+	managerA, err := NewProcessManager(topicsA, dir, dbFile, crashFile)
 	if err != nil {
 		t.Fatalf("error loading DB: %s", err.Error())
 	}
@@ -116,7 +130,7 @@ func TestSpawnedBeforeManagerStarted(t *testing.T) {
 	// and then we don't touch it anymore. Then, the second is created, as if Robin
 	// restarted and the manager is going in fresh with processes that haven't died yet.
 	topicsB := &pubsub.Registry{}
-	managerB, err := NewProcessManager(topicsB, dir, dbFile)
+	managerB, err := NewProcessManager(topicsB, dir, dbFile, crashFile)
 	if err != nil {
 		t.Fatalf("error loading DB: %s", err.Error())
 	}
@@ -145,5 +159,498 @@ func TestSpawnedBeforeManagerStarted(t *testing.T) {
 	}
 }
 
+func TestManagersSharingDb(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	// Each manager creates its topics in its own registry, like separate robin processes
+	managerA, err := NewProcessManager(&pubsub.Registry{}, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+	managerB, err := NewProcessManager(&pubsub.Registry{}, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	idA := ProcessId{Category: "robin", Key: "a"}
+	idB := ProcessId{Category: "robin", Key: "b"}
+	config := ProcessConfig{Command: "sleep", Args: []string{"100"}}
+
+	config.Id = idA
+	if _, err := managerA.SpawnFromPathVar(config); err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+	defer managerA.Kill(idA)
+
+	// Makes A reload the db the next time it's used, which drops the runtime fields of its rows
+	config.Id = idB
+	procB, err := managerB.SpawnFromPathVar(config)
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	if !managerA.IsAlive(idA) {
+		t.Fatalf("manager doesn't think its own process is alive after reloading")
+	}
+	if !managerA.IsAlive(idB) {
+		t.Fatalf("manager doesn't think the other manager's process is alive")
+	}
+
+	config.Id = idA
+	if _, err := managerA.SpawnFromPathVar(config); !errors.Is(err, ErrProcessAlreadyExists) {
+		t.Fatalf("expected spawning a running process to fail, got %v", err)
+	}
+
+	restarted, err := managerA.Restart(idA)
+	if err != nil {
+		t.Fatalf("failed to restart process: %s", err.Error())
+	}
+	if !managerB.IsAlive(idA) {
+		t.Fatalf("other manager doesn't think the restarted process is alive")
+	}
+	if proc, _ := managerB.FindById(idA); proc.Pid != restarted.Pid {
+		t.Fatalf("other manager has pid %d for the restarted process, expected %d", proc.Pid, restarted.Pid)
+	}
+
+	if err := managerB.Kill(idB); err != nil {
+		t.Fatalf("failed to kill process: %s", err.Error())
+	}
+	<-procB.Context.Done()
+
+	if managerA.IsAlive(idB) {
+		t.Fatalf("manager thinks the other manager's process is alive after it was killed")
+	}
+}
+
 // TODO: test to ensure that writes to the stderr and stdout don't mess with each other
-// TODO: test to ensure that children that the process spawns get killed as well
+
+func TestCrashReport(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	sub, err := pubsub.Subscribe[CrashReport](topics, CrashReportsTopicId, pubsub.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("error subscribing to crash reports: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	id := ProcessId{Category: "robin", Key: "crash"}
+	_, err = manager.SpawnFromPathVar(ProcessConfig{
+		Id:      id,
+		Command: "sh",
+		Args:    []string{"-c", "echo hello; echo world; exit 3"},
+	})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	var report CrashReport
+	select {
+	case msg := <-sub.Out:
+		report = msg.Data
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for crash report")
+	}
+
+	if report.ProcessId != id {
+		t.Fatalf("crash report had the wrong process id: %+v", report.ProcessId)
+	}
+	if report.ExitCode != 3 {
+		t.Fatalf("crash report had the wrong exit code: %d", report.ExitCode)
+	}
+	if len(report.Logs) != 2 || report.Logs[0] != "hello" || report.Logs[1] != "world" {
+		t.Fatalf("crash report had the wrong logs: %+v", report.Logs)
+	}
+
+	// Reports should survive a restart
+	managerB, err := NewProcessManager(&pubsub.Registry{}, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	reports := managerB.GetCrashReports(id)
+	if len(reports) != 1 {
+		t.Fatalf("expected 1 persisted crash report, got %d", len(reports))
+	}
+	if reports[0].Config.Id != id || reports[0].Config.HealthCheck == nil {
+		t.Fatalf("crash report config was not persisted correctly: %+v", reports[0].Config)
+	}
+}
+
+func TestKillIsNotACrash(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	sub, err := pubsub.Subscribe[CrashReport](topics, CrashReportsTopicId, pubsub.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("error subscribing to crash reports: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	id := ProcessId{Category: "robin", Key: "killed"}
+	proc, err := manager.SpawnFromPathVar(ProcessConfig{
+		Id:      id,
+		Command: "sleep",
+		Args:    []string{"100"},
+	})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	if err := manager.Kill(id); err != nil {
+		t.Fatalf("failed to kill process: %s", err.Error())
+	}
+
+	<-proc.Context.Done()
+
+	select {
+	case msg := <-sub.Out:
+		t.Fatalf("got a crash report for a killed process: %+v", msg.Data)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
+
+func TestSpawnShell(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	id := ProcessId{Category: "robin", Key: "shell"}
+	proc, err := manager.Spawn(ProcessConfig{
+		Id:      id,
+		Command: `echo hello | tr a-z A-Z && echo "$GREETING $1"`,
+		Args:    []string{"world"},
+		Env:     map[string]string{"GREETING": "goodbye"},
+		Shell:   &ShellConfig{},
+	})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	if proc.Shell == nil || !filepath.IsAbs(proc.Shell.Shell) {
+		t.Fatalf("process didn't record the resolved shell: %+v", proc.Shell)
+	}
+
+	<-proc.Context.Done()
+
+	logs, err := manager.GetLogFile(id)
+	if err != nil {
+		t.Fatalf("error reading logs: %s", err.Error())
+	}
+
+	if logs.Text != "HELLO\ngoodbye world\n" {
+		t.Fatalf("shell command produced the wrong output: %q", logs.Text)
+	}
+}
+
+func TestKillShellChildren(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	id := ProcessId{Category: "robin", Key: "shell-children"}
+	proc, err := manager.Spawn(ProcessConfig{
+		Id:      id,
+		Command: "sleep 100 & echo $!; wait",
+		Shell:   &ShellConfig{Shell: "sh"},
+	})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	var childPid int
+	for i := 0; i < 50 && childPid == 0; i++ {
+		logs, err := manager.GetLogFile(id)
+		if err != nil {
+			t.Fatalf("error reading logs: %s", err.Error())
+		}
+
+		childPid, _ = strconv.Atoi(strings.TrimSpace(logs.Text))
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if childPid == 0 {
+		t.Fatalf("shell never printed the PID of its child")
+	}
+
+	if err := manager.Kill(id); err != nil {
+		t.Fatalf("failed to kill process: %s", err.Error())
+	}
+
+	<-proc.Context.Done()
+
+	for i := 0; i < 50 && pidIsRunning(childPid); i++ {
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	if pidIsRunning(childPid) {
+		t.Fatalf("child of the shell is still alive after killing the shell")
+	}
+}
+
+// Orphaned children get re-parented to init, which doesn't always reap them, so
+// a killed child can stick around as a zombie. Zombies aren't running though.
+func pidIsRunning(pid int) bool {
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return health.PidIsAlive(pid)
+	}
+
+	// The state is the field right after the executable name, which is wrapped in parens
+	fields := strings.Fields(string(stat[bytes.LastIndexByte(stat, ')')+1:]))
+	return len(fields) > 0 && fields[0] != "Z"
+}
+
+func TestRestartProcess(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	id := ProcessId{Category: "robin", Key: "restart"}
+	procA, err := manager.SpawnFromPathVar(ProcessConfig{
+		Id:      id,
+		Command: "sleep",
+		Args:    []string{"100"},
+	})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+
+	procB, err := manager.Restart(id)
+	if err != nil {
+		t.Fatalf("error restarting process: %s", err.Error())
+	}
+	defer manager.Kill(id)
+
+	if procA.Pid == procB.Pid {
+		t.Fatalf("restarted process has the same PID as the original")
+	}
+	if procA.IsAlive() {
+		t.Fatalf("manager thinks the original process is still alive")
+	}
+	if !manager.IsAlive(id) || !health.PidIsAlive(procB.Pid) {
+		t.Fatalf("restarted process isn't alive")
+	}
+	if procB.Command != procA.Command || len(procB.Args) != 1 || procB.Args[0] != "100" {
+		t.Fatalf("restarted process has a different config: %+v", procB)
+	}
+}
+
+func TestSpawnWithLogs(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	id := ProcessId{Category: "robin", Key: "logs"}
+	_, sub, err := manager.SpawnWithLogs(ProcessConfig{
+		Id:      id,
+		Command: "echo a; echo b",
+		Shell:   &ShellConfig{},
+	}, pubsub.SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("error spawning process: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	// The topic gets closed once the process exits, and all of its output has been published
+	lines := []string{}
+	for msg := range sub.Out {
+		lines = append(lines, msg.Data)
+	}
+
+	if len(lines) != 2 || lines[0] != "a" || lines[1] != "b" {
+		t.Fatalf("got the wrong output: %+v", lines)
+	}
+}
+
+func TestRemoveCategory(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	ids := []ProcessId{
+		{Category: "/app/a", Key: "one"},
+		{Category: "/app/a/nested", Key: "two"},
+		{Category: "/app/ab", Key: "three"},
+	}
+
+	for _, id := range ids {
+		if _, err := manager.SpawnFromPathVar(ProcessConfig{
+			Id:      id,
+			Command: "sleep",
+			Args:    []string{"100"},
+		}); err != nil {
+			t.Fatalf("error spawning process: %s", err.Error())
+		}
+	}
+	defer manager.Kill(ids[2])
+
+	if err := manager.RemoveCategory("/app/a"); err != nil {
+		t.Fatalf("error removing category: %s", err.Error())
+	}
+
+	if _, found := manager.FindById(ids[0]); found {
+		t.Fatalf("process in the category was not removed")
+	}
+	if _, found := manager.FindById(ids[1]); found {
+		t.Fatalf("process in a sub-category was not removed")
+	}
+	if !manager.IsAlive(ids[2]) {
+		t.Fatalf("process outside of the category was removed")
+	}
+
+	manager.runtimeMux.Lock()
+	_, foundRemoved := manager.runtime[ids[0]]
+	_, foundOther := manager.runtime[ids[2]]
+	manager.runtimeMux.Unlock()
+	if foundRemoved || !foundOther {
+		t.Fatalf("expected only the removed processes' runtime state to be deleted")
+	}
+}
+
+func TestWriteLogArchive(t *testing.T) {
+	dir := t.TempDir()
+	dbFile := filepath.Join(dir, "testing.db")
+	crashFile := filepath.Join(dir, "crashes.db")
+
+	topics := &pubsub.Registry{}
+	manager, err := NewProcessManager(topics, dir, dbFile, crashFile)
+	if err != nil {
+		t.Fatalf("error loading DB: %s", err.Error())
+	}
+
+	id := ProcessId{Category: "/export", Key: "echo"}
+	for _, message := range []string{"first", "second"} {
+		proc, err := manager.Spawn(ProcessConfig{
+			Id:      id,
+			Command: "echo " + message,
+			Env:     map[string]string{"API_TOKEN": "hunter2"},
+			Shell:   &ShellConfig{},
+		})
+		if err != nil {
+			t.Fatalf("error spawning process: %s", err.Error())
+		}
+
+		<-proc.Context.Done()
+	}
+
+	var buf bytes.Buffer
+	err = manager.WriteLogArchive(&buf, LogArchiveOptions{
+		Categories: []string{"/export"},
+		Format:     LogArchiveTarGz,
+	})
+	if err != nil {
+		t.Fatalf("error writing archive: %s", err.Error())
+	}
+
+	gz, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("error reading archive: %s", err.Error())
+	}
+
+	files := map[string]string{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("error reading archive: %s", err.Error())
+		}
+
+		contents, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatalf("error reading archive: %s", err.Error())
+		}
+
+		files[header.Name] = string(contents)
+	}
+
+	if files["logs/export/echo.log"] != "second\n" {
+		t.Fatalf("archive had the wrong current logs: %q", files["logs/export/echo.log"])
+	}
+	if files["logs/export/echo.log.1"] != "first\n" {
+		t.Fatalf("archive had the wrong rotated logs: %q", files["logs/export/echo.log.1"])
+	}
+
+	var manifest logArchiveManifest
+	if err := json.Unmarshal([]byte(files["manifest.json"]), &manifest); err != nil {
+		t.Fatalf("error reading manifest: %s", err.Error())
+	}
+
+	if len(manifest.Processes) != 1 || manifest.Processes[0].Id != id {
+		t.Fatalf("manifest had the wrong processes: %+v", manifest.Processes)
+	}
+	if manifest.Processes[0].Env["API_TOKEN"] != redactedValue {
+		t.Fatalf("manifest didn't redact secrets: %q", manifest.Processes[0].Env["API_TOKEN"])
+	}
+}
+
+func TestRedactShellCommand(t *testing.T) {
+	proc := Process{
+		Command: `API_TOKEN=hunter2 ./deploy --password="correct horse" --env=prod && echo done`,
+		Args:    []string{"--secret=abc", "plain"},
+		Env:     map[string]string{"SESSION_KEY": "abc", "PORT": "8080"},
+		Shell:   &ShellConfig{},
+	}
+
+	redactProcess(&proc)
+
+	expected := `API_TOKEN=[REDACTED] ./deploy --password=[REDACTED] --env=prod && echo done`
+	if proc.Command != expected {
+		t.Errorf("expected command %q, got %q", expected, proc.Command)
+	}
+	if proc.Args[0] != "--secret="+redactedValue || proc.Args[1] != "plain" {
+		t.Errorf("args weren't redacted: %q", proc.Args)
+	}
+	if proc.Env["SESSION_KEY"] != redactedValue || proc.Env["PORT"] != "8080" {
+		t.Errorf("env wasn't redacted: %v", proc.Env)
+	}
+}
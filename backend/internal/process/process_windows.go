@@ -5,20 +5,31 @@ package process
 import (
 	"fmt"
 	"os"
+	"os/exec"
+	"strconv"
 	"syscall"
 
 	"robinplatform.dev/internal/log"
 )
 
+const defaultShell = "cmd.exe"
+
 func getProcessSysAttrs() *syscall.SysProcAttr {
 	return &syscall.SysProcAttr{}
 }
 
+// TODO: cmd.exe doesn't have a login mode, or positional parameters, so the args
+// just get appended to the command string.
+func shellArgv(shell ShellConfig, command string, args []string) []string {
+	argv := []string{shell.Shell, "/C", command}
+	return append(argv, args...)
+}
+
 // Kill will kill the process with the given id (not PID), and remove it from
 // the internal database.
 // TODO: Make this work on windows
 func (w *WHandle) Kill(id ProcessId) error {
-	procEntry, found := w.db.Find(findById(id))
+	procEntry, found := w.db.FindByKey(processIdIndex, id.String())
 	if !found {
 		return processNotFound(id)
 	}
@@ -32,8 +43,13 @@ func (w *WHandle) Kill(id ProcessId) error {
 			"pid": procEntry.Pid,
 		})
 	} else {
-		if err := osProcess.Kill(); err != nil {
-			return fmt.Errorf("failed to kill process: %w", err)
+		// Killing the process alone would leave its children running, e.g. the commands
+		// run by a shell, so the whole tree is killed. If that fails, at least the process
+		// itself gets killed.
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(procEntry.Pid)).Run(); err != nil {
+			if err := osProcess.Kill(); err != nil {
+				return fmt.Errorf("failed to kill process: %w", err)
+			}
 		}
 		osProcess.Release()
 	}
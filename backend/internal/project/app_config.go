@@ -41,13 +41,34 @@ func (entrypoint *serializableDaemonEntrypoint) UnmarshalJSON(data []byte) error
 	return fmt.Errorf("failed to unmarshal daemon entrypoint (expected either a string array or a map)")
 }
 
+type DaemonShellConfig struct {
+	// Shell is the shell to run the daemon with, e.g. "sh" or "bash"
+	Shell string `json:"shell"`
+	// Login runs the shell as a login shell, so that the user's profile gets loaded
+	Login bool `json:"login"`
+}
+
+// Gives other apps access to some of an app's topics
+type TopicAccessRule struct {
+	// Category is the category of the app's topics that this rule applies to, relative to the
+	// app's topics and including sub-categories. If empty, the rule applies to all of them.
+	Category []string `json:"category"`
+	// ReadPublic lets every app subscribe to the topics
+	ReadPublic bool `json:"readPublic"`
+	// Grants maps the IDs of other apps to the permissions they get, which can be
+	// "subscribe" and "publish"
+	Grants map[string][]string `json:"grants"`
+}
+
 type serializableRobinAppConfig struct {
-	Id       string                       `json:"id"`
-	Name     string                       `json:"name"`
-	PageIcon string                       `json:"pageIcon"`
-	Page     string                       `json:"page"`
-	Files    []string                     `json:"files"`
-	Daemon   serializableDaemonEntrypoint `json:"daemon"`
+	Id          string                       `json:"id"`
+	Name        string                       `json:"name"`
+	PageIcon    string                       `json:"pageIcon"`
+	Page        string                       `json:"page"`
+	Files       []string                     `json:"files"`
+	Daemon      serializableDaemonEntrypoint `json:"daemon"`
+	DaemonShell *DaemonShellConfig           `json:"daemonShell,omitempty"`
+	TopicAccess []TopicAccessRule            `json:"topicAccess,omitempty"`
 }
 
 type RobinAppConfig struct {
@@ -67,16 +88,24 @@ type RobinAppConfig struct {
 	Files []string
 	// Daemon represents the command that should be run to start the app's daemon
 	Daemon []string
+	// DaemonShell runs the first entry of Daemon as a shell command string, with the
+	// remaining entries as its positional parameters. This allows pipelines, env expansion, etc.
+	DaemonShell *DaemonShellConfig
+	// TopicAccess lists the other apps that can use this app's topics. By default, only
+	// the app itself can.
+	TopicAccess []TopicAccessRule
 }
 
 func (appConfig RobinAppConfig) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&serializableRobinAppConfig{
-		Id:       appConfig.Id,
-		Name:     appConfig.Name,
-		PageIcon: appConfig.PageIcon,
-		Page:     appConfig.Page,
-		Files:    appConfig.Files,
-		Daemon:   appConfig.Daemon,
+		Id:          appConfig.Id,
+		Name:        appConfig.Name,
+		PageIcon:    appConfig.PageIcon,
+		Page:        appConfig.Page,
+		Files:       appConfig.Files,
+		Daemon:      appConfig.Daemon,
+		DaemonShell: appConfig.DaemonShell,
+		TopicAccess: appConfig.TopicAccess,
 	})
 }
 
@@ -92,6 +121,8 @@ func (appConfig *RobinAppConfig) UnmarshalJSON(data []byte) error {
 	appConfig.Page = serializableConfig.Page
 	appConfig.Files = serializableConfig.Files
 	appConfig.Daemon = serializableConfig.Daemon
+	appConfig.DaemonShell = serializableConfig.DaemonShell
+	appConfig.TopicAccess = serializableConfig.TopicAccess
 
 	return nil
 }
@@ -211,6 +242,16 @@ func (appConfig *RobinAppConfig) readRobinAppConfig(projectConfig *RobinProjectC
 		return fmt.Errorf("'name' is required")
 	}
 
+	for _, rule := range appConfig.TopicAccess {
+		for appId, permissions := range rule.Grants {
+			for _, permission := range permissions {
+				if permission != "subscribe" && permission != "publish" {
+					return fmt.Errorf("'topicAccess' grants unknown permission '%s' to app '%s' (expected subscribe or publish)", permission, appId)
+				}
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -42,6 +42,56 @@ type RobinConfig struct {
 
 	// KeyMappings is a map of key mappings
 	KeyMappings map[string]string `json:"keyMappings"`
+
+	// Bridge shares topics with other robin instances. Changes take effect when robin restarts.
+	Bridge *BridgeConfig `json:"bridge,omitempty"`
+}
+
+type BridgeConfig struct {
+	// Listen is the path of a unix socket that other robin instances can connect to. They
+	// can also connect to the websocket at `/api/bridge`.
+	Listen string `json:"listen,omitempty"`
+
+	// Secret is shared with the instances that this one is connected to. Peers have to send
+	// it when they connect, so instances without a secret don't accept any peers. It's also
+	// sent to the peers this instance connects to, unless they have their own secret.
+	Secret string `json:"secret,omitempty"`
+
+	// Accept lists the topics that instances which connect to this one can mirror. Each
+	// peer only gets the parts of what it asks for that are covered by these.
+	Accept []BridgeMirrorConfig `json:"accept,omitempty"`
+
+	// Peers are the robin instances to connect to
+	Peers []BridgePeerConfig `json:"peers,omitempty"`
+}
+
+type BridgePeerConfig struct {
+	// Address is either a robin server's URL, e.g. `ws://localhost:9011`, or the path
+	// of a unix socket that another instance listens on, e.g. `unix:///tmp/robin.sock`
+	Address string `json:"address"`
+
+	// Secret is the peer's bridge secret, which defaults to this instance's own
+	Secret string `json:"secret,omitempty"`
+
+	// Mirror lists the topics that are shared with the peer
+	Mirror []BridgeMirrorConfig `json:"mirror"`
+}
+
+type BridgeMirrorDirection string
+
+const (
+	// Messages published here are sent to the peer
+	BridgeExport BridgeMirrorDirection = "export"
+	// Messages published on the peer are sent here
+	BridgeImport BridgeMirrorDirection = "import"
+	BridgeBoth   BridgeMirrorDirection = "both"
+)
+
+type BridgeMirrorConfig struct {
+	// Prefix is a topic category. Topics in it and its sub-categories are mirrored.
+	Prefix string `json:"prefix"`
+	// Direction defaults to `both`
+	Direction BridgeMirrorDirection `json:"direction,omitempty"`
 }
 
 var defaultRobinConfig = RobinConfig{}
@@ -0,0 +1,69 @@
+package pubsub
+
+import (
+	"errors"
+	"fmt"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+
+type Permission string
+
+const (
+	PermissionSubscribe Permission = "subscribe"
+	PermissionPublish   Permission = "publish"
+)
+
+func ParsePermission(permission string) (Permission, error) {
+	switch Permission(permission) {
+	case PermissionSubscribe, PermissionPublish:
+		return Permission(permission), nil
+	default:
+		return "", fmt.Errorf("unknown topic permission '%s' (expected subscribe or publish)", permission)
+	}
+}
+
+// AnonymousAppId is the app ID of callers that couldn't be identified. It's never a topic's
+// owner or granted permissions, so it can only subscribe to public topics.
+const AnonymousAppId = "<anonymous>"
+
+// TopicAcl controls which apps can use a topic. Access checks are done on behalf of an app
+// ID, and an empty app ID means the caller is robin itself, which can always access every topic.
+// Requests from outside of robin must never be checked with an empty app ID unless robin has
+// verified that they come from its own UI, and should use `AnonymousAppId` otherwise.
+type TopicAcl struct {
+	// Owner is the ID of the app that owns the topic, which can always subscribe and publish.
+	// Topics without an owner belong to robin, and apps can't use them unless granted access.
+	Owner string `json:"owner,omitempty"`
+	// ReadPublic lets every app subscribe to the topic
+	ReadPublic bool `json:"readPublic,omitempty"`
+	// Grants maps the IDs of other apps to the permissions they have on the topic
+	Grants map[string][]Permission `json:"grants,omitempty"`
+}
+
+func (acl TopicAcl) Allows(appId string, permission Permission) bool {
+	if appId == "" || appId == acl.Owner {
+		return true
+	}
+
+	if permission == PermissionSubscribe && acl.ReadPublic {
+		return true
+	}
+
+	for _, granted := range acl.Grants[appId] {
+		if granted == permission {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns an error wrapping `ErrAccessDenied` if the app doesn't have the permission
+func (topic *Topic[_]) CheckAccess(appId string, permission Permission) error {
+	if topic.acl.Allows(appId, permission) {
+		return nil
+	}
+
+	return fmt.Errorf("%w: app '%s' can't %s to topic %s", ErrAccessDenied, appId, permission, topic.Id.String())
+}
@@ -0,0 +1,371 @@
+package pubsub
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"robinplatform.dev/internal/identity"
+	"robinplatform.dev/internal/log"
+)
+
+var logger log.Logger = log.New("pubsub")
+
+const durableLogExt = ".jsonl"
+
+// Durable topics that don't set any retention limits get these, since their logs would
+// otherwise grow forever.
+var defaultDurableRetention = RetentionOptions{MaxMessages: 1000}
+
+// The log is compacted once it has this many times more entries than are retained
+const durableCompactionFactor = 2
+
+// The first line of a durable topic's log
+type durableHeader struct {
+	Id        TopicId          `json:"id"`
+	Retention RetentionOptions `json:"retention"`
+	Schema    json.RawMessage  `json:"schema,omitempty"`
+	Acl       TopicAcl         `json:"acl"`
+	IdleTTL   time.Duration    `json:"idleTtl,omitempty"`
+	// The topic's counter when the log was last compacted. This is needed to restore the
+	// counter if every message in the log has expired.
+	Counter int32 `json:"counter"`
+}
+
+// Every line after the header is an entry
+type durableEntry struct {
+	MessageId   int32           `json:"messageId"`
+	PublishedAt time.Time       `json:"publishedAt"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// An append-only log of a topic's messages. It is owned by the topic, and
+// must only be used while holding the topic's lock.
+type durableLog struct {
+	path    string
+	file    *os.File
+	entries int
+}
+
+func durableLogPath(dir string, id TopicId) string {
+	return filepath.Join(dir, url.PathEscape(id.String())+durableLogExt)
+}
+
+// Reads a durable log. A partially written last line, e.g. from robin getting killed
+// in the middle of a write, is ignored.
+func readDurableLog(path string) (durableHeader, []durableEntry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return durableHeader{}, nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return durableHeader{}, nil, err
+		}
+		return durableHeader{}, nil, fmt.Errorf("durable topic log %s is empty", path)
+	}
+
+	var header durableHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil {
+		return durableHeader{}, nil, fmt.Errorf("failed to parse header of durable topic log %s: %w", path, err)
+	}
+
+	entries := []durableEntry{}
+	for scanner.Scan() {
+		var entry durableEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			logger.Warn("Skipping corrupt entry in durable topic log", log.Ctx{
+				"path": path,
+				"err":  err.Error(),
+			})
+			continue
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return header, entries, scanner.Err()
+}
+
+// Restores the topic's counter and retained messages from its log, if it has one,
+// and then opens the log for appending.
+// Requires caller to take the lock, or the topic to not be shared yet
+func (topic *Topic[T]) openDurableLog(dir string) error {
+	path := durableLogPath(dir, topic.Id)
+
+	header, entries, err := readDurableLog(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	topic.counter = header.Counter
+	for _, entry := range entries {
+		var data T
+		if err := json.Unmarshal(entry.Data, &data); err != nil {
+			logger.Warn("Skipping durable topic message with the wrong type", log.Ctx{
+				"topicId":   topic.Id.String(),
+				"messageId": entry.MessageId,
+				"err":       err.Error(),
+			})
+			continue
+		}
+
+		topic.retained = append(topic.retained, retainedMessage[T]{
+			message:     Message[T]{MessageId: entry.MessageId, Data: data},
+			publishedAt: entry.PublishedAt,
+		})
+
+		if entry.MessageId >= topic.counter {
+			topic.counter = entry.MessageId + 1
+		}
+	}
+
+	topic.trimRetained(time.Now())
+
+	topic.durable = &durableLog{path: path}
+	return topic.compactDurableLog()
+}
+
+// Rewrites the log so that it only contains the retained messages, and reopens it.
+// Requires caller to take the lock
+func (topic *Topic[T]) compactDurableLog() error {
+	if err := os.MkdirAll(filepath.Dir(topic.durable.path), 0755); err != nil {
+		return err
+	}
+
+	tmpPath := topic.durable.path + ".tmp"
+	tmpFile, err := os.Create(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	writer := bufio.NewWriter(tmpFile)
+	encoder := json.NewEncoder(writer)
+
+	err = encoder.Encode(durableHeader{
+		Id:        topic.Id,
+		Retention: topic.retention,
+		Schema:    topic.rawSchema,
+		Acl:       topic.acl,
+		IdleTTL:   topic.idleTTL,
+		Counter:   topic.counter,
+	})
+
+	entries := 0
+	for _, item := range topic.retained {
+		if err != nil {
+			break
+		}
+
+		var data []byte
+		data, err = json.Marshal(item.message.Data)
+		if err == nil {
+			err = encoder.Encode(durableEntry{
+				MessageId:   item.message.MessageId,
+				PublishedAt: item.publishedAt,
+				Data:        data,
+			})
+			entries += 1
+		}
+	}
+
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpPath, topic.durable.path)
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write durable topic log for %s: %w", topic.Id.String(), err)
+	}
+
+	if topic.durable.file != nil {
+		topic.durable.file.Close()
+	}
+
+	file, err := os.OpenFile(topic.durable.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		topic.durable.file = nil
+		return fmt.Errorf("failed to open durable topic log for %s: %w", topic.Id.String(), err)
+	}
+
+	topic.durable.file = file
+	topic.durable.entries = entries
+	return nil
+}
+
+// Requires caller to take the lock
+func (topic *Topic[T]) persist(item retainedMessage[T]) {
+	if topic.durable == nil || topic.durable.file == nil {
+		return
+	}
+
+	err := func() error {
+		data, err := json.Marshal(item.message.Data)
+		if err != nil {
+			return err
+		}
+
+		line, err := json.Marshal(durableEntry{
+			MessageId:   item.message.MessageId,
+			PublishedAt: item.publishedAt,
+			Data:        data,
+		})
+		if err != nil {
+			return err
+		}
+
+		if _, err := topic.durable.file.Write(append(line, '\n')); err != nil {
+			return err
+		}
+
+		topic.durable.entries += 1
+		if topic.durable.entries > durableCompactionFactor*len(topic.retained)+16 {
+			return topic.compactDurableLog()
+		}
+
+		return nil
+	}()
+
+	if err != nil {
+		logger.Warn("Failed to persist message of durable topic", log.Ctx{
+			"topicId":   topic.Id.String(),
+			"messageId": item.message.MessageId,
+			"err":       err.Error(),
+		})
+	}
+}
+
+// Requires caller to take the lock
+func (topic *Topic[T]) closeDurableLog() {
+	if topic.durable == nil || topic.durable.file == nil {
+		return
+	}
+
+	topic.durable.file.Close()
+	topic.durable.file = nil
+}
+
+// Enables durable topics, which are stored in `dir`, and recreates every durable topic found
+// there. Restored topics hold `any` data until they are claimed by `CreateTopic`.
+func (r *Registry) RestoreDurableTopics(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create durable topics directory: %w", err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read durable topics directory: %w", err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.durableDir = dir
+
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), durableLogExt) {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		header, _, err := readDurableLog(path)
+		if err != nil {
+			logger.Warn("Failed to restore durable topic", log.Ctx{
+				"path": path,
+				"err":  err.Error(),
+			})
+			continue
+		}
+
+		topic, err := createTopic[any](r, header.Id, TopicOptions{
+			Durable:   true,
+			Retention: header.Retention,
+			Schema:    header.Schema,
+			Acl:       header.Acl,
+			IdleTTL:   header.IdleTTL,
+		})
+		if err != nil {
+			logger.Warn("Failed to restore durable topic", log.Ctx{
+				"topicId": header.Id.String(),
+				"err":     err.Error(),
+			})
+			continue
+		}
+
+		topic.restored = true
+	}
+
+	return nil
+}
+
+// Closes every topic in the category like `CloseCategory`, and deletes their durable logs,
+// so that they aren't restored when robin restarts. This includes the logs of topics that
+// already expired.
+func (r *Registry) DiscardCategory(category string) {
+	r.CloseCategory(category)
+
+	r.m.Lock()
+	dir := r.durableDir
+	r.m.Unlock()
+
+	if dir == "" {
+		return
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		logger.Warn("Failed to read durable topics directory", log.Ctx{
+			"path": dir,
+			"err":  err.Error(),
+		})
+		return
+	}
+
+	for _, file := range files {
+		name, ok := strings.CutSuffix(file.Name(), durableLogExt)
+		if file.IsDir() || !ok {
+			continue
+		}
+
+		rawId, err := url.PathUnescape(name)
+		if err != nil {
+			continue
+		}
+		id, err := identity.Parse(rawId)
+		if err != nil {
+			continue
+		}
+
+		if id.Category != category && !strings.HasPrefix(id.Category, category+"/") {
+			continue
+		}
+
+		path := filepath.Join(dir, file.Name())
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("Failed to delete durable topic log", log.Ctx{
+				"path": path,
+				"err":  err.Error(),
+			})
+		}
+	}
+}
@@ -0,0 +1,459 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MessageFilter selects which messages a subscriber receives. A message has to pass every
+// condition that is set.
+type MessageFilter struct {
+	// Contains keeps string messages that contain this substring. Other messages are dropped.
+	Contains string `json:"contains,omitempty"`
+	// Match keeps string messages that match this regular expression. Other messages are dropped.
+	Match string `json:"match,omitempty"`
+	// Where keeps messages where every predicate holds
+	Where []FieldPredicate `json:"where,omitempty"`
+}
+
+type PredicateOp string
+
+const (
+	PredicateExists   PredicateOp = "exists"
+	PredicateEq       PredicateOp = "eq"
+	PredicateNe       PredicateOp = "ne"
+	PredicateLt       PredicateOp = "lt"
+	PredicateLte      PredicateOp = "lte"
+	PredicateGt       PredicateOp = "gt"
+	PredicateGte      PredicateOp = "gte"
+	PredicateContains PredicateOp = "contains"
+	PredicateMatches  PredicateOp = "matches"
+)
+
+// FieldPredicate tests a single value inside a message, as the message would be encoded in JSON
+type FieldPredicate struct {
+	// Path is a JSON path to the value, e.g. `$.process.id` or `$.lines[0]`
+	Path string      `json:"path"`
+	Op   PredicateOp `json:"op"`
+	// Value is compared against the value at `Path`. It isn't used by `exists`, and has
+	// to be a regular expression for `matches`.
+	Value any `json:"value,omitempty"`
+}
+
+type pathSegment struct {
+	field string
+	index int
+	// isIndex is set for segments like `[0]`
+	isIndex bool
+}
+
+// Parses a JSON path made of fields and array indices, e.g. `$.a.b[0]`. The leading `$` is optional.
+func parseJsonPath(path string) ([]pathSegment, error) {
+	rest := strings.TrimPrefix(path, "$")
+	if rest != path {
+		rest = strings.TrimPrefix(rest, ".")
+	}
+
+	segments := []pathSegment{}
+	for rest != "" {
+		if strings.HasPrefix(rest, "[") {
+			end := strings.IndexByte(rest, ']')
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed '[' in JSON path '%s'", path)
+			}
+
+			index, err := strconv.Atoi(rest[1:end])
+			if err != nil || index < 0 {
+				return nil, fmt.Errorf("invalid array index '%s' in JSON path '%s'", rest[1:end], path)
+			}
+
+			segments = append(segments, pathSegment{index: index, isIndex: true})
+			rest = strings.TrimPrefix(rest[end+1:], ".")
+			continue
+		}
+
+		end := strings.IndexAny(rest, ".[")
+		if end == -1 {
+			end = len(rest)
+		}
+		if end == 0 {
+			return nil, fmt.Errorf("empty field name in JSON path '%s'", path)
+		}
+
+		segments = append(segments, pathSegment{field: rest[:end]})
+		rest = strings.TrimPrefix(rest[end:], ".")
+	}
+
+	return segments, nil
+}
+
+func lookupJsonPath(value any, segments []pathSegment) (any, bool) {
+	for _, segment := range segments {
+		if segment.isIndex {
+			array, ok := value.([]any)
+			if !ok || segment.index >= len(array) {
+				return nil, false
+			}
+			value = array[segment.index]
+		} else {
+			object, ok := value.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			value, ok = object[segment.field]
+			if !ok {
+				return nil, false
+			}
+		}
+	}
+
+	return value, true
+}
+
+// Compares two values decoded from JSON, returning false if they can't be ordered
+func compareJsonValues(a, b any) (int, bool) {
+	switch a := a.(type) {
+	case float64:
+		if b, ok := b.(float64); ok {
+			switch {
+			case a < b:
+				return -1, true
+			case a > b:
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b), true
+		}
+	}
+
+	return 0, false
+}
+
+type compiledPredicate struct {
+	path   []pathSegment
+	op     PredicateOp
+	value  any
+	regexp *regexp.Regexp
+}
+
+func (predicate *compiledPredicate) test(message any) bool {
+	value, found := lookupJsonPath(message, predicate.path)
+	if predicate.op == PredicateExists {
+		return found
+	}
+	if !found {
+		return false
+	}
+
+	switch predicate.op {
+	case PredicateEq:
+		return reflect.DeepEqual(value, predicate.value)
+	case PredicateNe:
+		return !reflect.DeepEqual(value, predicate.value)
+	case PredicateLt, PredicateLte, PredicateGt, PredicateGte:
+		cmp, ok := compareJsonValues(value, predicate.value)
+		if !ok {
+			return false
+		}
+		switch predicate.op {
+		case PredicateLt:
+			return cmp < 0
+		case PredicateLte:
+			return cmp <= 0
+		case PredicateGt:
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	case PredicateContains:
+		switch value := value.(type) {
+		case string:
+			needle, ok := predicate.value.(string)
+			return ok && strings.Contains(value, needle)
+		case []any:
+			for _, item := range value {
+				if reflect.DeepEqual(item, predicate.value) {
+					return true
+				}
+			}
+		}
+		return false
+	case PredicateMatches:
+		str, ok := value.(string)
+		return ok && predicate.regexp.MatchString(str)
+	}
+
+	return false
+}
+
+// Normalizes a value to the types produced by decoding JSON, so that predicates see
+// structs and maps the same way.
+func toJsonValue(value any) (any, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded any
+	err = json.Unmarshal(buf, &decoded)
+	return decoded, err
+}
+
+// Returns a function that tests whether a message's data passes the filter
+func (filter MessageFilter) compile() (func(data any) bool, error) {
+	var match *regexp.Regexp
+	if filter.Match != "" {
+		var err error
+		match, err = regexp.Compile(filter.Match)
+		if err != nil {
+			return nil, fmt.Errorf("invalid filter regular expression: %w", err)
+		}
+	}
+
+	predicates := make([]compiledPredicate, 0, len(filter.Where))
+	for _, where := range filter.Where {
+		path, err := parseJsonPath(where.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		predicate := compiledPredicate{path: path, op: where.Op}
+
+		switch where.Op {
+		case PredicateExists:
+		case PredicateEq, PredicateNe, PredicateLt, PredicateLte, PredicateGt, PredicateGte, PredicateContains:
+			predicate.value, err = toJsonValue(where.Value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for filter on '%s': %w", where.Path, err)
+			}
+		case PredicateMatches:
+			pattern, ok := where.Value.(string)
+			if !ok {
+				return nil, fmt.Errorf("filter on '%s' needs a regular expression string to match", where.Path)
+			}
+			predicate.regexp, err = regexp.Compile(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression for filter on '%s': %w", where.Path, err)
+			}
+		default:
+			return nil, fmt.Errorf("unknown filter operation '%s'", where.Op)
+		}
+
+		predicates = append(predicates, predicate)
+	}
+
+	return func(data any) bool {
+		if filter.Contains != "" || match != nil {
+			str, ok := data.(string)
+			if !ok {
+				return false
+			}
+			if filter.Contains != "" && !strings.Contains(str, filter.Contains) {
+				return false
+			}
+			if match != nil && !match.MatchString(str) {
+				return false
+			}
+		}
+
+		if len(predicates) == 0 {
+			return true
+		}
+
+		value, err := toJsonValue(data)
+		if err != nil {
+			return false
+		}
+
+		for i := range predicates {
+			if !predicates[i].test(value) {
+				return false
+			}
+		}
+
+		return true
+	}, nil
+}
+
+// ThrottleOptions limit how many messages a subscriber receives. They are applied after the
+// filter, in the order they're listed here.
+type ThrottleOptions struct {
+	// SampleEvery only keeps every Nth message
+	SampleEvery int `json:"sampleEvery,omitempty"`
+	// MaxPerSecond drops messages that arrive faster than this rate, allowing short
+	// bursts of up to one second's worth of messages
+	MaxPerSecond float64 `json:"maxPerSecond,omitempty"`
+	// Debounce holds back each message until no newer message has arrived for this long,
+	// and then only delivers the latest one
+	Debounce time.Duration `json:"debounce,omitempty"`
+}
+
+func (opts ThrottleOptions) validate() error {
+	if opts.SampleEvery < 0 {
+		return fmt.Errorf("throttle can't sample every %d messages", opts.SampleEvery)
+	}
+
+	if opts.MaxPerSecond < 0 || math.IsNaN(opts.MaxPerSecond) || math.IsInf(opts.MaxPerSecond, 0) {
+		return fmt.Errorf("throttle rate must be a positive number, got %v", opts.MaxPerSecond)
+	}
+
+	if opts.Debounce < 0 {
+		return fmt.Errorf("throttle debounce must not be negative, got %s", opts.Debounce)
+	}
+
+	return nil
+}
+
+// RefineOptions filter and throttle the messages of a subscription, for subscribers like
+// browsers that only want a small part of a busy topic.
+type RefineOptions struct {
+	Filter   *MessageFilter  `json:"filter,omitempty"`
+	Throttle ThrottleOptions `json:"throttle,omitempty"`
+}
+
+// Tracks the state of the rate limits that don't need a timer
+type throttleState struct {
+	opts ThrottleOptions
+
+	seen       int
+	tokens     float64
+	lastRefill time.Time
+}
+
+func (state *throttleState) admit(now time.Time) bool {
+	state.seen += 1
+	if state.opts.SampleEvery > 1 && (state.seen-1)%state.opts.SampleEvery != 0 {
+		return false
+	}
+
+	if state.opts.MaxPerSecond > 0 {
+		burst := math.Max(1, state.opts.MaxPerSecond)
+		if state.lastRefill.IsZero() {
+			state.tokens = burst
+		} else {
+			elapsed := now.Sub(state.lastRefill).Seconds()
+			state.tokens = math.Min(burst, state.tokens+elapsed*state.opts.MaxPerSecond)
+		}
+		state.lastRefill = now
+
+		if state.tokens < 1 {
+			return false
+		}
+		state.tokens -= 1
+	}
+
+	return true
+}
+
+// Filters and throttles the messages from `in`. The returned channel is closed once `in` is
+// closed, after delivering any debounced message, or once the context is done. If the options
+// don't filter or throttle anything, `in` is returned as is.
+//
+// Messages that are held back or dropped don't block the topic, so the subscription's own
+// backpressure policy only applies to messages that make it through.
+func Refine[T any](ctx context.Context, in <-chan Message[T], opts RefineOptions) (<-chan Message[T], error) {
+	if err := opts.Throttle.validate(); err != nil {
+		return nil, err
+	}
+
+	keep := func(any) bool { return true }
+	if opts.Filter != nil {
+		var err error
+		keep, err = opts.Filter.compile()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.Filter == nil && opts.Throttle == (ThrottleOptions{}) {
+		return in, nil
+	}
+
+	out := make(chan Message[T])
+	go func() {
+		defer close(out)
+
+		throttle := throttleState{opts: opts.Throttle}
+
+		var pending *Message[T]
+		var timer *time.Timer
+		var timerC <-chan time.Time
+		defer func() {
+			if timer != nil {
+				timer.Stop()
+			}
+		}()
+
+		send := func(message Message[T]) bool {
+			select {
+			case out <- message:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case message, ok := <-in:
+				if !ok {
+					if pending != nil {
+						send(*pending)
+					}
+					return
+				}
+
+				if !keep(message.Data) || !throttle.admit(time.Now()) {
+					continue
+				}
+
+				if opts.Throttle.Debounce == 0 {
+					if !send(message) {
+						return
+					}
+					continue
+				}
+
+				pending = &message
+				if timer == nil {
+					timer = time.NewTimer(opts.Throttle.Debounce)
+				} else {
+					if !timer.Stop() {
+						select {
+						case <-timer.C:
+						default:
+						}
+					}
+					timer.Reset(opts.Throttle.Debounce)
+				}
+				timerC = timer.C
+
+			case <-timerC:
+				timerC = nil
+				if pending != nil {
+					message := *pending
+					pending = nil
+					if !send(message) {
+						return
+					}
+				}
+
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
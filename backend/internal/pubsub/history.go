@@ -0,0 +1,117 @@
+package pubsub
+
+import (
+	"fmt"
+	"time"
+)
+
+// Retention is never allowed to grow past this many messages, even if the topic
+// only asked for an age limit.
+const maxRetainedMessages = 10_000
+
+type RetentionOptions struct {
+	// MaxMessages is the number of most recent messages to keep
+	MaxMessages int `json:"maxMessages,omitempty"`
+	// MaxAge is how long a message is kept after it is published
+	MaxAge time.Duration `json:"maxAge,omitempty"`
+}
+
+func (opts RetentionOptions) enabled() bool {
+	return opts.MaxMessages > 0 || opts.MaxAge > 0
+}
+
+func (opts RetentionOptions) validate() error {
+	if opts.MaxMessages < 0 || opts.MaxMessages > maxRetainedMessages {
+		return fmt.Errorf("topic can retain between 0 and %d messages, got %d", maxRetainedMessages, opts.MaxMessages)
+	}
+
+	if opts.MaxAge < 0 {
+		return fmt.Errorf("topic retention age must not be negative, got %s", opts.MaxAge)
+	}
+
+	return nil
+}
+
+// Cursor selects which retained messages a new subscriber receives before live messages.
+// The zero value only delivers live messages.
+type Cursor struct {
+	// FromMessageId delivers every retained message with this ID or later
+	FromMessageId *int32 `json:"fromMessageId,omitempty"`
+	// Last delivers up to this many of the most recent retained messages
+	Last int `json:"last,omitempty"`
+}
+
+func (cursor Cursor) validate() error {
+	if cursor.FromMessageId != nil && cursor.Last != 0 {
+		return fmt.Errorf("subscription cursor can't set both fromMessageId and last")
+	}
+
+	if cursor.Last < 0 {
+		return fmt.Errorf("subscription cursor can't ask for the last %d messages", cursor.Last)
+	}
+
+	return nil
+}
+
+type retainedMessage[T any] struct {
+	message     Message[T]
+	publishedAt time.Time
+}
+
+// Requires caller to take the lock
+func (topic *Topic[T]) retain(message Message[T], now time.Time) {
+	if !topic.retention.enabled() {
+		return
+	}
+
+	topic.retained = append(topic.retained, retainedMessage[T]{message: message, publishedAt: now})
+	topic.trimRetained(now)
+}
+
+// Requires caller to take the lock
+func (topic *Topic[T]) trimRetained(now time.Time) {
+	limit := topic.retention.MaxMessages
+	if limit == 0 {
+		limit = maxRetainedMessages
+	}
+
+	start := 0
+	if len(topic.retained) > limit {
+		start = len(topic.retained) - limit
+	}
+
+	if topic.retention.MaxAge > 0 {
+		cutoff := now.Add(-topic.retention.MaxAge)
+		for start < len(topic.retained) && topic.retained[start].publishedAt.Before(cutoff) {
+			start += 1
+		}
+	}
+
+	topic.retained = topic.retained[start:]
+}
+
+// Returns the retained messages selected by the cursor, oldest first.
+// Requires caller to take the lock
+func (topic *Topic[T]) backlog(cursor Cursor) []Message[T] {
+	if cursor.FromMessageId == nil && cursor.Last == 0 {
+		return nil
+	}
+
+	topic.trimRetained(time.Now())
+
+	start := 0
+	if cursor.FromMessageId != nil {
+		for start < len(topic.retained) && topic.retained[start].message.MessageId < *cursor.FromMessageId {
+			start += 1
+		}
+	} else if len(topic.retained) > cursor.Last {
+		start = len(topic.retained) - cursor.Last
+	}
+
+	messages := make([]Message[T], 0, len(topic.retained)-start)
+	for _, item := range topic.retained[start:] {
+		messages = append(messages, item.message)
+	}
+
+	return messages
+}
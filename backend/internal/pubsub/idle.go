@@ -0,0 +1,85 @@
+package pubsub
+
+import (
+	"strings"
+	"time"
+)
+
+// Called when a subscriber leaves. Publishing only updates `lastActive`, since the
+// idle timer checks it before expiring the topic.
+// Requires caller to take the lock
+func (topic *Topic[_]) markActive(now time.Time) {
+	topic.lastActive = now
+
+	// The timer isn't rescheduled while the topic has subscribers, so it has to be
+	// restarted once they're all gone.
+	if topic.idleTimer != nil && len(topic.subscribers) == 0 {
+		topic.idleTimer.Reset(topic.idleTTL)
+	}
+}
+
+func (topic *Topic[_]) startIdleTimer() {
+	if topic.idleTTL <= 0 {
+		return
+	}
+
+	// The timer's callback takes the lock, so it can't run before the timer is set
+	topic.m.Lock()
+	defer topic.m.Unlock()
+
+	topic.lastActive = time.Now()
+	topic.idleTimer = time.AfterFunc(topic.idleTTL, topic.expireIfIdle)
+}
+
+func (topic *Topic[_]) expireIfIdle() {
+	topic.m.Lock()
+
+	if topic.closed || len(topic.subscribers) > 0 {
+		topic.m.Unlock()
+		return
+	}
+
+	if idle := time.Since(topic.lastActive); idle < topic.idleTTL {
+		topic.idleTimer.Reset(topic.idleTTL - idle)
+		topic.m.Unlock()
+		return
+	}
+
+	topic.closeLocked()
+	topic.m.Unlock()
+
+	topic.registry.evict(topic)
+}
+
+// Removes a closed topic from the registry, unless it has already been replaced by a new
+// topic with the same ID
+func (r *Registry) evict(topic anyTopic) {
+	if r == nil {
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	key := topic.GetId().String()
+	if r.topics[key] == topic {
+		delete(r.topics, key)
+	}
+}
+
+// Closes every topic in the category, including its sub-categories
+func (r *Registry) CloseCategory(category string) {
+	r.m.Lock()
+	topics := []anyTopic{}
+	for _, topic := range r.topics {
+		topicCategory := topic.GetId().Category
+		if topicCategory == category || strings.HasPrefix(topicCategory, category+"/") {
+			topics = append(topics, topic)
+		}
+	}
+	r.m.Unlock()
+
+	for _, topic := range topics {
+		topic.Close()
+	}
+}
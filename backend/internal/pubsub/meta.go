@@ -0,0 +1,97 @@
+package pubsub
+
+import (
+	"sync"
+)
+
+type MetaTopicInfo struct {
+	Kind string `json:"kind"`
+	Data any    `json:"data"`
+}
+
+// Sends changes to the registry's topics to the meta topic.
+//
+// Changes are queued while holding the lock of whatever changed, so that they're queued in
+// the same order that they happened. The queue is unbounded and only guarded by its own lock,
+// so queueing never waits on anything. The meta topic is published to by a single goroutine
+// that doesn't hold any other lock, so slow meta topic subscribers only delay notifications,
+// and can't block the topics they're about.
+type metaNotifier struct {
+	m     sync.Mutex
+	queue []MetaTopicInfo
+	// The number of changes queued so far. Since the meta topic only gets messages from
+	// this notifier, this is also the ID of the meta topic's next message.
+	queued int32
+
+	// Has an item whenever the queue might not be empty
+	wake chan struct{}
+}
+
+func newMetaNotifier() *metaNotifier {
+	return &metaNotifier{wake: make(chan struct{}, 1)}
+}
+
+// Queues a change. This does nothing if the registry has no meta topic.
+func (n *metaNotifier) notify(info MetaTopicInfo) {
+	if n == nil {
+		return
+	}
+
+	n.m.Lock()
+	n.queue = append(n.queue, info)
+	n.queued += 1
+	n.m.Unlock()
+
+	select {
+	case n.wake <- struct{}{}:
+	default:
+		// The publishing goroutine is already going to check the queue
+	}
+}
+
+// Returns the ID that the next queued change will have in the meta topic
+func (n *metaNotifier) counter() int32 {
+	if n == nil {
+		return 0
+	}
+
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	return n.queued
+}
+
+func (n *metaNotifier) run(meta *Topic[MetaTopicInfo]) {
+	for range n.wake {
+		n.m.Lock()
+		batch := n.queue
+		n.queue = nil
+		n.m.Unlock()
+
+		for _, item := range batch {
+			meta.Publish(item)
+		}
+	}
+}
+
+func (r *Registry) CreateMetaTopics() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	notifier := newMetaNotifier()
+	r.notifier = notifier
+
+	// Lazily create meta topic
+	meta, err := createTopic[MetaTopicInfo](r, MetaTopic, TopicOptions{})
+	if err != nil {
+		return err
+	}
+
+	go notifier.run(meta)
+
+	// A few recent summaries are kept, so that new subscribers can start out with some history
+	_, err = createTopic[RegistryMetrics](r, MetricsTopic, TopicOptions{
+		Retention: RetentionOptions{MaxMessages: 12},
+	})
+	return err
+}
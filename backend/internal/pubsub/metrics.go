@@ -0,0 +1,205 @@
+package pubsub
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"sort"
+	"time"
+)
+
+// Rates are averaged over roughly this long, with recent messages weighing more
+const rateWindow = 10 * time.Second
+
+// The number of topics listed in `RegistryMetrics.BusiestTopics`
+const busiestTopicsCount = 10
+
+// An exponentially weighted moving average of how often something happens, in units per second
+type rateMeter struct {
+	rate    float64
+	updated time.Time
+}
+
+func (meter *rateMeter) at(now time.Time) float64 {
+	if meter.updated.IsZero() {
+		return 0
+	}
+
+	elapsed := now.Sub(meter.updated).Seconds()
+	if elapsed <= 0 {
+		return meter.rate
+	}
+
+	return meter.rate * math.Exp(-elapsed/rateWindow.Seconds())
+}
+
+func (meter *rateMeter) add(now time.Time, amount float64) {
+	meter.rate = meter.at(now) + amount/rateWindow.Seconds()
+	meter.updated = now
+}
+
+// Tracks what's been published to a topic. It's owned by the topic, and must
+// only be used while holding the topic's lock.
+type publishStats struct {
+	published     int64
+	bytes         int64
+	lastPublished time.Time
+	messageRate   rateMeter
+	byteRate      rateMeter
+}
+
+func (stats *publishStats) record(now time.Time, size int) {
+	stats.published += 1
+	stats.bytes += int64(size)
+	stats.lastPublished = now
+	stats.messageRate.add(now, 1)
+	stats.byteRate.add(now, float64(size))
+}
+
+func (stats *publishStats) info(now time.Time) PublishStatsInfo {
+	info := PublishStatsInfo{
+		Published:      stats.published,
+		Bytes:          stats.bytes,
+		MessagesPerSec: stats.messageRate.at(now),
+		BytesPerSec:    stats.byteRate.at(now),
+	}
+
+	if !stats.lastPublished.IsZero() {
+		lastPublished := stats.lastPublished
+		info.LastPublishedAt = &lastPublished
+	}
+
+	return info
+}
+
+type PublishStatsInfo struct {
+	// The number of messages published since the topic was created
+	Published int64 `json:"published"`
+	// The total size of the published messages, as JSON. Only strings, messages that are
+	// already encoded, and messages published with their size are measured, other
+	// messages count as 0 bytes.
+	Bytes           int64      `json:"bytes"`
+	LastPublishedAt *time.Time `json:"lastPublishedAt,omitempty"`
+	// Recent publish rates, averaged over about 10 seconds
+	MessagesPerSec float64 `json:"messagesPerSec"`
+	BytesPerSec    float64 `json:"bytesPerSec"`
+}
+
+// Returns the approximate size of a message as JSON. This is called while publishing,
+// with the topic locked, so messages are never encoded just to measure them, and only
+// strings and bytes get counted.
+func messageSize(message any) int {
+	switch message := message.(type) {
+	case string:
+		return len(message) + 2
+	case json.RawMessage:
+		return len(message)
+	case []byte:
+		return len(message)
+	default:
+		return 0
+	}
+}
+
+// A summary of every topic in the registry
+type RegistryMetrics struct {
+	CollectedAt          time.Time `json:"collectedAt"`
+	Topics               int       `json:"topics"`
+	Subscribers          int       `json:"subscribers"`
+	PatternSubscriptions int       `json:"patternSubscriptions"`
+	// The number of messages waiting in subscribers' buffers
+	QueueDepth int   `json:"queueDepth"`
+	Dropped    int64 `json:"dropped"`
+	// Published, bytes and rates are totals over every topic that's currently open
+	PublishStatsInfo
+	// The topics with the highest publish rates, busiest first
+	BusiestTopics []TopicInfo `json:"busiestTopics"`
+}
+
+type PubsubMetrics struct {
+	Summary RegistryMetrics      `json:"summary"`
+	Topics  map[string]TopicInfo `json:"topics"`
+}
+
+// Collects metrics for every topic in the registry
+func (r *Registry) GetMetrics() PubsubMetrics {
+	r.m.Lock()
+	patternSubscriptions := len(r.patterns)
+	r.m.Unlock()
+
+	info := r.GetTopicInfo()
+
+	summary := RegistryMetrics{
+		CollectedAt:          time.Now(),
+		Topics:               len(info.Info),
+		PatternSubscriptions: patternSubscriptions,
+	}
+
+	topics := make([]TopicInfo, 0, len(info.Info))
+	for _, topic := range info.Info {
+		topics = append(topics, topic)
+
+		summary.Subscribers += topic.SubscriberCount
+		summary.Dropped += topic.Dropped
+		summary.Published += topic.Published
+		summary.Bytes += topic.Bytes
+		summary.MessagesPerSec += topic.MessagesPerSec
+		summary.BytesPerSec += topic.BytesPerSec
+
+		for _, sub := range topic.Subscribers {
+			summary.QueueDepth += sub.QueueDepth
+		}
+
+		if topic.LastPublishedAt != nil && (summary.LastPublishedAt == nil || topic.LastPublishedAt.After(*summary.LastPublishedAt)) {
+			summary.LastPublishedAt = topic.LastPublishedAt
+		}
+	}
+
+	sort.Slice(topics, func(i, j int) bool {
+		if topics[i].MessagesPerSec != topics[j].MessagesPerSec {
+			return topics[i].MessagesPerSec > topics[j].MessagesPerSec
+		}
+		return topics[i].Id.String() < topics[j].Id.String()
+	})
+
+	if len(topics) > busiestTopicsCount {
+		topics = topics[:busiestTopicsCount]
+	}
+	summary.BusiestTopics = topics
+
+	return PubsubMetrics{
+		Summary: summary,
+		Topics:  info.Info,
+	}
+}
+
+// Publishes a summary of the registry's metrics to the metrics topic every interval, until
+// the context is cancelled. Nothing is collected while the topic has no subscribers.
+func (r *Registry) PublishMetrics(ctx context.Context, interval time.Duration) {
+	topicUntyped, err := getTopic(r, MetricsTopic)
+	if err != nil {
+		return
+	}
+
+	topic, ok := topicUntyped.(*Topic[RegistryMetrics])
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if topic.GetInfo().SubscriberCount == 0 {
+				continue
+			}
+
+			topic.Publish(r.GetMetrics().Summary)
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,242 @@
+package pubsub
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+)
+
+// TopicPattern matches the categories of topics. Each segment of the pattern is matched
+// against one segment of a category using `path.Match` syntax, and a `**` segment matches
+// any number of segments, including none. For example, `/logs/app/*` matches the logs of
+// processes in direct sub-categories of `/app`, and `/logs/**` matches every logs topic.
+type TopicPattern struct {
+	pattern  string
+	segments []string
+}
+
+func ParseTopicPattern(pattern string) (TopicPattern, error) {
+	if !strings.HasPrefix(pattern, "/") {
+		return TopicPattern{}, fmt.Errorf("topic pattern '%s' must start with a '/'", pattern)
+	}
+
+	segments := splitCategory(pattern)
+	for _, segment := range segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return TopicPattern{}, fmt.Errorf("invalid topic pattern '%s': %w", pattern, err)
+		}
+	}
+
+	return TopicPattern{pattern: pattern, segments: segments}, nil
+}
+
+var patternEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "?", `\?`, "[", `\[`)
+
+// Escapes a category so that it only matches itself when used as part of a pattern
+func EscapeCategory(category string) string {
+	return patternEscaper.Replace(category)
+}
+
+func (pattern TopicPattern) String() string {
+	return pattern.pattern
+}
+
+func (pattern TopicPattern) Matches(id TopicId) bool {
+	return matchSegments(pattern.segments, splitCategory(id.Category))
+}
+
+func splitCategory(category string) []string {
+	trimmed := strings.Trim(category, "/")
+	if trimmed == "" {
+		return nil
+	}
+
+	return strings.Split(trimmed, "/")
+}
+
+func matchSegments(pattern []string, category []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for skip := 0; skip <= len(category); skip++ {
+				if matchSegments(pattern[1:], category[skip:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(category) == 0 {
+			return false
+		}
+
+		// The pattern was validated when it was parsed
+		if matched, _ := path.Match(pattern[0], category[0]); !matched {
+			return false
+		}
+
+		pattern = pattern[1:]
+		category = category[1:]
+	}
+
+	return len(category) == 0
+}
+
+// A message received through a pattern subscription, tagged with the topic it came from
+type TaggedMessage struct {
+	TopicId TopicId `json:"topicId"`
+	Message[any]
+}
+
+type PatternSubscription struct {
+	// Out is never closed, since new topics matching the pattern can be created at any time
+	Out         <-chan TaggedMessage
+	Unsubscribe func()
+}
+
+type patternSubscription struct {
+	pattern TopicPattern
+	queue   *queue[TaggedMessage]
+	// Topics that this app can't subscribe to are skipped
+	appId string
+
+	// This mutex controls the reading and writing of the `attached` and `detached` fields
+	m        sync.Mutex
+	attached []attachedTopic
+	// Set once the subscription is gone, so that topics attached afterwards get detached
+	detached bool
+}
+
+type attachedTopic struct {
+	topic  anyTopic
+	detach func()
+}
+
+// This takes the topic's lock, which a publisher can hold for as long as one of its subscribers
+// is full, so it must not be called while holding the registry's lock unless the topic
+// hasn't been shared yet.
+func (sub *patternSubscription) attach(topic anyTopic) {
+	if !sub.pattern.Matches(topic.GetId()) || topic.CheckAccess(sub.appId, PermissionSubscribe) != nil {
+		return
+	}
+
+	detach, err := topic.addPatternSubscriber(sub.queue)
+	if err != nil {
+		// The topic was closed in the meantime
+		return
+	}
+
+	sub.m.Lock()
+	defer sub.m.Unlock()
+
+	if sub.detached {
+		// The subscription was removed while this topic was being attached
+		detach()
+		return
+	}
+
+	// Closed topics have already dropped their subscribers, so there's no point
+	// in holding onto them. This keeps the list from growing as topics get recreated.
+	attached := sub.attached[:0]
+	for _, item := range sub.attached {
+		if !item.topic.IsClosed() {
+			attached = append(attached, item)
+		}
+	}
+
+	sub.attached = append(attached, attachedTopic{topic: topic, detach: detach})
+}
+
+func (sub *patternSubscription) detachAll() {
+	sub.m.Lock()
+	attached := sub.attached
+	sub.attached = nil
+	sub.detached = true
+	sub.m.Unlock()
+
+	for _, item := range attached {
+		item.detach()
+	}
+}
+
+func (topic *Topic[T]) addPatternSubscriber(q *queue[TaggedMessage]) (func(), error) {
+	sub := &channelSubscriber[T, TaggedMessage]{
+		queue: q,
+		convert: func(message Message[T]) TaggedMessage {
+			return TaggedMessage{
+				TopicId: topic.Id,
+				Message: Message[any]{
+					MessageId: message.MessageId,
+					Data:      message.Data,
+					Via:       message.Via,
+				},
+			}
+		},
+	}
+
+	if err := topic.addSubscriber(sub, Cursor{}); err != nil {
+		return nil, err
+	}
+
+	return func() {
+		topic.removeSubscriber(sub)
+	}, nil
+}
+
+// Subscribes to every open topic whose category matches the pattern, including topics
+// that get created after this call. If `opts.AppId` is set, topics that the app isn't
+// allowed to subscribe to are skipped. Cursors aren't supported, since topics created later
+// have nothing to replay anyway.
+func SubscribePattern(r *Registry, pattern TopicPattern, opts SubscribeOptions) (PatternSubscription, error) {
+	if opts.Cursor != (Cursor{}) {
+		return PatternSubscription{}, fmt.Errorf("cursors aren't supported when subscribing to a topic pattern")
+	}
+
+	opts, err := opts.normalize()
+	if err != nil {
+		return PatternSubscription{}, err
+	}
+
+	sub := &patternSubscription{
+		pattern: pattern,
+		queue:   newQueue[TaggedMessage](opts),
+		appId:   opts.AppId,
+	}
+
+	// Attaching to a topic has to wait while it's publishing to a blocking subscriber, so
+	// the registry isn't locked while doing that. Topics created in the meantime are
+	// attached by `createTopic`, since the subscription is already registered.
+	r.m.Lock()
+	if r.patterns == nil {
+		r.patterns = make(map[*patternSubscription]struct{}, 4)
+	}
+	r.patterns[sub] = struct{}{}
+
+	topics := make([]anyTopic, 0, len(r.topics))
+	for _, topic := range r.topics {
+		topics = append(topics, topic)
+	}
+	r.m.Unlock()
+
+	for _, topic := range topics {
+		sub.attach(topic)
+	}
+
+	unsubscribe := func() {
+		// A publisher might be blocked on this subscription while holding a topic's lock
+		sub.queue.stop()
+
+		r.m.Lock()
+		delete(r.patterns, sub)
+		r.m.Unlock()
+
+		// No new topics can get attached once the subscription is out of the registry
+		sub.detachAll()
+	}
+
+	return PatternSubscription{
+		Out:         sub.queue.out,
+		Unsubscribe: unsubscribe,
+	}, nil
+}
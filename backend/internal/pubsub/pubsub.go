@@ -1,11 +1,16 @@
 package pubsub
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/santhosh-tekuri/jsonschema/v5"
 	"robinplatform.dev/internal/identity"
 )
 
@@ -19,14 +24,17 @@ func init() {
 }
 
 var (
-	ErrTopicClosed      error = errors.New("tried to operate on a closed topic")
-	ErrTopicDoesntExist error = errors.New("tried to operate on a topic that doesn't exist")
-	ErrTopicExists      error = errors.New("tried to create a topic that already exists")
-	ErrNilSubscriber    error = errors.New("used a nil channel when subscribing")
+	ErrTopicClosed         error = errors.New("tried to operate on a closed topic")
+	ErrTopicDoesntExist    error = errors.New("tried to operate on a topic that doesn't exist")
+	ErrTopicExists         error = errors.New("tried to create a topic that already exists")
+	ErrNilSubscriber       error = errors.New("used a nil channel when subscribing")
+	ErrInvalidTopicOptions error = errors.New("invalid topic options")
 )
 
 var (
 	MetaTopic TopicId = TopicId{Category: "/topics", Key: "meta"}
+	// Gets a summary of the registry's metrics while `PublishMetrics` is running
+	MetricsTopic TopicId = TopicId{Category: "/topics", Key: "metrics"}
 )
 
 // Identifier of a topic
@@ -39,26 +47,65 @@ func (topic TopicId) String() string {
 type Message[T any] struct {
 	MessageId int32 `json:"messageId"` // The counter value when this message was sent
 	Data      T     `json:"data"`      // The data associated with this message
+	// Via lists the robin instances that a message received over a bridge has passed
+	// through, starting with the one it was published on. It's empty for local messages.
+	Via []string `json:"via,omitempty"`
 }
 
 type Topic[T any] struct {
 	// `id` is only set at creation time and isn't written to afterwards.
 	Id TopicId
-	// `metaChannel` is only set at creation time and isn't written to afterwards.
-	metaChannel chan MetaTopicInfo
-
-	// This mutex controls the reading and writing of the
-	// `subscribers` and `closed` fields.
+	// `registry` and `notifier` are only set at creation time and aren't written to afterwards.
+	registry *Registry
+	notifier *metaNotifier
+	// `retention` is only set at creation time and isn't written to afterwards.
+	retention RetentionOptions
+	// `schema` and `rawSchema` are only set at creation time and aren't written to afterwards.
+	schema    *jsonschema.Schema
+	rawSchema json.RawMessage
+	// `acl` is only set at creation time and isn't written to afterwards.
+	acl TopicAcl
+	// `idleTTL` and `idleTimer` are only set at creation time and aren't written to
+	// afterwards. The timer is only set if the topic has an idle TTL.
+	idleTTL   time.Duration
+	idleTimer *time.Timer
+	// `restored` is set for durable topics recreated from disk, until someone claims them
+	// with `CreateTopic`. It is controlled by the registry's mutex.
+	restored bool
+
+	// This mutex controls the reading and writing of the `subscribers`, `counter`, `dropped`,
+	// `retained`, `lastActive`, `stats` and `closed` fields.
 	m sync.Mutex
 
 	counter     int32
 	closed      bool
-	subscribers []chan Message[T]
+	subscribers []subscriber[T]
+	retained    []retainedMessage[T]
+	// Only set for durable topics
+	durable *durableLog
+
+	// The total number of messages dropped by this topic's subscribers, including
+	// subscribers that have since unsubscribed
+	dropped int64
+
+	// The last time a message was published or a subscriber left
+	lastActive time.Time
+
+	stats publishStats
 }
 
 type anyTopic interface {
-	addAnySubscriber() (Subscription[any], error)
+	addAnySubscriber(opts SubscribeOptions) (Subscription[any], error)
+	addPatternSubscriber(q *queue[TaggedMessage]) (func(), error)
+	publishAny(data any, via []string) error
+	sendAny(data any) error
+	validateAny(data any) error
+	GetId() TopicId
+	CheckAccess(appId string, permission Permission) error
 	IsClosed() bool
+	Close()
+	shutdown()
+	isRestored() bool
 	GetInfo() TopicInfo
 }
 
@@ -67,99 +114,122 @@ type Subscription[T any] struct {
 	Unsubscribe func()
 }
 
-func (topic *Topic[T]) addSubscriber() (<-chan Message[T], error) {
+// Requires caller to take the lock
+func (topic *Topic[_]) info() TopicInfo {
+	subscribers := make([]SubscriberInfo, 0, len(topic.subscribers))
+	for _, sub := range topic.subscribers {
+		subscribers = append(subscribers, sub.info())
+	}
+
+	return TopicInfo{
+		Id:               topic.Id,
+		Counter:          topic.counter,
+		Closed:           topic.closed,
+		SubscriberCount:  len(topic.subscribers),
+		Dropped:          topic.dropped,
+		Subscribers:      subscribers,
+		Retention:        topic.retention,
+		Retained:         len(topic.retained),
+		Durable:          topic.durable != nil,
+		Schema:           topic.rawSchema,
+		Acl:              topic.acl,
+		IdleTTL:          topic.idleTTL,
+		PublishStatsInfo: topic.stats.info(time.Now()),
+	}
+}
+
+func (topic *Topic[T]) addSubscriber(sub subscriber[T], cursor Cursor) error {
 	topic.m.Lock()
 	defer topic.m.Unlock()
 
 	if topic.closed {
-		return nil, fmt.Errorf("%w: %s", ErrTopicClosed, topic.Id.String())
+		return fmt.Errorf("%w: %s", ErrTopicClosed, topic.Id.String())
 	}
 
-	sub := make(chan Message[T], 4)
+	// Since publishing also takes the lock, there's no gap between the
+	// backlog and the first live message.
+	sub.preload(topic.backlog(cursor))
 	topic.subscribers = append(topic.subscribers, sub)
 
-	if topic.metaChannel != nil {
-		topic.metaChannel <- MetaTopicInfo{
-			Kind: "update",
-			Data: TopicInfo{
-				Id:              topic.Id,
-				Closed:          topic.closed,
-				SubscriberCount: len(topic.subscribers),
-			},
-		}
+	topic.notifier.notify(MetaTopicInfo{
+		Kind: "update",
+		Data: topic.info(),
+	})
+
+	return nil
+}
+
+func (topic *Topic[T]) subscribe(opts SubscribeOptions) (Subscription[T], error) {
+	opts, err := opts.normalize()
+	if err != nil {
+		return Subscription[T]{}, err
+	}
+
+	if err := topic.CheckAccess(opts.AppId, PermissionSubscribe); err != nil {
+		return Subscription[T]{}, err
+	}
+
+	sub := newChannelSubscriber(opts, func(message Message[T]) Message[T] {
+		return message
+	})
+
+	if err := topic.addSubscriber(sub, opts.Cursor); err != nil {
+		return Subscription[T]{}, err
 	}
 
-	return sub, nil
+	return Subscription[T]{
+		Out: sub.queue.out,
+		Unsubscribe: func() {
+			topic.removeSubscriber(sub)
+		},
+	}, nil
 }
 
-func (topic *Topic[T]) addAnySubscriber() (Subscription[any], error) {
-	channel, err := topic.addSubscriber()
+func (topic *Topic[T]) addAnySubscriber(opts SubscribeOptions) (Subscription[any], error) {
+	opts, err := opts.normalize()
 	if err != nil {
 		return Subscription[any]{}, err
 	}
 
-	// This channel is used to end the goroutine that pipes messages from the topic to
-	// the subscriber. It needs to be separate so that when the publisher closes the topic,
-	// and then separately, the subscriber unsubscribes, there isn't accidentally an instance of
-	// closing the channel returned by addSubscriber.
-	//
-	// Technically, now that I've fixed the return type, it's not even possible to call close on
-	// the channel returned by `addSubscriber` anymore, but I feel this explanation is still useful
-	// as documentation.
-	endChannel := make(chan struct{}, 1)
-
-	anyChannel := make(chan Message[any])
-	go func() {
-		defer close(anyChannel)
-
-		for {
-			select {
-			case <-endChannel:
-				return
-
-			case val, ok := <-channel:
-				if !ok {
-					return
-				}
-
-				anyChannel <- Message[any]{
-					MessageId: val.MessageId,
-					Data:      val.Data,
-				}
-
-			}
-		}
-	}()
-
-	unsub := func() {
-		topic.removeSubscriber(channel)
-		close(endChannel)
+	if err := topic.CheckAccess(opts.AppId, PermissionSubscribe); err != nil {
+		return Subscription[any]{}, err
 	}
 
-	sub := Subscription[any]{
-		Out:         anyChannel,
-		Unsubscribe: unsub,
+	sub := newChannelSubscriber(opts, func(message Message[T]) Message[any] {
+		return Message[any]{
+			MessageId: message.MessageId,
+			Data:      message.Data,
+			Via:       message.Via,
+		}
+	})
+
+	if err := topic.addSubscriber(sub, opts.Cursor); err != nil {
+		return Subscription[any]{}, err
 	}
 
-	return sub, nil
+	return Subscription[any]{
+		Out: sub.queue.out,
+		Unsubscribe: func() {
+			topic.removeSubscriber(sub)
+		},
+	}, nil
 }
 
 func (topic *Topic[_]) LockWithInfo() TopicInfo {
 	topic.m.Lock()
 
-	return TopicInfo{
-		Id:              topic.Id,
-		Counter:         topic.counter,
-		Closed:          topic.closed,
-		SubscriberCount: len(topic.subscribers),
-	}
+	return topic.info()
 }
 
 func (topic *Topic[_]) Unlock() {
 	topic.m.Unlock()
 }
 
-func (topic *Topic[T]) removeSubscriber(sub <-chan Message[T]) {
+func (topic *Topic[T]) removeSubscriber(sub subscriber[T]) {
+	// This has to happen before taking the lock, since a publisher might be holding
+	// it while it waits for this subscriber to make room in its buffer.
+	sub.stop()
+
 	topic.m.Lock()
 	defer topic.m.Unlock()
 
@@ -174,18 +244,18 @@ func (topic *Topic[T]) removeSubscriber(sub <-chan Message[T]) {
 		writeIndex += 1
 	}
 
+	if writeIndex == len(topic.subscribers) {
+		// Already removed, either by a previous call or by the topic closing
+		return
+	}
+
 	topic.subscribers = topic.subscribers[:writeIndex]
+	topic.markActive(time.Now())
 
-	if topic.metaChannel != nil {
-		topic.metaChannel <- MetaTopicInfo{
-			Kind: "update",
-			Data: TopicInfo{
-				Id:              topic.Id,
-				Closed:          topic.closed,
-				SubscriberCount: len(topic.subscribers),
-			},
-		}
-	}
+	topic.notifier.notify(MetaTopicInfo{
+		Kind: "update",
+		Data: topic.info(),
+	})
 }
 
 func (topic *Topic[_]) GetInfo() TopicInfo {
@@ -195,6 +265,22 @@ func (topic *Topic[_]) GetInfo() TopicInfo {
 	return info
 }
 
+func (topic *Topic[_]) hasOptions(opts TopicOptions) bool {
+	return topic.retention == opts.Retention &&
+		topic.idleTTL == opts.IdleTTL &&
+		bytes.Equal(topic.rawSchema, opts.Schema) &&
+		reflect.DeepEqual(topic.acl, opts.Acl)
+}
+
+// Requires caller to take the registry's lock
+func (topic *Topic[_]) isRestored() bool {
+	return topic.restored
+}
+
+func (topic *Topic[_]) GetId() TopicId {
+	return topic.Id
+}
+
 func (topic *Topic[_]) IsClosed() bool {
 	topic.m.Lock()
 	defer topic.m.Unlock()
@@ -202,7 +288,25 @@ func (topic *Topic[_]) IsClosed() bool {
 	return topic.closed
 }
 
+// Publishes a message to every subscriber. Only subscribers using `BackpressureBlock`
+// can make this wait; the other policies drop messages instead.
 func (topic *Topic[T]) Publish(message T) {
+	topic.publish(message, nil, messageSize(message))
+}
+
+// Publishes a message that was received as `size` bytes of JSON, e.g. from an app. Its
+// size is counted in the topic's metrics, which `Publish` can only do for some types.
+func (topic *Topic[T]) PublishWithSize(message T, size int) {
+	topic.publish(message, nil, size)
+}
+
+func (topic *Topic[T]) publish(message T, via []string, size int) {
+	topic.send(message, via, size, true)
+}
+
+// Delivers a message to the current subscribers. Messages that aren't kept are neither
+// retained nor persisted, so subscribers that come later never see them.
+func (topic *Topic[T]) send(message T, via []string, size int, keep bool) {
 	topic.m.Lock()
 	defer topic.m.Unlock()
 
@@ -210,122 +314,275 @@ func (topic *Topic[T]) Publish(message T) {
 		return
 	}
 
+	msg := Message[T]{
+		MessageId: topic.counter,
+		Data:      message,
+		Via:       via,
+	}
+
+	now := time.Now()
+	topic.lastActive = now
+	topic.stats.record(now, size)
+	if keep {
+		topic.retain(msg, now)
+		topic.persist(retainedMessage[T]{message: msg, publishedAt: now})
+	}
+
 	for _, sub := range topic.subscribers {
-		sub <- Message[T]{
-			MessageId: topic.counter,
-			Data:      message,
-		}
+		topic.dropped += sub.deliver(msg)
 	}
 
 	topic.counter += 1
 }
 
+// Publishes data of an unknown type. Data that isn't a `T`, e.g. because it was decoded
+// from JSON, is converted by encoding it as JSON and decoding it as a `T`.
+// Converts data of any type to the topic's type, by round-tripping it through JSON if necessary
+func (topic *Topic[T]) convertAny(data any) (T, error) {
+	message, ok := data.(T)
+	if !ok {
+		buf, err := json.Marshal(data)
+		if err == nil {
+			err = json.Unmarshal(buf, &message)
+		}
+		if err != nil {
+			return message, fmt.Errorf("can't publish %T to topic %s: %w", data, topic.Id.String(), err)
+		}
+	}
+
+	return message, nil
+}
+
+func (topic *Topic[T]) publishAny(data any, via []string) error {
+	message, err := topic.convertAny(data)
+	if err != nil {
+		return err
+	}
+
+	topic.publish(message, via, messageSize(message))
+	return nil
+}
+
+// Sends data of any type to the current subscribers, without retaining or persisting it
+func (topic *Topic[T]) sendAny(data any) error {
+	message, err := topic.convertAny(data)
+	if err != nil {
+		return err
+	}
+
+	topic.send(message, nil, messageSize(message), false)
+	return nil
+}
+
+// Checks that data of any type can be converted to the topic's type, and matches its schema
+func (topic *Topic[T]) validateAny(data any) error {
+	message, err := topic.convertAny(data)
+	if err != nil {
+		return err
+	}
+
+	return topic.ValidateMessage(message)
+}
+
+// Publishes to a topic without knowing its type. `via` is attached to the message,
+// and should only be set for messages received from other robin instances. Messages
+// that don't match the topic's schema are rejected.
+func PublishAny(r *Registry, id TopicId, data any, via []string) error {
+	topic, err := getTopic(r, id)
+	if err != nil {
+		return err
+	}
+
+	if err := topic.validateAny(data); err != nil {
+		return err
+	}
+
+	return topic.publishAny(data, via)
+}
+
+// Closes the topic and removes it from the registry. Subscribers are told about it by
+// their channels getting closed, and the meta topic gets a `close` message.
 func (topic *Topic[_]) Close() {
+	topic.shutdown()
+	topic.registry.evict(topic)
+}
+
+// Closes the topic without removing it from the registry
+func (topic *Topic[_]) shutdown() {
 	topic.m.Lock()
 	defer topic.m.Unlock()
 
+	topic.closeLocked()
+}
+
+// Requires caller to take the lock
+func (topic *Topic[_]) closeLocked() {
 	if topic.closed {
 		return
 	}
 
 	topic.closed = true
 
-	if topic.metaChannel != nil {
-		topic.metaChannel <- MetaTopicInfo{
-			Kind: "close",
-			Data: topic.Id,
-		}
+	if topic.idleTimer != nil {
+		topic.idleTimer.Stop()
 	}
 
-	for _, channel := range topic.subscribers {
-		close(channel)
+	topic.notifier.notify(MetaTopicInfo{
+		Kind: "close",
+		Data: topic.Id,
+	})
+
+	for _, sub := range topic.subscribers {
+		sub.close()
 	}
 
 	topic.subscribers = nil
-}
-
-type MetaTopicInfo struct {
-	Kind string `json:"kind"`
-	Data any    `json:"data"`
+	topic.retained = nil
+	topic.closeDurableLog()
 }
 
 type Registry struct {
 	m sync.Mutex
 
-	metaChannel chan MetaTopicInfo
+	// Sends changes to the registry's topics to the meta topic. It's nil until
+	// `CreateMetaTopics` is called.
+	notifier *metaNotifier
 
 	// TODO: this implementation will scatter stuff all over the heap.
 	// It can be fixed with some kind of stable-pointer-arraylist but
 	// that's not worth writing right now
 	topics map[string]anyTopic
+
+	// Pattern subscriptions get attached to every new topic that they match
+	patterns map[*patternSubscription]struct{}
+
+	// The directory that durable topics are stored in. Durable topics can't be
+	// created until this is set by `RestoreDurableTopics`.
+	durableDir string
+}
+
+type TopicOptions struct {
+	// Retention controls how many published messages are kept around for
+	// subscribers that ask for them with a `Cursor`. By default, nothing is retained.
+	Retention RetentionOptions `json:"retention"`
+	// Durable topics write their retained messages to disk, and get restored along with
+	// their counter when robin restarts. If no retention is set, the last 1000 messages
+	// are kept.
+	Durable bool `json:"durable,omitempty"`
+	// Schema is an optional JSON Schema that publishers can check messages against
+	// with `ValidateMessage`. It can't reference other documents.
+	Schema json.RawMessage `json:"schema,omitempty"`
+	// Acl controls which apps can use the topic. This can't be set through JSON, since it
+	// would let apps grant themselves access to topics.
+	Acl TopicAcl `json:"-"`
+	// IdleTTL closes the topic once it has had no subscribers and no messages published
+	// for this long. By default, topics stay open until they're closed.
+	IdleTTL time.Duration `json:"idleTtl,omitempty"`
 }
 
-func CreateTopic[T any](r *Registry, id TopicId) (*Topic[T], error) {
+func CreateTopic[T any](r *Registry, id TopicId, opts TopicOptions) (*Topic[T], error) {
 	if strings.HasPrefix(id.Category, "/topics") {
 		return nil, ErrTopicExists
 	}
 
+	if err := opts.Retention.validate(); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidTopicOptions, err.Error())
+	}
+
+	if opts.IdleTTL < 0 {
+		return nil, fmt.Errorf("%w: idle TTL must not be negative, got %s", ErrInvalidTopicOptions, opts.IdleTTL)
+	}
+
+	if opts.Durable && !opts.Retention.enabled() {
+		opts.Retention = defaultDurableRetention
+	}
+
+	// Compiling the schema here also catches invalid schemas before taking the lock
+	if len(opts.Schema) > 0 {
+		if _, err := compileTopicSchema(opts.Schema); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTopicOptions, err.Error())
+		}
+
+		// Schemas are compacted so that they can be compared byte-for-byte
+		var compacted bytes.Buffer
+		if err := json.Compact(&compacted, opts.Schema); err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidTopicOptions, err.Error())
+		}
+		opts.Schema = compacted.Bytes()
+	}
+
 	r.m.Lock()
 	defer r.m.Unlock()
 
-	return createTopic[T](r, id)
+	return createTopic[T](r, id, opts)
 }
 
 // Requires caller to take the lock
-func createTopic[T any](r *Registry, id TopicId) (*Topic[T], error) {
+func createTopic[T any](r *Registry, id TopicId, opts TopicOptions) (*Topic[T], error) {
 	if r.topics == nil {
 		r.topics = make(map[string]anyTopic, 8)
 	}
 
 	key := id.String()
 	if prev := r.topics[key]; prev != nil && !prev.IsClosed() {
-		return nil, fmt.Errorf("%w: %s", ErrTopicExists, id.String())
-	}
-
-	topic := &Topic[T]{Id: id, metaChannel: r.metaChannel}
-	r.topics[key] = topic
+		if !prev.isRestored() {
+			return nil, fmt.Errorf("%w: %s", ErrTopicExists, id.String())
+		}
 
-	if r.metaChannel != nil {
-		r.metaChannel <- MetaTopicInfo{
-			Kind: "update",
-			Data: TopicInfo{
-				Id:              topic.Id,
-				Closed:          topic.closed,
-				SubscriberCount: len(topic.subscribers),
-			},
+		// Topics restored from disk are handed to the first caller that creates them. If the
+		// type or options don't match, the topic gets recreated from disk with the new ones.
+		if restored, ok := prev.(*Topic[T]); ok && restored.hasOptions(opts) {
+			restored.restored = false
+			return restored, nil
 		}
+
+		// The registry is already locked, and the entry gets replaced below anyway
+		prev.shutdown()
+		delete(r.topics, key)
 	}
 
-	return topic, nil
-}
+	topic := &Topic[T]{
+		Id:        id,
+		registry:  r,
+		notifier:  r.notifier,
+		retention: opts.Retention,
+		acl:       opts.Acl,
+		idleTTL:   opts.IdleTTL,
+	}
 
-func (r *Registry) CreateMetaTopics() error {
-	r.m.Lock()
-	defer r.m.Unlock()
+	if len(opts.Schema) > 0 {
+		schema, err := compileTopicSchema(opts.Schema)
+		if err != nil {
+			return nil, err
+		}
 
-	// This is VERY messy. The meta channel is buffered so that when
-	// you subscribe to the meta channel, there's not an automatic deadlock
-	// between the subscriber trying to add to the metaChannel and the
-	// goroutine below trying to get the meta topic mutex. However,
-	// this does not necessarily guarantee that the goroutine won't deadlock later,
-	// if a lock on the meta topic happens when a crapton of messages are being sent in
-	// the channel.
-	metaChannel := make(chan MetaTopicInfo, 8)
-	r.metaChannel = metaChannel
-
-	// Lazily create meta topic
-	meta, err := createTopic[MetaTopicInfo](r, MetaTopic)
-	if err != nil {
-		return err
+		topic.schema = schema
+		topic.rawSchema = opts.Schema
 	}
 
-	go func() {
-		for item := range metaChannel {
-			meta.Publish(item)
+	if opts.Durable {
+		if r.durableDir == "" {
+			return nil, fmt.Errorf("can't create durable topic %s, since durable topics are disabled", id.String())
 		}
-	}()
 
-	return nil
+		if err := topic.openDurableLog(r.durableDir); err != nil {
+			return nil, err
+		}
+	}
+
+	r.topics[key] = topic
+	topic.startIdleTimer()
+
+	r.notifier.notify(MetaTopicInfo{
+		Kind: "update",
+		Data: topic.info(),
+	})
+
+	for sub := range r.patterns {
+		sub.attach(topic)
+	}
+
+	return topic, nil
 }
 
 func getTopic(r *Registry, id TopicId) (anyTopic, error) {
@@ -346,21 +603,16 @@ func getTopic(r *Registry, id TopicId) (anyTopic, error) {
 	return topicUntyped, nil
 }
 
-func SubscribeAny(r *Registry, id TopicId) (Subscription[any], error) {
+func SubscribeAny(r *Registry, id TopicId, opts SubscribeOptions) (Subscription[any], error) {
 	topicUntyped, err := getTopic(r, id)
 	if err != nil {
 		return Subscription[any]{}, err
 	}
 
-	sub, err := topicUntyped.addAnySubscriber()
-	if err != nil {
-		return Subscription[any]{}, err
-	}
-
-	return sub, nil
+	return topicUntyped.addAnySubscriber(opts)
 }
 
-func Subscribe[T any](r *Registry, id TopicId) (Subscription[T], error) {
+func Subscribe[T any](r *Registry, id TopicId, opts SubscribeOptions) (Subscription[T], error) {
 	topicUntyped, err := getTopic(r, id)
 	if err != nil {
 		return Subscription[T]{}, err
@@ -371,19 +623,7 @@ func Subscribe[T any](r *Registry, id TopicId) (Subscription[T], error) {
 		return Subscription[T]{}, fmt.Errorf("%w: %s topic was the wrong type", ErrTopicDoesntExist, id.String())
 	}
 
-	channel, err := topic.addSubscriber()
-	if err != nil {
-		return Subscription[T]{}, err
-	}
-
-	sub := Subscription[T]{
-		Out: channel,
-		Unsubscribe: func() {
-			topic.removeSubscriber(channel)
-		},
-	}
-
-	return sub, nil
+	return topic.subscribe(opts)
 }
 
 type TopicInfo struct {
@@ -391,6 +631,19 @@ type TopicInfo struct {
 	Closed          bool    `json:"closed"`
 	Counter         int32   `json:"counter"` // The ID of the next message
 	SubscriberCount int     `json:"subscriberCount"`
+	// The total number of messages dropped because a subscriber's buffer was full
+	Dropped     int64            `json:"dropped"`
+	Subscribers []SubscriberInfo `json:"subscribers"`
+	Retention   RetentionOptions `json:"retention"`
+	// The number of messages currently retained
+	Retained int  `json:"retained"`
+	Durable  bool `json:"durable"`
+	// The topic's JSON Schema, if it has one
+	Schema json.RawMessage `json:"schema,omitempty"`
+	Acl    TopicAcl        `json:"acl"`
+	// IdleTTL is how long the topic stays open while idle, or zero if it doesn't expire
+	IdleTTL time.Duration `json:"idleTtl,omitempty"`
+	PublishStatsInfo
 }
 
 type RegistryTopicInfo struct {
@@ -399,18 +652,33 @@ type RegistryTopicInfo struct {
 }
 
 func (r *Registry) GetTopicInfo() RegistryTopicInfo {
-	r.m.Lock()
-	defer r.m.Unlock()
+	return r.GetTopicInfoForApp("")
+}
 
-	out := RegistryTopicInfo{
-		Info: make(map[string]TopicInfo, len(r.topics)),
+// Returns info about the topics that the app can subscribe to. If the app ID is empty,
+// every topic is included.
+func (r *Registry) GetTopicInfoForApp(appId string) RegistryTopicInfo {
+	// The counter is read before any topic, so that every change that's missing from
+	// the info gets sent to the meta topic with this ID or a later one.
+	counter := r.notifier.counter()
+
+	// Getting a topic's info has to wait while it's publishing to a blocking subscriber, so
+	// the registry isn't locked while doing that.
+	r.m.Lock()
+	topics := make(map[string]anyTopic, len(r.topics))
+	for key, topic := range r.topics {
+		if topic.CheckAccess(appId, PermissionSubscribe) == nil {
+			topics[key] = topic
+		}
 	}
+	r.m.Unlock()
 
-	if t, ok := r.topics[MetaTopic.String()]; ok {
-		out.Counter = t.GetInfo().Counter
+	out := RegistryTopicInfo{
+		Counter: counter,
+		Info:    make(map[string]TopicInfo, len(topics)),
 	}
 
-	for key, topic := range r.topics {
+	for key, topic := range topics {
 		out.Info[key] = topic.GetInfo()
 	}
 
@@ -1,9 +1,15 @@
 package pubsub
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 )
 
 func TestPubSubTopicCollision(t *testing.T) {
@@ -15,7 +21,7 @@ func TestPubSubTopicCollision(t *testing.T) {
 	}
 	var err error
 
-	_, err = CreateTopic[string](registry, topicId)
+	_, err = CreateTopic[string](registry, topicId, TopicOptions{})
 	if err != nil {
 		t.Fatalf("topic couldn't be created: %s", err.Error())
 	}
@@ -24,7 +30,7 @@ func TestPubSubTopicCollision(t *testing.T) {
 		Category: "/topics",
 		Key:      "meta",
 	}
-	_, err = CreateTopic[string](registry, metaTopicId)
+	_, err = CreateTopic[string](registry, metaTopicId, TopicOptions{})
 	if err == nil {
 		t.Fatalf("creating meta topic should have failed, but didn't")
 	}
@@ -36,7 +42,7 @@ func RunSubscriberMessages[T comparable](registry *Registry, topicId TopicId, co
 
 	failChannel := make(chan error)
 
-	topic, err := CreateTopic[T](registry, topicId)
+	topic, err := CreateTopic[T](registry, topicId, TopicOptions{})
 	if err != nil {
 		return err
 	}
@@ -74,7 +80,7 @@ func RunSubscriberMessages[T comparable](registry *Registry, topicId TopicId, co
 		wStart.Add(1)
 		wStop.Add(1)
 
-		sub, err := Subscribe[T](registry, topicId)
+		sub, err := Subscribe[T](registry, topicId, SubscribeOptions{})
 		if err != nil {
 			return err
 		}
@@ -145,3 +151,1391 @@ func TestPubSubParallel(t *testing.T) {
 		1, 2, 3, 4, 5,
 	})
 }
+
+// Publishes `count` messages to a subscriber that never reads, and returns the messages
+// left in its buffer afterwards.
+func publishToStalledSubscriber(t *testing.T, opts SubscribeOptions, count int) ([]int, TopicInfo) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: string(opts.Policy)}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+
+	sub, err := Subscribe[int](registry, topicId, opts)
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < count; i++ {
+			topic.Publish(i)
+		}
+	}()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publishing to a stalled %s subscriber blocked", opts.Policy)
+	}
+
+	info := topic.GetInfo()
+	topic.Close()
+
+	received := []int{}
+	for msg := range sub.Out {
+		received = append(received, msg.Data)
+	}
+
+	return received, info
+}
+
+func TestBackpressurePolicies(t *testing.T) {
+	cases := []struct {
+		opts     SubscribeOptions
+		expected []int
+	}{
+		{SubscribeOptions{Policy: BackpressureDropOldest, BufferSize: 3}, []int{7, 8, 9}},
+		{SubscribeOptions{Policy: BackpressureDropNewest, BufferSize: 3}, []int{0, 1, 2}},
+		{SubscribeOptions{Policy: BackpressureCoalesceLatest, BufferSize: 3}, []int{9}},
+	}
+
+	for _, c := range cases {
+		received, info := publishToStalledSubscriber(t, c.opts, 10)
+
+		if fmt.Sprint(received) != fmt.Sprint(c.expected) {
+			t.Fatalf("%s: expected to receive %v, got %v", c.opts.Policy, c.expected, received)
+		}
+
+		expectedDrops := int64(10 - len(c.expected))
+		if info.Dropped != expectedDrops {
+			t.Fatalf("%s: expected topic to report %d drops, got %d", c.opts.Policy, expectedDrops, info.Dropped)
+		}
+
+		if len(info.Subscribers) != 1 || info.Subscribers[0].Dropped != expectedDrops {
+			t.Fatalf("%s: expected subscriber to report %d drops, got %+v", c.opts.Policy, expectedDrops, info.Subscribers)
+		}
+	}
+}
+
+func TestUnsubscribeUnblocksPublisher(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "blocked"}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	sub, err := Subscribe[int](registry, topicId, SubscribeOptions{Policy: BackpressureBlock, BufferSize: 1})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	published := make(chan struct{})
+	go func() {
+		defer close(published)
+		for i := 0; i < 5; i++ {
+			topic.Publish(i)
+		}
+	}()
+
+	select {
+	case <-published:
+		t.Fatalf("publisher should have blocked on a full subscriber")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	sub.Unsubscribe()
+
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("publisher stayed blocked after the subscriber unsubscribed")
+	}
+
+	if info := topic.GetInfo(); info.SubscriberCount != 0 {
+		t.Fatalf("expected no subscribers, got %d", info.SubscriberCount)
+	}
+}
+
+func TestInvalidSubscribeOptions(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "invalid"}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	if _, err := Subscribe[int](registry, topicId, SubscribeOptions{Policy: "yolo"}); err == nil {
+		t.Fatalf("subscribing with an unknown policy should have failed")
+	}
+
+	if _, err := SubscribeAny(registry, topicId, SubscribeOptions{BufferSize: -1}); err == nil {
+		t.Fatalf("subscribing with a negative buffer size should have failed")
+	}
+
+	if _, err := SubscribeAny(registry, topicId, SubscribeOptions{BufferSize: MaxBufferSize + 1}); err == nil {
+		t.Fatalf("subscribing with a buffer size above the limit should have failed")
+	}
+}
+
+func collectMessages[T any](t *testing.T, sub Subscription[T], count int) []Message[T] {
+	messages := []Message[T]{}
+	for len(messages) < count {
+		select {
+		case msg, ok := <-sub.Out:
+			if !ok {
+				return messages
+			}
+			messages = append(messages, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out after receiving %d of %d messages", len(messages), count)
+		}
+	}
+
+	return messages
+}
+
+func TestReplayRetainedMessages(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "retained"}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{
+		Retention: RetentionOptions{MaxMessages: 5},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	for i := 0; i < 10; i++ {
+		topic.Publish(i)
+	}
+
+	if info := topic.GetInfo(); info.Retained != 5 {
+		t.Fatalf("expected 5 retained messages, got %d", info.Retained)
+	}
+
+	fromId := int32(7)
+	fromSub, err := Subscribe[int](registry, topicId, SubscribeOptions{Cursor: Cursor{FromMessageId: &fromId}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	defer fromSub.Unsubscribe()
+
+	// The backlog is larger than the default buffer, and shouldn't block or get dropped
+	lastSub, err := SubscribeAny(registry, topicId, SubscribeOptions{
+		Policy: BackpressureDropNewest,
+		Cursor: Cursor{Last: 100},
+	})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	defer lastSub.Unsubscribe()
+
+	liveSub, err := Subscribe[int](registry, topicId, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+	defer liveSub.Unsubscribe()
+
+	topic.Publish(10)
+
+	fromMessages := collectMessages(t, fromSub, 4)
+	for i, msg := range fromMessages {
+		if msg.MessageId != int32(7+i) || msg.Data != 7+i {
+			t.Fatalf("expected message %d, got %+v", 7+i, msg)
+		}
+	}
+
+	lastMessages := collectMessages(t, lastSub, 6)
+	for i, msg := range lastMessages {
+		if msg.MessageId != int32(5+i) || msg.Data != 5+i {
+			t.Fatalf("expected message %d, got %+v", 5+i, msg)
+		}
+	}
+
+	liveMessages := collectMessages(t, liveSub, 1)
+	if liveMessages[0].MessageId != 10 {
+		t.Fatalf("expected live subscriber to only see message 10, got %+v", liveMessages[0])
+	}
+}
+
+func TestRetentionMaxAge(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "aged"}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{
+		Retention: RetentionOptions{MaxAge: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	topic.Publish(0)
+	time.Sleep(100 * time.Millisecond)
+	topic.Publish(1)
+
+	sub, err := Subscribe[int](registry, topicId, SubscribeOptions{Cursor: Cursor{Last: 10}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	topic.Close()
+	messages := collectMessages(t, sub, 10)
+	if len(messages) != 1 || messages[0].Data != 1 {
+		t.Fatalf("expected only the recent message to be replayed, got %+v", messages)
+	}
+}
+
+func TestInvalidCursor(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "cursor"}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	fromId := int32(0)
+	if _, err := Subscribe[int](registry, topicId, SubscribeOptions{Cursor: Cursor{FromMessageId: &fromId, Last: 1}}); err == nil {
+		t.Fatalf("subscribing with both fromMessageId and last should have failed")
+	}
+}
+
+func TestTopicPatternMatches(t *testing.T) {
+	cases := []struct {
+		pattern  string
+		category string
+		expected bool
+	}{
+		{"/logs/app/*", "/logs/app/foo", true},
+		{"/logs/app/*", "/logs/app", false},
+		{"/logs/app/*", "/logs/app/foo/bar", false},
+		{"/logs/**", "/logs", true},
+		{"/logs/**", "/logs/app/foo/bar", true},
+		{"/logs/**/bar", "/logs/app/foo/bar", true},
+		{"/logs/**/bar", "/logs/app/foo", false},
+		{"/logs/a*", "/logs/app", true},
+		{"/logs/a*", "/topics", false},
+		{"/" + EscapeCategory("a*b"), "/a*b", true},
+		{"/" + EscapeCategory("a*b"), "/axb", false},
+	}
+
+	for _, c := range cases {
+		pattern, err := ParseTopicPattern(c.pattern)
+		if err != nil {
+			t.Fatalf("failed to parse pattern %s: %s", c.pattern, err.Error())
+		}
+
+		if actual := pattern.Matches(TopicId{Category: c.category, Key: "key"}); actual != c.expected {
+			t.Fatalf("expected %s matching %s to be %v", c.pattern, c.category, c.expected)
+		}
+	}
+
+	if _, err := ParseTopicPattern("/logs/[a"); err == nil {
+		t.Fatalf("parsing a malformed pattern should have failed")
+	}
+}
+
+func TestSubscribePattern(t *testing.T) {
+	registry := &Registry{}
+
+	before, err := CreateTopic[string](registry, TopicId{Category: "/logs/app/foo", Key: "a"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer before.Close()
+
+	other, err := CreateTopic[string](registry, TopicId{Category: "/logs/other", Key: "b"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer other.Close()
+
+	pattern, err := ParseTopicPattern("/logs/app/*")
+	if err != nil {
+		t.Fatalf("failed to parse pattern: %s", err.Error())
+	}
+
+	sub, err := SubscribePattern(registry, pattern, SubscribeOptions{BufferSize: 16})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	after, err := CreateTopic[int](registry, TopicId{Category: "/logs/app/bar", Key: "c"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer after.Close()
+
+	before.Publish("hello")
+	other.Publish("ignored")
+	after.Publish(12)
+
+	received := map[string]any{}
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-sub.Out:
+			received[msg.TopicId.String()] = msg.Data
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for messages, got %v", received)
+		}
+	}
+
+	if received[before.Id.String()] != "hello" || received[after.Id.String()] != 12 {
+		t.Fatalf("got the wrong messages: %v", received)
+	}
+
+	sub.Unsubscribe()
+
+	if info := after.GetInfo(); info.SubscriberCount != 0 {
+		t.Fatalf("expected unsubscribing to detach from every topic, got %d subscribers", info.SubscriberCount)
+	}
+
+	// Topics created after unsubscribing shouldn't get the subscription attached
+	late, err := CreateTopic[int](registry, TopicId{Category: "/logs/app/baz", Key: "d"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer late.Close()
+
+	if info := late.GetInfo(); info.SubscriberCount != 0 {
+		t.Fatalf("expected no subscribers on a topic created after unsubscribing, got %d", info.SubscriberCount)
+	}
+
+	select {
+	case msg := <-sub.Out:
+		t.Fatalf("received an unexpected message: %+v", msg)
+	default:
+	}
+}
+
+func TestDurableTopicRestore(t *testing.T) {
+	dir := t.TempDir()
+	topicId := TopicId{Category: "/test", Key: "durable"}
+
+	registry := &Registry{}
+	if err := registry.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to enable durable topics: %s", err.Error())
+	}
+
+	topic, err := CreateTopic[any](registry, topicId, TopicOptions{
+		Durable:   true,
+		Retention: RetentionOptions{MaxMessages: 3},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+
+	for i := 0; i < 5; i++ {
+		topic.Publish(map[string]any{"index": i})
+	}
+	topic.Close()
+
+	// Simulates robin restarting
+	restarted := &Registry{}
+	if err := restarted.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to restore durable topics: %s", err.Error())
+	}
+
+	info := restarted.GetTopicInfo().Info[topicId.String()]
+	if info.Counter != 5 || info.Retained != 3 || !info.Durable {
+		t.Fatalf("restored topic has the wrong state: %+v", info)
+	}
+
+	fromId := int32(3)
+	sub, err := SubscribeAny(restarted, topicId, SubscribeOptions{Cursor: Cursor{FromMessageId: &fromId}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	restoredTopic, err := CreateTopic[any](restarted, topicId, TopicOptions{Durable: true})
+	if err != nil {
+		t.Fatalf("failed to claim restored topic: %s", err.Error())
+	}
+	restoredTopic.Publish(map[string]any{"index": 5})
+
+	if _, err := CreateTopic[any](restarted, topicId, TopicOptions{Durable: true}); err == nil {
+		t.Fatalf("a restored topic should only be claimed once")
+	}
+
+	messages := collectMessages(t, sub, 3)
+	for i, msg := range messages {
+		// Replayed messages were decoded from JSON, so their numbers are floats
+		index := fmt.Sprint(msg.Data.(map[string]any)["index"])
+		if msg.MessageId != int32(3+i) || index != fmt.Sprint(3+i) {
+			t.Fatalf("expected message %d, got %+v", 3+i, msg)
+		}
+	}
+
+	restoredTopic.Close()
+}
+
+func TestDiscardCategory(t *testing.T) {
+	dir := t.TempDir()
+
+	registry := &Registry{}
+	if err := registry.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to enable durable topics: %s", err.Error())
+	}
+
+	discarded := TopicId{Category: "/app-topics/a", Key: "discarded"}
+	expired := TopicId{Category: "/app-topics/a/nested", Key: "expired"}
+	kept := TopicId{Category: "/app-topics/ab", Key: "kept"}
+	for _, id := range []TopicId{discarded, expired, kept} {
+		topic, err := CreateTopic[int](registry, id, TopicOptions{Durable: true})
+		if err != nil {
+			t.Fatalf("topic couldn't be created: %s", err.Error())
+		}
+		topic.Publish(1)
+
+		// Topics that are already closed, e.g. by expiring, still have their logs
+		if id == expired {
+			topic.Close()
+		}
+	}
+
+	registry.DiscardCategory("/app-topics/a")
+
+	restarted := &Registry{}
+	if err := restarted.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to restore durable topics: %s", err.Error())
+	}
+
+	info := restarted.GetTopicInfo().Info
+	if _, found := info[discarded.String()]; found {
+		t.Fatalf("discarded topic was restored")
+	}
+	if _, found := info[expired.String()]; found {
+		t.Fatalf("expired topic in discarded category was restored")
+	}
+	if _, found := info[kept.String()]; !found {
+		t.Fatalf("topic outside of the discarded category wasn't restored")
+	}
+}
+
+func TestDurableTopicTypedRestore(t *testing.T) {
+	dir := t.TempDir()
+	topicId := TopicId{Category: "/test", Key: "typed"}
+
+	registry := &Registry{}
+	if err := registry.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to enable durable topics: %s", err.Error())
+	}
+
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{Durable: true})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+
+	// Publish enough messages to trigger compaction a few times
+	for i := 0; i < 200; i++ {
+		topic.Publish(i)
+	}
+	topic.Close()
+
+	restarted := &Registry{}
+	if err := restarted.RestoreDurableTopics(dir); err != nil {
+		t.Fatalf("failed to restore durable topics: %s", err.Error())
+	}
+
+	typed, err := CreateTopic[int](restarted, topicId, TopicOptions{Durable: true})
+	if err != nil {
+		t.Fatalf("failed to claim restored topic with a different type: %s", err.Error())
+	}
+	defer typed.Close()
+
+	sub, err := Subscribe[int](restarted, topicId, SubscribeOptions{Cursor: Cursor{Last: 2}})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	messages := collectMessages(t, sub, 2)
+	if messages[0].Data != 198 || messages[1].Data != 199 {
+		t.Fatalf("expected the last two messages, got %+v", messages)
+	}
+
+	if info := typed.GetInfo(); info.Counter != 200 {
+		t.Fatalf("expected counter to be restored to 200, got %d", info.Counter)
+	}
+}
+
+func TestDurableTopicsDisabled(t *testing.T) {
+	registry := &Registry{}
+	if _, err := CreateTopic[int](registry, TopicId{Category: "/test", Key: "x"}, TopicOptions{Durable: true}); err == nil {
+		t.Fatalf("creating a durable topic without a directory should have failed")
+	}
+}
+
+// Answers every request on the topic by calling `handle`, until the subscription ends
+func serveRequests(registry *Registry, sub Subscription[any], handle func(RequestMessage) Reply) {
+	for msg := range sub.Out {
+		request := msg.Data.(RequestMessage)
+		if request.Kind != RequestKindRequest {
+			continue
+		}
+
+		reply := handle(request)
+		reply.CorrelationId = request.CorrelationId
+		SendReply(registry, request.ReplyTo, reply)
+	}
+}
+
+func TestRequestReply(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "service"}
+
+	topic, err := CreateTopic[any](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	sub, err := Subscribe[any](registry, topicId, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	go serveRequests(registry, sub, func(request RequestMessage) Reply {
+		if request.Data == "fail" {
+			return Reply{Error: "asked to fail"}
+		}
+
+		return Reply{Data: fmt.Sprintf("hello %v", request.Data)}
+	})
+
+	reply, err := Request(context.Background(), registry, topicId, "world", 5*time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %s", err.Error())
+	}
+
+	if reply != "hello world" {
+		t.Fatalf("got the wrong reply: %v", reply)
+	}
+
+	if _, err := Request(context.Background(), registry, topicId, "fail", 5*time.Second); !errors.Is(err, ErrRequestFailed) {
+		t.Fatalf("expected the request to fail, got %v", err)
+	}
+
+	missing := TopicId{Category: "/test", Key: "missing"}
+	if _, err := Request(context.Background(), registry, missing, nil, time.Second); !errors.Is(err, ErrTopicDoesntExist) {
+		t.Fatalf("expected requesting a missing topic to fail, got %v", err)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "slow"}
+
+	topic, err := CreateTopic[any](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	sub, err := Subscribe[any](registry, topicId, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	_, err = Request(context.Background(), registry, topicId, nil, 20*time.Millisecond)
+	if !errors.Is(err, ErrRequestTimeout) {
+		t.Fatalf("expected the request to time out, got %v", err)
+	}
+
+	messages := collectMessages(t, sub, 2)
+	request := messages[0].Data.(RequestMessage)
+	cancel := messages[1].Data.(RequestMessage)
+	if request.Kind != RequestKindRequest || cancel.Kind != RequestKindCancel || cancel.CorrelationId != request.CorrelationId {
+		t.Fatalf("expected a request followed by its cancellation, got %+v", messages)
+	}
+
+	// The requester stopped waiting, so replying late should fail. The reply topic has been
+	// closed and removed from the registry by then.
+	err = SendReply(registry, request.ReplyTo, Reply{CorrelationId: request.CorrelationId})
+	if !errors.Is(err, ErrTopicDoesntExist) {
+		t.Fatalf("expected replying after the timeout to fail, got %v", err)
+	}
+
+	// Cancelling the context also cancels the request
+	ctx, cancelCtx := context.WithCancel(context.Background())
+	cancelCtx()
+	if _, err := Request(ctx, registry, topicId, nil, 5*time.Second); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the request to be cancelled, got %v", err)
+	}
+}
+
+func TestRequestValidatesAndIsntRetained(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "typed-service"}
+
+	topic, err := CreateTopic[any](registry, topicId, TopicOptions{
+		Retention: RetentionOptions{MaxMessages: 5},
+		Schema:    json.RawMessage(`{"type": "string"}`),
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	sub, err := Subscribe[any](registry, topicId, SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	go serveRequests(registry, sub, func(request RequestMessage) Reply {
+		return Reply{Data: fmt.Sprintf("hello %v", request.Data)}
+	})
+
+	if _, err := Request(context.Background(), registry, topicId, 1, time.Second); !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected data that doesn't match the schema to be rejected, got %v", err)
+	}
+
+	if reply, err := Request(context.Background(), registry, topicId, "world", 5*time.Second); err != nil || reply != "hello world" {
+		t.Fatalf("expected a reply, got %v, %v", reply, err)
+	}
+
+	// Requests only go to the subscribers that are there when they're sent
+	if info := topic.GetInfo(); info.Retained != 0 {
+		t.Fatalf("expected requests not to be retained, got %d retained messages", info.Retained)
+	}
+}
+
+func TestTopicSchema(t *testing.T) {
+	registry := &Registry{}
+	topicId := TopicId{Category: "/test", Key: "schema"}
+
+	schema := json.RawMessage(`{
+		"type": "object",
+		"properties": {
+			"name": { "type": "string" },
+			"count": { "type": "integer", "minimum": 0 }
+		},
+		"required": ["name"]
+	}`)
+
+	topic, err := CreateTopic[any](registry, topicId, TopicOptions{Schema: schema})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer topic.Close()
+
+	if err := topic.ValidateMessage(map[string]any{"name": "robin", "count": 3}); err != nil {
+		t.Fatalf("valid message was rejected: %s", err.Error())
+	}
+
+	err = topic.ValidateMessage(map[string]any{"name": 12, "count": -1})
+	if !errors.Is(err, ErrSchemaMismatch) {
+		t.Fatalf("expected invalid message to be rejected, got %v", err)
+	}
+
+	// The error should point at every field that's wrong
+	if !strings.Contains(err.Error(), "/name") || !strings.Contains(err.Error(), "/count") {
+		t.Fatalf("expected error to mention both fields, got: %s", err.Error())
+	}
+
+	var compacted bytes.Buffer
+	json.Compact(&compacted, schema)
+	if info := topic.GetInfo(); string(info.Schema) != compacted.String() {
+		t.Fatalf("expected topic info to include the schema, got %s", string(info.Schema))
+	}
+
+	_, err = CreateTopic[any](registry, TopicId{Category: "/test", Key: "bad"}, TopicOptions{
+		Schema: json.RawMessage(`{"type": "nope"}`),
+	})
+	if !errors.Is(err, ErrInvalidTopicOptions) {
+		t.Fatalf("expected an invalid schema to be rejected, got %v", err)
+	}
+
+	_, err = CreateTopic[any](registry, TopicId{Category: "/test", Key: "ref"}, TopicOptions{
+		Schema: json.RawMessage(`{"$ref": "file:///etc/passwd"}`),
+	})
+	if !errors.Is(err, ErrInvalidTopicOptions) {
+		t.Fatalf("expected a schema referencing a file to be rejected, got %v", err)
+	}
+}
+
+func TestTopicAcl(t *testing.T) {
+	registry := &Registry{}
+
+	private, err := CreateTopic[int](registry, TopicId{Category: "/app-topics/owner", Key: "private"}, TopicOptions{
+		Acl: TopicAcl{Owner: "owner"},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer private.Close()
+
+	shared, err := CreateTopic[int](registry, TopicId{Category: "/app-topics/owner", Key: "shared"}, TopicOptions{
+		Acl: TopicAcl{
+			Owner:      "owner",
+			ReadPublic: true,
+			Grants:     map[string][]Permission{"friend": {PermissionPublish}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer shared.Close()
+
+	checks := []struct {
+		topic      *Topic[int]
+		appId      string
+		permission Permission
+		allowed    bool
+	}{
+		{private, "", PermissionPublish, true},
+		{private, "owner", PermissionSubscribe, true},
+		{private, "owner", PermissionPublish, true},
+		{private, "other", PermissionSubscribe, false},
+		{private, "friend", PermissionPublish, false},
+		{shared, "other", PermissionSubscribe, true},
+		{shared, "other", PermissionPublish, false},
+		{shared, "friend", PermissionPublish, true},
+		{private, AnonymousAppId, PermissionSubscribe, false},
+		{shared, AnonymousAppId, PermissionSubscribe, true},
+		{shared, AnonymousAppId, PermissionPublish, false},
+	}
+
+	for _, check := range checks {
+		err := check.topic.CheckAccess(check.appId, check.permission)
+		if check.allowed && err != nil {
+			t.Errorf("expected '%s' to be able to %s to %s, got %s", check.appId, check.permission, check.topic.Id.String(), err.Error())
+		} else if !check.allowed && !errors.Is(err, ErrAccessDenied) {
+			t.Errorf("expected '%s' to be denied %s on %s, got %v", check.appId, check.permission, check.topic.Id.String(), err)
+		}
+	}
+
+	if _, err := SubscribeAny(registry, private.Id, SubscribeOptions{AppId: "other"}); !errors.Is(err, ErrAccessDenied) {
+		t.Fatalf("expected subscription by another app to be denied, got %v", err)
+	}
+
+	sub, err := SubscribeAny(registry, private.Id, SubscribeOptions{AppId: "owner"})
+	if err != nil {
+		t.Fatalf("owner couldn't subscribe: %s", err.Error())
+	}
+	sub.Unsubscribe()
+
+	info := registry.GetTopicInfoForApp("other")
+	if _, found := info.Info[private.Id.String()]; found {
+		t.Fatalf("topic info for another app included the private topic")
+	}
+	if _, found := info.Info[shared.Id.String()]; !found {
+		t.Fatalf("topic info for another app didn't include the public topic")
+	}
+
+	if info := registry.GetTopicInfo(); len(info.Info) < 2 {
+		t.Fatalf("expected internal topic info to include every topic, got %d topics", len(info.Info))
+	}
+}
+
+func TestSubscribePatternDoesntBlockRegistry(t *testing.T) {
+	registry := &Registry{}
+
+	busy, err := CreateTopic[int](registry, TopicId{Category: "/busy", Key: "a"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer busy.Close()
+
+	slow, err := Subscribe[int](registry, busy.Id, SubscribeOptions{Policy: BackpressureBlock, BufferSize: 1})
+	if err != nil {
+		t.Fatalf("failed to subscribe: %s", err.Error())
+	}
+
+	// The second publish waits for the slow subscriber while holding the topic's lock
+	busy.Publish(1)
+	go busy.Publish(2)
+	time.Sleep(50 * time.Millisecond)
+
+	pattern, err := ParseTopicPattern("/**")
+	if err != nil {
+		t.Fatalf("failed to parse pattern: %s", err.Error())
+	}
+
+	subscribed := make(chan PatternSubscription)
+	go func() {
+		sub, err := SubscribePattern(registry, pattern, SubscribeOptions{BufferSize: 16})
+		if err != nil {
+			t.Errorf("failed to subscribe: %s", err.Error())
+		}
+		subscribed <- sub
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	created := make(chan error)
+	go func() {
+		topic, err := CreateTopic[int](registry, TopicId{Category: "/quiet", Key: "b"}, TopicOptions{})
+		if err == nil {
+			defer topic.Close()
+		}
+		created <- err
+	}()
+
+	select {
+	case err := <-created:
+		if err != nil {
+			t.Fatalf("topic couldn't be created: %s", err.Error())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("creating a topic was blocked by a pattern subscription waiting on a busy topic")
+	}
+
+	// Once the slow subscriber catches up, the pattern subscription gets attached
+	collectMessages(t, slow, 2)
+
+	select {
+	case sub := <-subscribed:
+		defer sub.Unsubscribe()
+	case <-time.After(5 * time.Second):
+		t.Fatalf("timed out waiting for the pattern subscription")
+	}
+
+	slow.Unsubscribe()
+	if info := busy.GetInfo(); info.SubscriberCount != 1 {
+		t.Fatalf("expected the pattern subscription to be attached to the busy topic, got %d subscribers", info.SubscriberCount)
+	}
+}
+
+func TestPatternSubscriptionAcl(t *testing.T) {
+	registry := &Registry{}
+
+	pattern, err := ParseTopicPattern("/app-topics/owner")
+	if err != nil {
+		t.Fatalf("pattern couldn't be parsed: %s", err.Error())
+	}
+
+	sub, err := SubscribePattern(registry, pattern, SubscribeOptions{AppId: "other", BufferSize: 8})
+	if err != nil {
+		t.Fatalf("pattern subscription failed: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	private, err := CreateTopic[int](registry, TopicId{Category: "/app-topics/owner", Key: "private"}, TopicOptions{
+		Acl: TopicAcl{Owner: "owner"},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer private.Close()
+
+	public, err := CreateTopic[int](registry, TopicId{Category: "/app-topics/owner", Key: "public"}, TopicOptions{
+		Acl: TopicAcl{Owner: "owner", ReadPublic: true},
+	})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer public.Close()
+
+	private.Publish(1)
+	public.Publish(2)
+
+	select {
+	case message := <-sub.Out:
+		if message.TopicId != public.Id {
+			t.Fatalf("expected a message from the public topic, got one from %s", message.TopicId.String())
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("didn't get the message from the public topic")
+	}
+
+	select {
+	case message := <-sub.Out:
+		t.Fatalf("got unexpected message from %s", message.TopicId.String())
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	type logLine struct {
+		Level  string   `json:"level"`
+		Code   int      `json:"code"`
+		Tags   []string `json:"tags"`
+		Source struct {
+			Name string `json:"name"`
+		} `json:"source"`
+	}
+
+	line := logLine{Level: "error", Code: 500, Tags: []string{"http", "db"}}
+	line.Source.Name = "api-server"
+
+	cases := []struct {
+		name    string
+		filter  MessageFilter
+		data    any
+		matches bool
+	}{
+		{"contains", MessageFilter{Contains: "ERROR"}, "12:00 ERROR failed", true},
+		{"doesn't contain", MessageFilter{Contains: "ERROR"}, "12:00 INFO ok", false},
+		{"contains on object", MessageFilter{Contains: "ERROR"}, line, false},
+		{"regex", MessageFilter{Match: `^\d+:\d+ (WARN|ERROR)`}, "12:00 WARN slow", true},
+		{"regex mismatch", MessageFilter{Match: `^\d+:\d+ (WARN|ERROR)`}, "INFO 12:00 WARN", false},
+		{"eq", MessageFilter{Where: []FieldPredicate{{Path: "$.level", Op: PredicateEq, Value: "error"}}}, line, true},
+		{"ne", MessageFilter{Where: []FieldPredicate{{Path: "$.level", Op: PredicateNe, Value: "error"}}}, line, false},
+		{"gte", MessageFilter{Where: []FieldPredicate{{Path: "$.code", Op: PredicateGte, Value: 500}}}, line, true},
+		{"lt", MessageFilter{Where: []FieldPredicate{{Path: "code", Op: PredicateLt, Value: 500}}}, line, false},
+		{"nested", MessageFilter{Where: []FieldPredicate{{Path: "$.source.name", Op: PredicateMatches, Value: "^api-"}}}, line, true},
+		{"index", MessageFilter{Where: []FieldPredicate{{Path: "$.tags[1]", Op: PredicateEq, Value: "db"}}}, line, true},
+		{"array contains", MessageFilter{Where: []FieldPredicate{{Path: "$.tags", Op: PredicateContains, Value: "http"}}}, line, true},
+		{"exists", MessageFilter{Where: []FieldPredicate{{Path: "$.source", Op: PredicateExists}}}, line, true},
+		{"missing", MessageFilter{Where: []FieldPredicate{{Path: "$.user.id", Op: PredicateExists}}}, line, false},
+		{"every predicate", MessageFilter{Where: []FieldPredicate{
+			{Path: "$.level", Op: PredicateEq, Value: "error"},
+			{Path: "$.code", Op: PredicateGt, Value: 500},
+		}}, line, false},
+	}
+
+	for _, c := range cases {
+		keep, err := c.filter.compile()
+		if err != nil {
+			t.Fatalf("%s: filter couldn't be compiled: %s", c.name, err.Error())
+		}
+
+		if keep(c.data) != c.matches {
+			t.Errorf("%s: expected match to be %v", c.name, c.matches)
+		}
+	}
+
+	invalid := []MessageFilter{
+		{Match: "("},
+		{Where: []FieldPredicate{{Path: "$.a[", Op: PredicateEq}}},
+		{Where: []FieldPredicate{{Path: "$.a", Op: "approximately"}}},
+		{Where: []FieldPredicate{{Path: "$.a", Op: PredicateMatches, Value: 1}}},
+	}
+
+	for _, filter := range invalid {
+		if _, err := filter.compile(); err == nil {
+			t.Errorf("expected filter %+v to be rejected", filter)
+		}
+	}
+}
+
+func TestRefine(t *testing.T) {
+	collect := func(opts RefineOptions, data ...any) []any {
+		in := make(chan Message[any], len(data))
+		for i, item := range data {
+			in <- Message[any]{MessageId: int32(i), Data: item}
+		}
+		close(in)
+
+		out, err := Refine(context.Background(), in, opts)
+		if err != nil {
+			t.Fatalf("refine failed: %s", err.Error())
+		}
+
+		received := []any{}
+		for message := range out {
+			received = append(received, message.Data)
+		}
+		return received
+	}
+
+	filtered := collect(RefineOptions{Filter: &MessageFilter{Contains: "ERROR"}}, "ERROR a", "INFO b", "ERROR c")
+	if fmt.Sprint(filtered) != "[ERROR a ERROR c]" {
+		t.Fatalf("unexpected filtered messages: %v", filtered)
+	}
+
+	sampled := collect(RefineOptions{Throttle: ThrottleOptions{SampleEvery: 3}}, 0, 1, 2, 3, 4, 5, 6)
+	if fmt.Sprint(sampled) != "[0 3 6]" {
+		t.Fatalf("unexpected sampled messages: %v", sampled)
+	}
+
+	// All of these arrive at once, so only the burst gets through
+	limited := collect(RefineOptions{Throttle: ThrottleOptions{MaxPerSecond: 2}}, 0, 1, 2, 3, 4)
+	if fmt.Sprint(limited) != "[0 1]" {
+		t.Fatalf("unexpected rate limited messages: %v", limited)
+	}
+
+	// The last message is flushed when the input closes
+	debounced := collect(RefineOptions{Throttle: ThrottleOptions{Debounce: time.Hour}}, 0, 1, 2)
+	if fmt.Sprint(debounced) != "[2]" {
+		t.Fatalf("unexpected debounced messages: %v", debounced)
+	}
+
+	in := make(chan Message[any])
+	out, err := Refine(context.Background(), in, RefineOptions{Throttle: ThrottleOptions{Debounce: 20 * time.Millisecond}})
+	if err != nil {
+		t.Fatalf("refine failed: %s", err.Error())
+	}
+
+	in <- Message[any]{MessageId: 0, Data: "first"}
+	in <- Message[any]{MessageId: 1, Data: "second"}
+
+	select {
+	case message := <-out:
+		if message.Data != "second" {
+			t.Fatalf("expected the latest message after the debounce, got %v", message.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("debounced message was never delivered")
+	}
+	close(in)
+
+	if _, err := Refine(context.Background(), in, RefineOptions{Throttle: ThrottleOptions{SampleEvery: -1}}); err == nil {
+		t.Fatalf("expected invalid throttle options to be rejected")
+	}
+}
+
+func TestMetaTopicStress(t *testing.T) {
+	registry := &Registry{}
+	if err := registry.CreateMetaTopics(); err != nil {
+		t.Fatalf("meta topic couldn't be created: %s", err.Error())
+	}
+
+	// This subscriber doesn't read anything until every operation is done, so the meta
+	// topic is stuck the whole time. None of the topics should have to wait for it.
+	meta, err := Subscribe[MetaTopicInfo](registry, MetaTopic, SubscribeOptions{Policy: BackpressureBlock, BufferSize: 1})
+	if err != nil {
+		t.Fatalf("couldn't subscribe to meta topic: %s", err.Error())
+	}
+	defer meta.Unsubscribe()
+
+	const workers = 16
+	const topicsPerWorker = 200
+
+	stopInfo := make(chan struct{})
+	infoDone := make(chan struct{})
+	go func() {
+		defer close(infoDone)
+		for {
+			select {
+			case <-stopInfo:
+				return
+			default:
+				registry.GetTopicInfo()
+			}
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+
+			for i := 0; i < topicsPerWorker; i++ {
+				topic, err := CreateTopic[int](registry, TopicId{Category: fmt.Sprintf("/stress/%d", w), Key: fmt.Sprint(i)}, TopicOptions{})
+				if err != nil {
+					t.Errorf("topic couldn't be created: %s", err.Error())
+					return
+				}
+
+				first, _ := topic.subscribe(SubscribeOptions{Policy: BackpressureDropOldest})
+				second, _ := topic.subscribe(SubscribeOptions{Policy: BackpressureDropOldest})
+				topic.Publish(i)
+				first.Unsubscribe()
+				topic.Close()
+				second.Unsubscribe()
+			}
+		}(w)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(30 * time.Second):
+		t.Fatalf("topic operations deadlocked")
+	}
+
+	// Every topic should have been created, subscribed to twice, unsubscribed from once, and
+	// closed, in that order. The second unsubscribe happens after closing, so it isn't a change.
+	expected := registry.notifier.counter()
+	events := map[string][]string{}
+	for id := int32(0); id < expected; id++ {
+		select {
+		case message := <-meta.Out:
+			if message.MessageId != id {
+				t.Fatalf("expected meta message %d, got %d", id, message.MessageId)
+			}
+
+			switch data := message.Data.Data.(type) {
+			case TopicInfo:
+				events[data.Id.String()] = append(events[data.Id.String()], fmt.Sprintf("update %d", data.SubscriberCount))
+			case TopicId:
+				events[data.String()] = append(events[data.String()], "close")
+			}
+
+		case <-time.After(5 * time.Second):
+			t.Fatalf("only got %d of %d meta messages", id, expected)
+		}
+	}
+
+	for w := 0; w < workers; w++ {
+		for i := 0; i < topicsPerWorker; i++ {
+			id := TopicId{Category: fmt.Sprintf("/stress/%d", w), Key: fmt.Sprint(i)}
+			got := strings.Join(events[id.String()], ", ")
+			if got != "update 0, update 1, update 2, update 1, close" {
+				t.Fatalf("unexpected meta messages for %s: %s", id.String(), got)
+			}
+		}
+	}
+
+	// Getting info about the meta topic waits for it to finish publishing, so this can
+	// only finish once the meta messages have been read.
+	close(stopInfo)
+	<-infoDone
+}
+
+func TestClosedTopicsAreEvicted(t *testing.T) {
+	registry := &Registry{}
+	if err := registry.CreateMetaTopics(); err != nil {
+		t.Fatalf("meta topic couldn't be created: %s", err.Error())
+	}
+
+	meta, err := Subscribe[MetaTopicInfo](registry, MetaTopic, SubscribeOptions{BufferSize: 16})
+	if err != nil {
+		t.Fatalf("couldn't subscribe to meta topic: %s", err.Error())
+	}
+	defer meta.Unsubscribe()
+
+	topicId := TopicId{Category: "/test", Key: "evicted"}
+	topic, err := CreateTopic[int](registry, topicId, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	topic.Close()
+
+	if _, found := registry.GetTopicInfo().Info[topicId.String()]; found {
+		t.Fatalf("closed topic is still in the registry")
+	}
+
+	if _, err := Subscribe[int](registry, topicId, SubscribeOptions{}); !errors.Is(err, ErrTopicDoesntExist) {
+		t.Fatalf("expected subscribing to a closed topic to fail, got %v", err)
+	}
+
+	// Subscribers to the meta topic still find out about the topic closing
+	for {
+		select {
+		case message := <-meta.Out:
+			if id, ok := message.Data.Data.(TopicId); ok && message.Data.Kind == "close" && id == topicId {
+				recreated, err := CreateTopic[int](registry, topicId, TopicOptions{})
+				if err != nil {
+					t.Fatalf("closed topic couldn't be recreated: %s", err.Error())
+				}
+
+				// Closing the old topic again must not evict its replacement
+				topic.Close()
+				if _, found := registry.GetTopicInfo().Info[topicId.String()]; !found {
+					t.Fatalf("recreated topic was evicted by the old one")
+				}
+
+				recreated.Close()
+				return
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("meta topic didn't get a close message")
+		}
+	}
+}
+
+func TestIdleTopicExpiry(t *testing.T) {
+	registry := &Registry{}
+
+	if _, err := CreateTopic[int](registry, TopicId{Category: "/test", Key: "bad"}, TopicOptions{IdleTTL: -time.Second}); !errors.Is(err, ErrInvalidTopicOptions) {
+		t.Fatalf("expected a negative idle TTL to be rejected, got %v", err)
+	}
+
+	const ttl = 200 * time.Millisecond
+	waitForClose := func(topic *Topic[int]) time.Duration {
+		start := time.Now()
+		for !topic.IsClosed() {
+			if time.Since(start) > 2*time.Second {
+				t.Fatalf("idle topic %s was never closed", topic.Id.String())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+		return time.Since(start)
+	}
+
+	idle, err := CreateTopic[int](registry, TopicId{Category: "/test", Key: "idle"}, TopicOptions{IdleTTL: ttl})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	waitForClose(idle)
+
+	if _, found := registry.GetTopicInfo().Info[idle.Id.String()]; found {
+		t.Fatalf("expired topic is still in the registry")
+	}
+
+	// Subscribers keep the topic open, however long they're subscribed for
+	subscribed, err := CreateTopic[int](registry, TopicId{Category: "/test", Key: "subscribed"}, TopicOptions{IdleTTL: ttl})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+
+	sub, err := subscribed.subscribe(SubscribeOptions{})
+	if err != nil {
+		t.Fatalf("couldn't subscribe: %s", err.Error())
+	}
+
+	time.Sleep(3 * ttl)
+	if subscribed.IsClosed() {
+		t.Fatalf("topic with a subscriber expired")
+	}
+
+	sub.Unsubscribe()
+	if elapsed := waitForClose(subscribed); elapsed < ttl {
+		t.Fatalf("topic expired %s after its last subscriber left, before its TTL of %s", elapsed, ttl)
+	}
+
+	// Publishing also keeps the topic open
+	published, err := CreateTopic[int](registry, TopicId{Category: "/test", Key: "published"}, TopicOptions{IdleTTL: ttl})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+
+	for i := 0; i < 6; i++ {
+		time.Sleep(ttl / 5)
+		published.Publish(i)
+	}
+
+	if published.IsClosed() {
+		t.Fatalf("topic that was published to expired")
+	}
+	waitForClose(published)
+}
+
+func TestCloseCategory(t *testing.T) {
+	registry := &Registry{}
+
+	ids := []TopicId{
+		{Category: "/app-topics/a", Key: "1"},
+		{Category: "/app-topics/a/nested", Key: "2"},
+		{Category: "/app-topics/ab", Key: "3"},
+	}
+
+	topics := []*Topic[int]{}
+	for _, id := range ids {
+		topic, err := CreateTopic[int](registry, id, TopicOptions{})
+		if err != nil {
+			t.Fatalf("topic couldn't be created: %s", err.Error())
+		}
+		topics = append(topics, topic)
+	}
+
+	registry.CloseCategory("/app-topics/a")
+
+	if !topics[0].IsClosed() || !topics[1].IsClosed() {
+		t.Fatalf("topics in the category weren't closed")
+	}
+
+	if topics[2].IsClosed() {
+		t.Fatalf("topic in a different category with the same prefix was closed")
+	}
+
+	if info := registry.GetTopicInfo(); len(info.Info) != 1 {
+		t.Fatalf("expected only one topic to be left in the registry, got %d", len(info.Info))
+	}
+}
+
+func TestPubsubMetrics(t *testing.T) {
+	registry := &Registry{}
+	if err := registry.CreateMetaTopics(); err != nil {
+		t.Fatalf("meta topic couldn't be created: %s", err.Error())
+	}
+
+	busy, err := CreateTopic[string](registry, TopicId{Category: "/test", Key: "busy"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer busy.Close()
+
+	quiet, err := CreateTopic[any](registry, TopicId{Category: "/test", Key: "quiet"}, TopicOptions{})
+	if err != nil {
+		t.Fatalf("topic couldn't be created: %s", err.Error())
+	}
+	defer quiet.Close()
+
+	sub, err := busy.subscribe(SubscribeOptions{Policy: BackpressureDropOldest, BufferSize: 8})
+	if err != nil {
+		t.Fatalf("couldn't subscribe: %s", err.Error())
+	}
+	defer sub.Unsubscribe()
+
+	for i := 0; i < 20; i++ {
+		busy.Publish("hello")
+	}
+	quiet.Publish(json.RawMessage(`{"a":1}`))
+	// Other messages aren't encoded just to measure them
+	quiet.Publish(map[string]int{"a": 1})
+
+	info := busy.GetInfo()
+	if info.Published != 20 || info.Bytes != 20*int64(len(`"hello"`)) {
+		t.Fatalf("unexpected publish stats: %+v", info.PublishStatsInfo)
+	}
+	if info.LastPublishedAt == nil || info.MessagesPerSec <= 0 || info.BytesPerSec <= 0 {
+		t.Fatalf("expected recent publish stats, got %+v", info.PublishStatsInfo)
+	}
+	if info.Subscribers[0].QueueDepth != 8 || info.Subscribers[0].Dropped != 12 {
+		t.Fatalf("unexpected subscriber stats: %+v", info.Subscribers[0])
+	}
+
+	metrics := registry.GetMetrics()
+	summary := metrics.Summary
+	if summary.Published != 22 || summary.Bytes != info.Bytes+int64(len(`{"a":1}`)) {
+		t.Fatalf("unexpected summary totals: %+v", summary.PublishStatsInfo)
+	}
+	if summary.QueueDepth != 8 || summary.Dropped != 12 {
+		t.Fatalf("unexpected summary queue stats: queueDepth=%d dropped=%d", summary.QueueDepth, summary.Dropped)
+	}
+	if len(summary.BusiestTopics) == 0 || summary.BusiestTopics[0].Id != busy.Id {
+		t.Fatalf("expected %s to be the busiest topic, got %+v", busy.Id.String(), summary.BusiestTopics)
+	}
+	if _, found := metrics.Topics[quiet.Id.String()]; !found {
+		t.Fatalf("metrics didn't include every topic")
+	}
+
+	metricsSub, err := Subscribe[RegistryMetrics](registry, MetricsTopic, SubscribeOptions{BufferSize: 1, Policy: BackpressureCoalesceLatest})
+	if err != nil {
+		t.Fatalf("couldn't subscribe to metrics topic: %s", err.Error())
+	}
+	defer metricsSub.Unsubscribe()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go registry.PublishMetrics(ctx, 10*time.Millisecond)
+
+	select {
+	case message := <-metricsSub.Out:
+		if message.Data.Published < 21 {
+			t.Fatalf("unexpected published metrics: %+v", message.Data)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("metrics were never published")
+	}
+}
+
+func TestRateMeter(t *testing.T) {
+	meter := rateMeter{}
+	start := time.Now()
+
+	// A steady 5 messages per second should settle at a rate of about 5
+	for i := 0; i < 500; i++ {
+		meter.add(start.Add(time.Duration(i)*200*time.Millisecond), 1)
+	}
+
+	now := start.Add(499 * 200 * time.Millisecond)
+	if rate := meter.at(now); rate < 4.5 || rate > 5.5 {
+		t.Fatalf("expected a rate of about 5, got %f", rate)
+	}
+
+	if rate := meter.at(now.Add(time.Minute)); rate > 0.1 {
+		t.Fatalf("expected the rate to decay after a minute without messages, got %f", rate)
+	}
+}
@@ -0,0 +1,157 @@
+package pubsub
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrRequestTimeout = errors.New("request timed out")
+	ErrRequestFailed  = errors.New("request failed")
+)
+
+// Every request gets its own reply topic in this category, keyed by its correlation ID
+const RepliesCategory = "/replies"
+
+type RequestKind string
+
+const (
+	RequestKindRequest RequestKind = "request"
+	// Sent to the request's topic when the requester stops waiting for a reply, so that
+	// the responder can stop working on it.
+	RequestKindCancel RequestKind = "cancel"
+)
+
+// The message published to a topic to make a request. Responders reply by calling
+// `SendReply` with the request's `ReplyTo` topic and `CorrelationId`. Requests are only sent
+// to the topic's current subscribers, and are never retained or persisted, since nobody
+// can reply to them once the requester has stopped waiting.
+type RequestMessage struct {
+	Kind          RequestKind `json:"kind"`
+	CorrelationId string      `json:"correlationId"`
+	ReplyTo       TopicId     `json:"replyTo"`
+	// The time after which the requester stops waiting for a reply
+	Deadline time.Time `json:"deadline"`
+	Data     any       `json:"data,omitempty"`
+}
+
+type Reply struct {
+	CorrelationId string `json:"correlationId"`
+	Data          any    `json:"data,omitempty"`
+	// Error is set if the responder failed to handle the request
+	Error string `json:"error,omitempty"`
+}
+
+func newCorrelationId() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Publishes a request to the target topic and waits for a reply, until the timeout passes
+// or the context is cancelled. In both cases, a cancellation is published to the target topic.
+// The request's data has to match the topic's schema, if it has one.
+func Request(ctx context.Context, r *Registry, target TopicId, data any, timeout time.Duration) (any, error) {
+	topic, err := getTopic(r, target)
+	if err != nil {
+		return nil, err
+	}
+
+	if topic.IsClosed() {
+		return nil, fmt.Errorf("%w: %s", ErrTopicClosed, target.String())
+	}
+
+	if err := topic.validateAny(data); err != nil {
+		return nil, err
+	}
+
+	correlationId, err := newCorrelationId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create correlation ID: %w", err)
+	}
+
+	replyTo := TopicId{Category: RepliesCategory, Key: correlationId}
+	replyTopic, err := CreateTopic[Reply](r, replyTo, TopicOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer replyTopic.Close()
+
+	// Subscribe before publishing the request, so that the reply can't be missed
+	sub, err := replyTopic.subscribe(SubscribeOptions{BufferSize: 1})
+	if err != nil {
+		return nil, err
+	}
+	defer sub.Unsubscribe()
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	deadline, _ := ctx.Deadline()
+	request := RequestMessage{
+		Kind:          RequestKindRequest,
+		CorrelationId: correlationId,
+		ReplyTo:       replyTo,
+		Deadline:      deadline,
+		Data:          data,
+	}
+
+	if err := topic.sendAny(request); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply, ok := <-sub.Out:
+		if !ok {
+			return nil, fmt.Errorf("%w: reply topic was closed", ErrRequestFailed)
+		}
+
+		if reply.Data.Error != "" {
+			return nil, fmt.Errorf("%w: %s", ErrRequestFailed, reply.Data.Error)
+		}
+
+		return reply.Data.Data, nil
+
+	case <-ctx.Done():
+		request.Kind = RequestKindCancel
+		request.Data = nil
+		topic.sendAny(request)
+
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("%w after %s: %s", ErrRequestTimeout, timeout, target.String())
+		}
+
+		return nil, ctx.Err()
+	}
+}
+
+// Replies to a request. This fails if the requester has stopped waiting.
+func SendReply(r *Registry, replyTo TopicId, reply Reply) error {
+	topicUntyped, err := getTopic(r, replyTo)
+	if err != nil {
+		return err
+	}
+
+	topic, ok := topicUntyped.(*Topic[Reply])
+	if !ok || replyTo.Category != RepliesCategory {
+		return fmt.Errorf("%w: %s is not a reply topic", ErrTopicDoesntExist, replyTo.String())
+	}
+
+	if reply.CorrelationId != replyTo.Key {
+		return fmt.Errorf("correlation ID '%s' doesn't match reply topic %s", reply.CorrelationId, replyTo.String())
+	}
+
+	if topic.IsClosed() {
+		return fmt.Errorf("%w: %s", ErrTopicClosed, replyTo.String())
+	}
+
+	topic.Publish(reply)
+	return nil
+}
@@ -0,0 +1,90 @@
+package pubsub
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+var ErrSchemaMismatch = errors.New("message doesn't match the topic's schema")
+
+// The URL the schema is registered under while compiling. It never gets loaded.
+const topicSchemaUrl = "topic-schema.json"
+
+func compileTopicSchema(raw json.RawMessage) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+
+	// Schemas come from apps, so they shouldn't be able to make robin read
+	// files or make requests through `$ref`.
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("topic schemas can't reference other documents, tried to load %s", url)
+	}
+
+	if err := compiler.AddResource(topicSchemaUrl, bytes.NewReader(raw)); err != nil {
+		return nil, fmt.Errorf("invalid topic schema: %w", err)
+	}
+
+	schema, err := compiler.Compile(topicSchemaUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid topic schema: %w", err)
+	}
+
+	return schema, nil
+}
+
+// Validates a message against the topic's schema, if it has one. The error lists every
+// part of the message that didn't match, e.g. `/name: expected string, but got number`.
+func (topic *Topic[T]) ValidateMessage(message T) error {
+	if topic.schema == nil {
+		return nil
+	}
+
+	// The validator only understands the types produced by decoding JSON
+	buf, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("%w: message can't be encoded as JSON: %s", ErrSchemaMismatch, err.Error())
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(buf))
+	decoder.UseNumber()
+
+	var value any
+	if err := decoder.Decode(&value); err != nil {
+		return fmt.Errorf("%w: %s", ErrSchemaMismatch, err.Error())
+	}
+
+	err = topic.schema.Validate(value)
+	if err == nil {
+		return nil
+	}
+
+	var validationErr *jsonschema.ValidationError
+	if !errors.As(err, &validationErr) {
+		return fmt.Errorf("%w: %s", ErrSchemaMismatch, err.Error())
+	}
+
+	problems := []string{}
+	for _, unit := range validationErr.BasicOutput().Errors {
+		// The first unit is a summary of the others
+		if unit.Error == "" || strings.HasPrefix(unit.Error, "doesn't validate with") {
+			continue
+		}
+
+		location := unit.InstanceLocation
+		if location == "" {
+			location = "/"
+		}
+		problems = append(problems, fmt.Sprintf("%s: %s", location, unit.Error))
+	}
+
+	if len(problems) == 0 {
+		problems = append(problems, validationErr.Error())
+	}
+
+	return fmt.Errorf("%w %s: %s", ErrSchemaMismatch, topic.Id.String(), strings.Join(problems, "; "))
+}
@@ -0,0 +1,234 @@
+package pubsub
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// BackpressurePolicy decides what happens when a message is published to a subscriber
+// whose buffer is already full.
+type BackpressurePolicy string
+
+const (
+	// The publisher waits until the subscriber has room in its buffer. No messages are lost,
+	// but a slow subscriber slows down every publisher of the topic.
+	BackpressureBlock BackpressurePolicy = "block"
+	// The oldest buffered message is dropped to make room for the new one.
+	BackpressureDropOldest BackpressurePolicy = "drop-oldest"
+	// The new message is dropped, and the buffered messages are kept.
+	BackpressureDropNewest BackpressurePolicy = "drop-newest"
+	// Only the latest message is kept, replacing any message that hasn't been read yet.
+	// The buffer size is always 1 under this policy.
+	BackpressureCoalesceLatest BackpressurePolicy = "coalesce-latest"
+)
+
+const DefaultBufferSize = 4
+
+// Buffers are allocated up front, and their size can come from clients, so it's limited
+const MaxBufferSize = 64 * 1024
+
+type SubscribeOptions struct {
+	// Policy defaults to `BackpressureBlock`
+	Policy BackpressurePolicy `json:"policy,omitempty"`
+	// BufferSize defaults to `DefaultBufferSize`, and can be at most `MaxBufferSize`
+	BufferSize int `json:"bufferSize,omitempty"`
+	// Cursor selects the retained messages delivered before any live messages.
+	// The buffer grows to fit them, so that none are dropped.
+	Cursor Cursor `json:"cursor"`
+	// AppId is the app that is subscribing, which must be allowed to by the topic's ACL.
+	// It's empty when robin itself subscribes. This can't be set through JSON, since
+	// clients could otherwise pretend to be robin.
+	AppId string `json:"-"`
+}
+
+func (opts SubscribeOptions) normalize() (SubscribeOptions, error) {
+	if err := opts.Cursor.validate(); err != nil {
+		return opts, err
+	}
+
+	switch opts.Policy {
+	case "":
+		opts.Policy = BackpressureBlock
+	case BackpressureBlock, BackpressureDropOldest, BackpressureDropNewest:
+	case BackpressureCoalesceLatest:
+		opts.BufferSize = 1
+	default:
+		return opts, fmt.Errorf("unknown backpressure policy '%s'", opts.Policy)
+	}
+
+	if opts.BufferSize < 0 {
+		return opts, fmt.Errorf("subscriber buffer size must not be negative, got %d", opts.BufferSize)
+	}
+
+	if opts.BufferSize > MaxBufferSize {
+		return opts, fmt.Errorf("subscriber buffer size can be at most %d, got %d", MaxBufferSize, opts.BufferSize)
+	}
+
+	if opts.BufferSize == 0 {
+		opts.BufferSize = DefaultBufferSize
+	}
+
+	return opts, nil
+}
+
+type SubscriberInfo struct {
+	Policy     BackpressurePolicy `json:"policy"`
+	BufferSize int                `json:"bufferSize"`
+	// The number of messages that were dropped because this subscriber's buffer was full
+	Dropped int64 `json:"dropped"`
+	// The number of messages waiting in the buffer to be received
+	QueueDepth int `json:"queueDepth"`
+}
+
+// A subscriber is owned by its topic, and all of its methods except `stop` must
+// be called with the topic's lock held.
+type subscriber[T any] interface {
+	// Fills the subscriber's buffer with retained messages. This is called once, before the
+	// subscriber is added to the topic.
+	preload(messages []Message[T])
+	// Delivers a message according to the subscriber's backpressure policy, and returns
+	// the number of messages that were dropped to do so.
+	deliver(message Message[T]) int64
+	// Stops delivery to the subscriber, including any delivery that is currently blocked.
+	// This is safe to call without the topic's lock.
+	stop()
+	// Called when the topic closes
+	close()
+	info() SubscriberInfo
+}
+
+// A bounded buffer that applies a backpressure policy when it's full. A queue can be shared
+// by subscribers on several topics, so it serializes deliveries with its own lock instead of
+// relying on a topic's lock.
+type queue[Out any] struct {
+	opts SubscribeOptions
+	out  chan Out
+
+	// `done` is closed when the subscriber unsubscribes, so that a publisher which is
+	// blocked on a full buffer can give up, instead of holding the topic's lock forever.
+	done     chan struct{}
+	stopOnce sync.Once
+
+	// This mutex serializes non-blocking deliveries, so that making room in a full
+	// buffer doesn't race with another publisher.
+	m       sync.Mutex
+	dropped atomic.Int64
+}
+
+func newQueue[Out any](opts SubscribeOptions) *queue[Out] {
+	return &queue[Out]{
+		opts: opts,
+		out:  make(chan Out, opts.BufferSize),
+		done: make(chan struct{}),
+	}
+}
+
+// Returns the number of messages dropped to push the item
+func (q *queue[Out]) push(item Out) int64 {
+	if q.opts.Policy == BackpressureBlock {
+		select {
+		case q.out <- item:
+		case <-q.done:
+		}
+		return 0
+	}
+
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if q.opts.Policy == BackpressureDropNewest {
+		select {
+		case q.out <- item:
+			return 0
+		default:
+			q.dropped.Add(1)
+			return 1
+		}
+	}
+
+	// The subscriber might be reading concurrently, so we keep trying until there's room.
+	// This terminates, because the lock guarantees we're the only writer.
+	var dropped int64
+	for {
+		select {
+		case q.out <- item:
+			q.dropped.Add(dropped)
+			return dropped
+		default:
+		}
+
+		select {
+		case <-q.out:
+			dropped += 1
+		default:
+		}
+	}
+}
+
+func (q *queue[Out]) stop() {
+	q.stopOnce.Do(func() {
+		close(q.done)
+	})
+}
+
+func (q *queue[Out]) info() SubscriberInfo {
+	return SubscriberInfo{
+		Policy:     q.opts.Policy,
+		BufferSize: q.opts.BufferSize,
+		Dropped:    q.dropped.Load(),
+		QueueDepth: len(q.out),
+	}
+}
+
+// A subscriber that converts the topic's messages into `Out` and pushes them into a queue.
+type channelSubscriber[T any, Out any] struct {
+	queue   *queue[Out]
+	convert func(Message[T]) Out
+
+	// Whether the queue belongs to this subscriber alone. Shared queues are
+	// not preloaded or closed when the topic closes.
+	ownsQueue bool
+}
+
+func newChannelSubscriber[T any, Out any](opts SubscribeOptions, convert func(Message[T]) Out) *channelSubscriber[T, Out] {
+	return &channelSubscriber[T, Out]{
+		queue:     newQueue[Out](opts),
+		convert:   convert,
+		ownsQueue: true,
+	}
+}
+
+func (sub *channelSubscriber[T, Out]) preload(messages []Message[T]) {
+	if len(messages) == 0 || !sub.ownsQueue {
+		return
+	}
+
+	if sub.queue.opts.Policy == BackpressureCoalesceLatest {
+		messages = messages[len(messages)-1:]
+	}
+
+	// Nobody can have a reference to the channel yet, so it's safe to replace it
+	sub.queue.out = make(chan Out, sub.queue.opts.BufferSize+len(messages))
+	for _, message := range messages {
+		sub.queue.out <- sub.convert(message)
+	}
+}
+
+func (sub *channelSubscriber[T, Out]) deliver(message Message[T]) int64 {
+	return sub.queue.push(sub.convert(message))
+}
+
+func (sub *channelSubscriber[T, Out]) stop() {
+	sub.queue.stop()
+}
+
+func (sub *channelSubscriber[T, Out]) close() {
+	if sub.ownsQueue {
+		close(sub.queue.out)
+	}
+}
+
+func (sub *channelSubscriber[T, Out]) info() SubscriberInfo {
+	return sub.queue.info()
+}
@@ -0,0 +1,248 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"robinplatform.dev/internal/compilerServer"
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/process"
+	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
+)
+
+// Apps can only manage processes in their own category, `/app/{app-id}`. This is enforced
+// by building every process ID from the app's ID, rather than accepting raw process IDs.
+
+type SpawnAppProcessInput struct {
+	AppId    string   `json:"appId"`
+	Category []string `json:"category"`
+	Key      string   `json:"key"`
+
+	Command string            `json:"command"`
+	Args    []string          `json:"args"`
+	Env     map[string]string `json:"env"`
+	// WorkDir defaults to the app's directory
+	WorkDir string               `json:"workDir"`
+	Shell   *process.ShellConfig `json:"shell"`
+}
+
+var SpawnAppProcess = AppsRpcMethod[SpawnAppProcessInput, process.Process]{
+	Name: "SpawnAppProcess",
+	Run: func(req RpcRequest[SpawnAppProcessInput]) (process.Process, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return process.Process{}, err
+		}
+
+		app, _, err := req.Server.compiler.GetApp(req.Data.AppId)
+		if err != nil {
+			return process.Process{}, Errorf(http.StatusInternalServerError, "%s", err.Error())
+		}
+
+		if req.Data.Key == "" {
+			return process.Process{}, Errorf(http.StatusBadRequest, "process is missing a key")
+		}
+
+		if req.Data.Command == "" {
+			return process.Process{}, Errorf(http.StatusBadRequest, "process is missing a command")
+		}
+
+		workDir := req.Data.WorkDir
+		if workDir == "" {
+			workDir, err = app.GetAppDir()
+			if err != nil {
+				return process.Process{}, Errorf(http.StatusInternalServerError, "%s", err.Error())
+			}
+		} else if !filepath.IsAbs(workDir) {
+			return process.Process{}, Errorf(http.StatusBadRequest, "process working directory must be an absolute path, got '%s'", workDir)
+		}
+
+		env := make(map[string]string, len(req.Data.Env)+3)
+		for key, value := range req.Data.Env {
+			env[key] = value
+		}
+		env["ROBIN_APP_ID"] = app.Id
+		env["ROBIN_PROCESS_TYPE"] = "process"
+		env["ROBIN_PROJECT_PATH"] = project.GetProjectPathOrExit()
+
+		proc, err := process.Manager.SpawnFromPathVar(process.ProcessConfig{
+			Id:      app.AppProcessId(req.Data.Category, req.Data.Key),
+			WorkDir: workDir,
+			Env:     env,
+			Command: req.Data.Command,
+			Args:    req.Data.Args,
+			Shell:   req.Data.Shell,
+		})
+		if err != nil {
+			return process.Process{}, processHttpError(err)
+		}
+
+		return proc, nil
+	},
+}
+
+type ListAppProcessesInput struct {
+	AppId string `json:"appId"`
+}
+
+var ListAppProcesses = AppsRpcMethod[ListAppProcessesInput, []process.Process]{
+	Name: "ListAppProcesses",
+	Run: func(req RpcRequest[ListAppProcessesInput]) ([]process.Process, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return nil, err
+		}
+
+		app, _, err := req.Server.compiler.GetApp(req.Data.AppId)
+		if err != nil {
+			return nil, Errorf(http.StatusInternalServerError, "%s", err.Error())
+		}
+
+		category := compilerServer.AppProcessCategory(app.Id)
+		processes := []process.Process{}
+		for _, proc := range process.Manager.CopyOutData() {
+			if proc.Id.IsInCategory(category) {
+				processes = append(processes, proc)
+			}
+		}
+
+		return processes, nil
+	},
+}
+
+type AppProcessIdInput struct {
+	AppId    string   `json:"appId"`
+	Category []string `json:"category"`
+	Key      string   `json:"key"`
+}
+
+var KillAppProcess = AppsRpcMethod[AppProcessIdInput, struct{}]{
+	Name: "KillAppProcess",
+	Run: func(req RpcRequest[AppProcessIdInput]) (struct{}, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return struct{}{}, err
+		}
+
+		app, _, err := req.Server.compiler.GetApp(req.Data.AppId)
+		if err != nil {
+			return struct{}{}, Errorf(http.StatusInternalServerError, "%s", err.Error())
+		}
+
+		if err := process.Manager.Kill(app.AppProcessId(req.Data.Category, req.Data.Key)); err != nil {
+			return struct{}{}, processHttpError(err)
+		}
+
+		return struct{}{}, nil
+	},
+}
+
+var SubscribeAppProcessLogs = Stream[AppProcessIdInput, any]{
+	Name: "SubscribeAppProcessLogs",
+	Run: func(req *StreamRequest[AppProcessIdInput, any]) error {
+		input, err := req.ParseInput()
+		if err != nil {
+			return err
+		}
+
+		app, _, err := req.Server.compiler.GetApp(input.AppId)
+		if err != nil {
+			// the error messages from GetApp() are already user-friendly
+			return err
+		}
+
+		// Logs topics belong to robin, so apps can only read the logs of their own processes.
+		// The app is identified by the websocket's token, not by the app ID in the input.
+		if err := checkActingApp(req.AppId, app.Id); err != nil {
+			return err
+		}
+
+		processId := app.AppProcessId(input.Category, input.Key)
+		if _, found := process.Manager.FindById(processId); !found {
+			return fmt.Errorf("%w: %s", process.ErrProcessNotFound, processId)
+		}
+
+		return PipeTopic(processId.LogsTopicId(), pubsub.SubscribeOptions{}, pubsub.RefineOptions{}, req)
+	},
+}
+
+type RemoveAppDataInput struct {
+	AppId string `json:"appId"`
+}
+
+// Removes every process that an app spawned, and deletes its durable topics. The CLI calls
+// this after removing an app from the project, so that the process db is only ever written
+// to by the running server.
+var RemoveAppData = InternalRpcMethod[RemoveAppDataInput, struct{}]{
+	Name: "RemoveAppData",
+	Run: func(req RpcRequest[RemoveAppDataInput]) (struct{}, *HttpError) {
+		if req.Data.AppId == "" {
+			return struct{}{}, Errorf(http.StatusBadRequest, "'appId' is required")
+		}
+
+		if err := process.Manager.RemoveCategory(compilerServer.AppProcessCategory(req.Data.AppId)); err != nil {
+			return struct{}{}, processHttpError(err)
+		}
+
+		req.Server.compiler.RemoveApp(req.Data.AppId)
+		return struct{}{}, nil
+	},
+}
+
+// Returns the app that a process or topic category belongs to, if it's under `root`
+func appIdFromCategory(root string, category string) (string, bool) {
+	rest, ok := strings.CutPrefix(category, root+"/")
+	if !ok {
+		return "", false
+	}
+
+	appId, _, _ := strings.Cut(rest, "/")
+	return appId, true
+}
+
+// Removes the processes and durable topics of apps that are no longer in the project, e.g.
+// because they were removed with `robin rm` while the server wasn't running. This has to run
+// after durable topics are restored.
+func (server *Server) removeOrphanedAppData() {
+	apps, err := project.GetAllProjectApps()
+	if err != nil {
+		logger.Warn("Failed to load project apps, skipping cleanup of their data", log.Ctx{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	installed := make(map[string]bool, len(apps))
+	for _, app := range apps {
+		installed[app.Id] = true
+	}
+
+	// App processes are in `/app/{app-id}/...`, while the daemons themselves are in `/app`
+	orphanedProcesses := make(map[string]bool)
+	for _, proc := range process.Manager.CopyOutData() {
+		if appId, ok := appIdFromCategory("/app", proc.Id.Category); ok && !installed[appId] {
+			orphanedProcesses[appId] = true
+		}
+	}
+
+	orphanedTopics := make(map[string]bool)
+	for _, info := range pubsub.Topics.GetTopicInfo().Info {
+		if appId, ok := appIdFromCategory("/app-topics", info.Id.Category); ok && !installed[appId] {
+			orphanedTopics[appId] = true
+		}
+	}
+
+	for appId := range orphanedProcesses {
+		category := compilerServer.AppProcessCategory(appId)
+		if err := process.Manager.RemoveCategory(category); err != nil {
+			logger.Err("Failed to remove processes of removed app", log.Ctx{
+				"category": category,
+				"err":      err.Error(),
+			})
+		}
+	}
+
+	for appId := range orphanedTopics {
+		server.compiler.RemoveApp(appId)
+	}
+}
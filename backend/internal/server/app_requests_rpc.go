@@ -0,0 +1,155 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"robinplatform.dev/internal/pubsub"
+)
+
+const (
+	defaultAppRequestTimeout = 10 * time.Second
+	maxAppRequestTimeout     = 2 * time.Minute
+)
+
+type SendAppRequestInput struct {
+	AppId string `json:"appId"`
+	// TargetAppId is the app whose topic receives the request. It defaults to `AppId`.
+	TargetAppId string   `json:"targetAppId"`
+	Category    []string `json:"category"`
+	Key         string   `json:"key"`
+	Data        any      `json:"data"`
+	// TimeoutMs defaults to 10 seconds, and can be at most 2 minutes
+	TimeoutMs int64 `json:"timeoutMs"`
+}
+
+func sendAppRequest(ctx context.Context, server *Server, callerAppId string, input SendAppRequestInput) (any, *HttpError) {
+	if err := checkActingApp(callerAppId, input.AppId); err != nil {
+		return nil, Errorf(http.StatusForbidden, "%s", err.Error())
+	}
+
+	if _, _, err := server.compiler.GetApp(input.AppId); err != nil {
+		return nil, Errorf(http.StatusInternalServerError, "%s", err.Error())
+	}
+
+	targetAppId := input.TargetAppId
+	if targetAppId == "" {
+		targetAppId = input.AppId
+	}
+
+	targetApp, _, err := server.compiler.GetApp(targetAppId)
+	if err != nil {
+		return nil, Errorf(http.StatusInternalServerError, "%s", err.Error())
+	}
+
+	// The target's daemon is the one that answers requests, so make sure it's running
+	if !targetApp.IsAlive() {
+		if err := targetApp.StartServer(); err != nil {
+			return nil, Errorf(http.StatusInternalServerError, "failed to start app '%s': %s", targetApp.Id, err.Error())
+		}
+	}
+
+	timeout := time.Duration(input.TimeoutMs) * time.Millisecond
+	if timeout <= 0 {
+		timeout = defaultAppRequestTimeout
+	} else if timeout > maxAppRequestTimeout {
+		return nil, Errorf(http.StatusBadRequest, "request timeout can be at most %s, got %s", maxAppRequestTimeout, timeout)
+	}
+
+	topicId := targetApp.TopicId(input.Category, input.Key)
+	if targetApp.Id != input.AppId {
+		topic := targetApp.GetTopic(topicId)
+		if topic == nil {
+			return nil, Errorf(http.StatusNotFound, "%s: %s", pubsub.ErrTopicDoesntExist.Error(), topicId.String())
+		}
+
+		if err := topic.CheckAccess(input.AppId, pubsub.PermissionPublish); err != nil {
+			return nil, Errorf(http.StatusForbidden, "%s", err.Error())
+		}
+	}
+
+	reply, err := pubsub.Request(ctx, &pubsub.Topics, topicId, input.Data, timeout)
+	if err != nil {
+		return nil, requestHttpError(err)
+	}
+
+	return reply, nil
+}
+
+func requestHttpError(err error) *HttpError {
+	switch {
+	case errors.Is(err, pubsub.ErrSchemaMismatch):
+		return Errorf(http.StatusBadRequest, "%s", err.Error())
+	case errors.Is(err, pubsub.ErrTopicDoesntExist), errors.Is(err, pubsub.ErrTopicClosed):
+		return Errorf(http.StatusNotFound, "%s", err.Error())
+	case errors.Is(err, pubsub.ErrRequestTimeout):
+		return Errorf(http.StatusGatewayTimeout, "%s", err.Error())
+	case errors.Is(err, pubsub.ErrRequestFailed):
+		return Errorf(http.StatusBadGateway, "%s", err.Error())
+	default:
+		return Errorf(http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+var SendAppRequest = AppsRpcMethod[SendAppRequestInput, any]{
+	Name: "SendAppRequest",
+	Run: func(req RpcRequest[SendAppRequestInput]) (any, *HttpError) {
+		return sendAppRequest(req.Request.Context(), req.Server, req.Server.callerAppId(req.Request), req.Data)
+	},
+}
+
+// This is the same as the `SendAppRequest` RPC, but the request can be cancelled by
+// closing the stream. The stream sends exactly one message, which is the reply.
+var SendAppRequestStream = Stream[SendAppRequestInput, any]{
+	Name: "SendAppRequest",
+	Run: func(req *StreamRequest[SendAppRequestInput, any]) error {
+		input, err := req.ParseInput()
+		if err != nil {
+			return err
+		}
+
+		reply, httpErr := sendAppRequest(req.Context, req.Server, req.AppId, input)
+		if httpErr != nil {
+			return errors.New(httpErr.Message)
+		}
+
+		req.Send(reply)
+		return nil
+	},
+}
+
+type ReplyToAppRequestInput struct {
+	AppId         string         `json:"appId"`
+	ReplyTo       pubsub.TopicId `json:"replyTo"`
+	CorrelationId string         `json:"correlationId"`
+	Data          any            `json:"data"`
+	Error         string         `json:"error"`
+}
+
+var ReplyToAppRequest = AppsRpcMethod[ReplyToAppRequestInput, struct{}]{
+	Name: "ReplyToAppRequest",
+	Run: func(req RpcRequest[ReplyToAppRequestInput]) (struct{}, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return struct{}{}, err
+		}
+
+		if _, _, err := req.Server.compiler.GetApp(req.Data.AppId); err != nil {
+			return struct{}{}, Errorf(http.StatusInternalServerError, "%s", err.Error())
+		}
+
+		err := pubsub.SendReply(&pubsub.Topics, req.Data.ReplyTo, pubsub.Reply{
+			CorrelationId: req.Data.CorrelationId,
+			Data:          req.Data.Data,
+			Error:         req.Data.Error,
+		})
+		if errors.Is(err, pubsub.ErrTopicDoesntExist) || errors.Is(err, pubsub.ErrTopicClosed) {
+			return struct{}{}, Errorf(http.StatusGone, "requester is no longer waiting for a reply: %s", err.Error())
+		} else if err != nil {
+			return struct{}{}, Errorf(http.StatusBadRequest, "%s", err.Error())
+		}
+
+		return struct{}{}, nil
+	},
+}
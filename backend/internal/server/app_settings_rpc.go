@@ -14,6 +14,10 @@ type GetAppSettingsByIdInput struct {
 var GetAppSettingsById = AppsRpcMethod[GetAppSettingsByIdInput, map[string]any]{
 	Name: "GetAppSettingsById",
 	Run: func(req RpcRequest[GetAppSettingsByIdInput]) (map[string]any, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return nil, err
+		}
+
 		app, err := project.LoadRobinAppById(req.Data.AppId)
 		if err != nil {
 			return nil, &HttpError{
@@ -45,6 +49,10 @@ type UpdateAppSettingsInput struct {
 var UpdateAppSettings = AppsRpcMethod[UpdateAppSettingsInput, struct{}]{
 	Name: "UpdateAppSettings",
 	Run: func(req RpcRequest[UpdateAppSettingsInput]) (struct{}, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return struct{}{}, err
+		}
+
 		app, err := project.LoadRobinAppById(req.Data.AppId)
 		if err != nil {
 			return struct{}{}, &HttpError{
@@ -48,9 +48,13 @@ type RunAppMethodInput struct {
 	Data       map[string]any `json:"data"`
 }
 
-var RunAppMethod = InternalRpcMethod[RunAppMethodInput, any]{
+var RunAppMethod = AppsRpcMethod[RunAppMethodInput, any]{
 	Name: "RunAppMethod",
 	Run: func(req RpcRequest[RunAppMethodInput]) (any, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return nil, err
+		}
+
 		appConfig, err := project.LoadRobinAppById(req.Data.AppId)
 		if err != nil {
 			return nil, &HttpError{
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"robinplatform.dev/internal/compilerServer"
+	"robinplatform.dev/internal/pubsub"
+)
+
+const (
+	// Apps send the token robin gave them in this header, or in the `appToken` query
+	// parameter for websockets and event streams, since browsers can't set headers on those.
+	appTokenHeader = "X-Robin-App-Token"
+
+	// Robin's own UI is identified by this cookie, which is set whenever its pages are served
+	robinTokenCookie = "robin-token"
+
+	// App pages are served with this policy, so that they run in an opaque origin instead of
+	// robin's. That keeps them from reading robin's UI, or the pages and cookies of other apps.
+	appPageSandbox = "sandbox allow-scripts allow-forms allow-popups allow-modals allow-downloads"
+)
+
+// Returns the ID of the app that sent a request, or an empty string if it came from robin's
+// own UI or CLI. The app ID is never taken from the request itself, since any caller could claim
+// to be any app. Callers that can't be identified get `pubsub.AnonymousAppId`.
+func (server *Server) callerAppId(req *http.Request) string {
+	token := req.Header.Get(appTokenHeader)
+	if token == "" {
+		token = req.URL.Query().Get("appToken")
+	}
+	if token != "" {
+		if appId, ok := compilerServer.AppIdFromToken(token); ok {
+			return appId
+		}
+		return pubsub.AnonymousAppId
+	}
+
+	// Browsers attach cookies to requests from other sites too, so robin's cookie only
+	// counts when the browser vouches that the request came from robin's own pages.
+	if !isSameOriginRequest(req) {
+		return pubsub.AnonymousAppId
+	}
+
+	if cookie, err := req.Cookie(robinTokenCookie); err == nil {
+		if appId, ok := compilerServer.AppIdFromToken(cookie.Value); ok && appId == "" {
+			return ""
+		}
+	}
+
+	return pubsub.AnonymousAppId
+}
+
+// Checks the headers that browsers set on their own, which pages can't change. Requests
+// that have neither of them are never treated as same-origin.
+func isSameOriginRequest(req *http.Request) bool {
+	if site := req.Header.Get("Sec-Fetch-Site"); site != "" {
+		return site == "same-origin"
+	}
+
+	origin, err := url.Parse(req.Header.Get("Origin"))
+	return err == nil && origin.Host != "" && origin.Host == req.Host
+}
+
+// Apps can only act as themselves, while robin's own UI can act as any app
+func checkActingApp(callerAppId string, appId string) error {
+	if callerAppId != "" && callerAppId != appId {
+		return fmt.Errorf("%w: caller '%s' can't act as app '%s'", pubsub.ErrAccessDenied, callerAppId, appId)
+	}
+
+	return nil
+}
+
+func (server *Server) checkActingApp(req *http.Request, appId string) *HttpError {
+	if err := checkActingApp(server.callerAppId(req), appId); err != nil {
+		return Errorf(http.StatusForbidden, "%s", err.Error())
+	}
+
+	return nil
+}
+
+// Internal methods and routes are only for robin's own UI and CLI
+func (server *Server) checkRobinCaller(req *http.Request) *HttpError {
+	if callerAppId := server.callerAppId(req); callerAppId != "" {
+		return Errorf(http.StatusForbidden, "%s: caller '%s' can't use robin's internal methods", pubsub.ErrAccessDenied.Error(), callerAppId)
+	}
+
+	return nil
+}
+
+func setRobinTokenCookie(res http.ResponseWriter) {
+	http.SetCookie(res, &http.Cookie{
+		Name:     robinTokenCookie,
+		Value:    compilerServer.AppToken(""),
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// App pages run in an opaque origin, so their requests to robin's API are cross-origin.
+// They're only allowed without credentials, since apps authenticate with their token.
+// Returns true if the request was a preflight, which doesn't need any further handling.
+func allowAppPageRequest(res http.ResponseWriter, req *http.Request) bool {
+	if req.Header.Get("Origin") != "null" {
+		return false
+	}
+
+	res.Header().Set("Access-Control-Allow-Origin", "null")
+	res.Header().Set("Access-Control-Allow-Headers", "Content-Type, "+appTokenHeader)
+	res.Header().Set("Access-Control-Allow-Methods", "GET, POST")
+
+	if req.Method == http.MethodOptions {
+		res.WriteHeader(http.StatusNoContent)
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,136 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"robinplatform.dev/internal/compilerServer"
+	"robinplatform.dev/internal/pubsub"
+)
+
+func TestCallerAppId(t *testing.T) {
+	server := &Server{}
+	robinCookie := &http.Cookie{Name: robinTokenCookie, Value: compilerServer.AppToken("")}
+
+	testCases := []struct {
+		name     string
+		target   string
+		headers  map[string]string
+		cookie   *http.Cookie
+		expected string
+	}{
+		{
+			name:     "robin cookie without a referer or origin",
+			cookie:   robinCookie,
+			expected: pubsub.AnonymousAppId,
+		},
+		{
+			name:     "robin cookie from a sandboxed app page",
+			headers:  map[string]string{"Origin": "null"},
+			cookie:   robinCookie,
+			expected: pubsub.AnonymousAppId,
+		},
+		{
+			name:     "robin cookie from another site",
+			headers:  map[string]string{"Sec-Fetch-Site": "same-site", "Origin": "http://localhost:9010"},
+			cookie:   robinCookie,
+			expected: pubsub.AnonymousAppId,
+		},
+		{
+			name:     "robin cookie from robin's origin",
+			headers:  map[string]string{"Origin": "http://localhost:9010"},
+			cookie:   robinCookie,
+			expected: "",
+		},
+		{
+			name:     "robin cookie from a same-origin fetch",
+			headers:  map[string]string{"Sec-Fetch-Site": "same-origin"},
+			cookie:   robinCookie,
+			expected: "",
+		},
+		{
+			name:     "forged robin cookie",
+			headers:  map[string]string{"Sec-Fetch-Site": "same-origin"},
+			cookie:   &http.Cookie{Name: robinTokenCookie, Value: ".forged"},
+			expected: pubsub.AnonymousAppId,
+		},
+		{
+			name:     "app token header",
+			headers:  map[string]string{appTokenHeader: compilerServer.AppToken("app")},
+			expected: "app",
+		},
+		{
+			name:     "app token query",
+			target:   "/api/websocket?appToken=" + compilerServer.AppToken("app"),
+			expected: "app",
+		},
+		{
+			name:     "app token with robin's cookie",
+			headers:  map[string]string{appTokenHeader: compilerServer.AppToken("app"), "Sec-Fetch-Site": "same-origin"},
+			cookie:   robinCookie,
+			expected: "app",
+		},
+		{
+			name:     "forged app token",
+			headers:  map[string]string{appTokenHeader: "other." + compilerServer.AppToken("app")[len("app."):]},
+			expected: pubsub.AnonymousAppId,
+		},
+	}
+
+	for _, testCase := range testCases {
+		target := testCase.target
+		if target == "" {
+			target = "/api/internal/rpc/GetConfig"
+		}
+
+		req := httptest.NewRequest("POST", "http://localhost:9010"+target, nil)
+		for name, value := range testCase.headers {
+			req.Header.Set(name, value)
+		}
+		if testCase.cookie != nil {
+			req.AddCookie(testCase.cookie)
+		}
+
+		if appId := server.callerAppId(req); appId != testCase.expected {
+			t.Errorf("%s: expected caller '%s', got '%s'", testCase.name, testCase.expected, appId)
+		}
+	}
+}
+
+func TestInternalMethodsRefuseApps(t *testing.T) {
+	server := &Server{router: httprouter.New()}
+	server.loadRpcMethods()
+	server.router.GET("/api/internal/export-logs", server.exportProcessLogs)
+
+	appToken := compilerServer.AppToken("app")
+	requests := []*http.Request{
+		httptest.NewRequest("POST", "/api/internal/rpc/KillProcess", strings.NewReader(`{"processId":{"category":"/app/other","key":"proc"}}`)),
+		httptest.NewRequest("POST", "/api/internal/rpc/RestartProcess", strings.NewReader(`{"processId":{"category":"/app/other","key":"proc"}}`)),
+		httptest.NewRequest("POST", "/api/internal/rpc/RemoveProcess", strings.NewReader(`{"processId":{"category":"/app/other","key":"proc"}}`)),
+		httptest.NewRequest("POST", "/api/internal/rpc/ListProcesses", strings.NewReader(`{}`)),
+		httptest.NewRequest("POST", "/api/internal/rpc/GetVersion", nil),
+		httptest.NewRequest("GET", "/api/internal/export-logs", nil),
+	}
+
+	for _, req := range requests {
+		req.Header.Set(appTokenHeader, appToken)
+
+		res := httptest.NewRecorder()
+		server.router.ServeHTTP(res, req)
+		if res.Code != http.StatusForbidden {
+			t.Errorf("%s: expected status %d for an app, got %d: %s", req.URL.Path, http.StatusForbidden, res.Code, res.Body.String())
+		}
+	}
+
+	req := httptest.NewRequest("POST", "/api/internal/rpc/GetVersion", nil)
+	req.Header.Set(appTokenHeader, compilerServer.AppToken(""))
+
+	res := httptest.NewRecorder()
+	server.router.ServeHTTP(res, req)
+	if res.Code != http.StatusOK {
+		t.Errorf("expected status %d for robin, got %d: %s", http.StatusOK, res.Code, res.Body.String())
+	}
+}
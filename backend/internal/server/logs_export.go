@@ -0,0 +1,64 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"robinplatform.dev/internal/identity"
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/process"
+)
+
+// Downloads the logs of the selected processes as an archive. The query parameters are:
+//   - process: the ID of a process to include, in the `{category}#{key}` format (repeatable)
+//   - category: a category of processes to include, along with its sub-categories (repeatable)
+//   - format: either `tar.gz` (the default) or `zip`
+//
+// If no processes or categories are given, the logs of every process are included.
+func (server *Server) exportProcessLogs(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	if err := server.checkRobinCaller(req); err != nil {
+		http.Error(res, err.Message, err.StatusCode)
+		return
+	}
+
+	query := req.URL.Query()
+
+	format, err := process.ParseLogArchiveFormat(query.Get("format"))
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	opts := process.LogArchiveOptions{
+		Categories: query["category"],
+		Format:     format,
+	}
+
+	for _, rawId := range query["process"] {
+		id, err := identity.Parse(rawId)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		opts.ProcessIds = append(opts.ProcessIds, process.ProcessId(id))
+	}
+
+	filename := fmt.Sprintf("robin-logs-%s.%s", time.Now().Format("20060102-150405"), format)
+	if format == process.LogArchiveZip {
+		res.Header().Set("Content-Type", "application/zip")
+	} else {
+		res.Header().Set("Content-Type", "application/gzip")
+	}
+	res.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// The archive is streamed, so once we've started writing it, there's no way to report
+	// errors to the client other than cutting the download short.
+	if err := process.Manager.WriteLogArchive(res, opts); err != nil {
+		logger.Err("Failed to export process logs", log.Ctx{
+			"err": err.Error(),
+		})
+	}
+}
@@ -1,20 +1,51 @@
 package server
 
 import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
 	"robinplatform.dev/internal/process"
 	"robinplatform.dev/internal/pubsub"
 )
 
-func PipeTopic[T any](topicId pubsub.TopicId, req *StreamRequest[T, any]) error {
-	sub, err := pubsub.SubscribeAny(&pubsub.Topics, topicId)
+// Websocket clients can be arbitrarily slow, so unless they ask otherwise, streams drop their
+// oldest undelivered messages instead of making publishers wait.
+var defaultStreamSubscribeOptions = pubsub.SubscribeOptions{
+	Policy:     pubsub.BackpressureDropOldest,
+	BufferSize: 256,
+}
+
+func streamSubscribeOptions(opts pubsub.SubscribeOptions) pubsub.SubscribeOptions {
+	if opts.Policy == "" {
+		opts.Policy = defaultStreamSubscribeOptions.Policy
+	}
+
+	if opts.BufferSize == 0 {
+		opts.BufferSize = defaultStreamSubscribeOptions.BufferSize
+	}
+
+	return opts
+}
+
+func PipeTopic[T any](topicId pubsub.TopicId, opts pubsub.SubscribeOptions, refine pubsub.RefineOptions, req *StreamRequest[T, any]) error {
+	sub, err := pubsub.SubscribeAny(&pubsub.Topics, topicId, streamSubscribeOptions(opts))
 	if err != nil {
 		return err
 	}
 	defer sub.Unsubscribe()
 
+	messages, err := pubsub.Refine(req.Context, sub.Out, refine)
+	if err != nil {
+		return err
+	}
+
 	for {
 		select {
-		case s, ok := <-sub.Out:
+		case s, ok := <-messages:
 			if !ok {
 				// Channel is closed
 				return nil
@@ -44,3 +75,183 @@ var GetProcessLogs = InternalRpcMethod[GetProcessLogsInput, process.LogFileResul
 		return result, nil
 	},
 }
+
+type GetCrashReportsInput struct {
+	// If the process ID is empty, the crash reports of all processes are returned
+	ProcessId process.ProcessId `json:"processId"`
+}
+
+var GetCrashReports = InternalRpcMethod[GetCrashReportsInput, []process.CrashReport]{
+	Name: "GetCrashReports",
+	Run: func(req RpcRequest[GetCrashReportsInput]) ([]process.CrashReport, *HttpError) {
+		return process.Manager.GetCrashReports(req.Data.ProcessId), nil
+	},
+}
+
+func processHttpError(err error) *HttpError {
+	switch {
+	case errors.Is(err, process.ErrProcessNotFound):
+		return Errorf(http.StatusNotFound, "%s", err.Error())
+	case errors.Is(err, process.ErrProcessAlreadyExists):
+		return Errorf(http.StatusConflict, "%s", err.Error())
+	default:
+		return Errorf(http.StatusInternalServerError, "%s", err.Error())
+	}
+}
+
+func validateProcessId(id process.ProcessId) *HttpError {
+	if id.Key == "" {
+		return Errorf(http.StatusBadRequest, "process id is missing a key")
+	}
+
+	if !strings.HasPrefix(id.Category, "/") || path.Clean(id.Category) != id.Category {
+		return Errorf(http.StatusBadRequest, "process category must be a clean path beginning with '/', got '%s'", id.Category)
+	}
+
+	return nil
+}
+
+func validateProcessConfig(config process.ProcessConfig) *HttpError {
+	if err := validateProcessId(config.Id); err != nil {
+		return err
+	}
+
+	// App daemons are managed through the app RPCs, since they need to be compiled
+	// and configured before they can be started
+	if config.Id.Category == "/app" || strings.HasPrefix(config.Id.Category, "/app/") {
+		return Errorf(http.StatusBadRequest, "processes in the '/app' category are managed by apps")
+	}
+
+	if config.Command == "" {
+		return Errorf(http.StatusBadRequest, "process is missing a command")
+	}
+
+	if config.WorkDir != "" && !filepath.IsAbs(config.WorkDir) {
+		return Errorf(http.StatusBadRequest, "process working directory must be an absolute path, got '%s'", config.WorkDir)
+	}
+
+	if config.Port < 0 || config.Port > 65535 {
+		return Errorf(http.StatusBadRequest, "invalid port number: %d", config.Port)
+	}
+
+	return nil
+}
+
+var SpawnProcess = InternalRpcMethod[process.ProcessConfig, process.Process]{
+	Name: "SpawnProcess",
+	Run: func(req RpcRequest[process.ProcessConfig]) (process.Process, *HttpError) {
+		if err := validateProcessConfig(req.Data); err != nil {
+			return process.Process{}, err
+		}
+
+		proc, err := process.Manager.SpawnFromPathVar(req.Data)
+		if err != nil {
+			return process.Process{}, processHttpError(err)
+		}
+
+		return proc, nil
+	},
+}
+
+type ProcessIdInput struct {
+	ProcessId process.ProcessId `json:"processId"`
+}
+
+var KillProcess = InternalRpcMethod[ProcessIdInput, struct{}]{
+	Name: "KillProcess",
+	Run: func(req RpcRequest[ProcessIdInput]) (struct{}, *HttpError) {
+		if err := validateProcessId(req.Data.ProcessId); err != nil {
+			return struct{}{}, err
+		}
+
+		if err := process.Manager.Kill(req.Data.ProcessId); err != nil {
+			return struct{}{}, processHttpError(err)
+		}
+
+		return struct{}{}, nil
+	},
+}
+
+var RestartProcess = InternalRpcMethod[ProcessIdInput, process.Process]{
+	Name: "RestartProcess",
+	Run: func(req RpcRequest[ProcessIdInput]) (process.Process, *HttpError) {
+		if err := validateProcessId(req.Data.ProcessId); err != nil {
+			return process.Process{}, err
+		}
+
+		proc, err := process.Manager.Restart(req.Data.ProcessId)
+		if err != nil {
+			return process.Process{}, processHttpError(err)
+		}
+
+		return proc, nil
+	},
+}
+
+var RemoveProcess = InternalRpcMethod[ProcessIdInput, struct{}]{
+	Name: "RemoveProcess",
+	Run: func(req RpcRequest[ProcessIdInput]) (struct{}, *HttpError) {
+		if err := validateProcessId(req.Data.ProcessId); err != nil {
+			return struct{}{}, err
+		}
+
+		if err := process.Manager.Remove(req.Data.ProcessId); err != nil {
+			return struct{}{}, processHttpError(err)
+		}
+
+		return struct{}{}, nil
+	},
+}
+
+type RunProcessInput struct {
+	Config process.ProcessConfig `json:"config"`
+	// Kill the process if the stream gets canceled before the process exits
+	KillOnCancel bool `json:"killOnCancel"`
+}
+
+// Spawns a process, and streams its output line-by-line until it exits. Like the other
+// process methods, this is only for robin's own UI, since apps use `SpawnAppProcess`.
+var RunProcess = Stream[RunProcessInput, string]{
+	Name: "RunProcess",
+	Run: func(req *StreamRequest[RunProcessInput, string]) error {
+		if req.AppId != "" {
+			return fmt.Errorf("%w: caller '%s' can't run arbitrary processes", pubsub.ErrAccessDenied, req.AppId)
+		}
+
+		input, err := req.ParseInput()
+		if err != nil {
+			return err
+		}
+
+		if err := validateProcessConfig(input.Config); err != nil {
+			return errors.New(err.Message)
+		}
+
+		_, sub, err := process.Manager.SpawnWithLogs(input.Config, streamSubscribeOptions(pubsub.SubscribeOptions{}))
+		if err != nil {
+			return err
+		}
+		defer sub.Unsubscribe()
+
+		for {
+			select {
+			case s, ok := <-sub.Out:
+				if !ok {
+					// The logs topic is closed once the process exits
+					return nil
+				}
+
+				req.Send(s.Data)
+
+			case <-req.Context.Done():
+				if input.KillOnCancel {
+					if err := process.Manager.Kill(input.Config.Id); err != nil && !errors.Is(err, process.ErrProcessNotFound) {
+						return err
+					}
+				}
+
+				return nil
+			}
+		}
+	},
+}
@@ -1,6 +1,11 @@
 package server
 
 import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"robinplatform.dev/internal/compilerServer"
 	"robinplatform.dev/internal/pubsub"
 )
 
@@ -8,18 +13,26 @@ type CreateTopicInput struct {
 	AppId    string   `json:"appId"`
 	Category []string `json:"category"`
 	Key      string   `json:"key"`
+	pubsub.TopicOptions
 }
 
 var CreateTopic = AppsRpcMethod[CreateTopicInput, struct{}]{
 	Name: "CreateTopic",
 	Run: func(req RpcRequest[CreateTopicInput]) (struct{}, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return struct{}{}, err
+		}
+
 		app, _, err := req.Server.compiler.GetApp(req.Data.AppId)
 		if err != nil {
 			return struct{}{}, Errorf(500, "%s", err.Error())
 		}
 
 		topicId := app.TopicId(req.Data.Category, req.Data.Key)
-		if _, err := app.UpsertTopic(topicId); err != nil {
+		if _, err := app.UpsertTopic(topicId, req.Data.TopicOptions); err != nil {
+			if errors.Is(err, pubsub.ErrInvalidTopicOptions) {
+				return struct{}{}, Errorf(400, "%s", err.Error())
+			}
 			return struct{}{}, Errorf(500, "%s", err.Error())
 		}
 
@@ -28,45 +41,94 @@ var CreateTopic = AppsRpcMethod[CreateTopicInput, struct{}]{
 }
 
 type PublishTopicInput struct {
-	AppId    string   `json:"appId"`
-	Category []string `json:"category"`
-	Key      string   `json:"key"`
-	Data     any      `json:"data"`
+	AppId string `json:"appId"`
+	// TargetAppId is the app whose topic gets the message. It defaults to `AppId`, and
+	// publishing to another app's topic requires the other app to grant access to it.
+	TargetAppId string   `json:"targetAppId"`
+	Category    []string `json:"category"`
+	Key         string   `json:"key"`
+	// Data is kept encoded until it's published, so that its size can be measured
+	Data json.RawMessage `json:"data"`
 }
 
 var PublishTopic = AppsRpcMethod[PublishTopicInput, struct{}]{
 	Name: "PublishToTopic",
 	Run: func(req RpcRequest[PublishTopicInput]) (struct{}, *HttpError) {
+		if err := req.Server.checkActingApp(req.Request, req.Data.AppId); err != nil {
+			return struct{}{}, err
+		}
+
 		app, _, err := req.Server.compiler.GetApp(req.Data.AppId)
 		if err != nil {
 			return struct{}{}, Errorf(500, "%s", err.Error())
 		}
 
-		topicId := app.TopicId(req.Data.Category, req.Data.Key)
-		topic, err := app.UpsertTopic(topicId)
-		if err != nil {
-			return struct{}{}, Errorf(500, "topic '%s' not found: %s", topicId.String(), err.Error())
+		var topic *pubsub.Topic[any]
+		if req.Data.TargetAppId == "" || req.Data.TargetAppId == app.Id {
+			topicId := app.TopicId(req.Data.Category, req.Data.Key)
+			topic, err = app.ReopenTopic(topicId)
+			if err != nil {
+				return struct{}{}, Errorf(500, "topic '%s' not found: %s", topicId.String(), err.Error())
+			}
+		} else {
+			targetApp, _, err := req.Server.compiler.GetApp(req.Data.TargetAppId)
+			if err != nil {
+				return struct{}{}, Errorf(500, "%s", err.Error())
+			}
+
+			// Apps can only create their own topics
+			topicId := targetApp.TopicId(req.Data.Category, req.Data.Key)
+			topic = targetApp.GetTopic(topicId)
+			if topic == nil {
+				return struct{}{}, Errorf(404, "topic '%s' not found", topicId.String())
+			}
+
+			if err := topic.CheckAccess(app.Id, pubsub.PermissionPublish); err != nil {
+				return struct{}{}, Errorf(403, "%s", err.Error())
+			}
 		}
 
-		topic.Publish(req.Data.Data)
+		var data any
+		if len(req.Data.Data) > 0 {
+			if err := json.Unmarshal(req.Data.Data, &data); err != nil {
+				return struct{}{}, Errorf(400, "invalid message data: %s", err.Error())
+			}
+		}
+
+		if err := topic.ValidateMessage(data); err != nil {
+			return struct{}{}, Errorf(400, "%s", err.Error())
+		}
+
+		topic.PublishWithSize(data, len(req.Data.Data))
 
 		return struct{}{}, nil
 	},
 }
 
-type GetTopicsInput struct {
+// Apps only get the topics they can subscribe to, while robin's UI gets every topic
+var GetTopics = AppsRpcMethod[struct{}, pubsub.RegistryTopicInfo]{
+	Name:             "GetTopics",
+	SkipInputParsing: true,
+	Run: func(req RpcRequest[struct{}]) (pubsub.RegistryTopicInfo, *HttpError) {
+		names := pubsub.Topics.GetTopicInfoForApp(req.Server.callerAppId(req.Request))
+		return names, nil
+	},
 }
 
-var GetTopics = AppsRpcMethod[GetTopicsInput, pubsub.RegistryTopicInfo]{
-	Name: "GetTopics",
-	Run: func(req RpcRequest[GetTopicsInput]) (pubsub.RegistryTopicInfo, *HttpError) {
-		names := pubsub.Topics.GetTopicInfo()
-		return names, nil
+var GetPubsubMetrics = InternalRpcMethod[struct{}, pubsub.PubsubMetrics]{
+	Name:             "GetPubsubMetrics",
+	SkipInputParsing: true,
+	Run: func(_ RpcRequest[struct{}]) (pubsub.PubsubMetrics, *HttpError) {
+		return pubsub.Topics.GetMetrics(), nil
 	},
 }
 
 type SubscribeTopicInput struct {
 	Id pubsub.TopicId `json:"id"`
+	pubsub.SubscribeOptions
+	// Filters and rate limits are applied before messages are sent, so that clients don't
+	// get flooded by busy topics.
+	pubsub.RefineOptions
 }
 
 var SubscribeTopic = Stream[SubscribeTopicInput, any]{
@@ -77,15 +139,21 @@ var SubscribeTopic = Stream[SubscribeTopicInput, any]{
 			return err
 		}
 
-		sub, err := pubsub.SubscribeAny(&pubsub.Topics, input.Id)
+		input.SubscribeOptions.AppId = req.AppId
+		sub, err := pubsub.SubscribeAny(&pubsub.Topics, input.Id, streamSubscribeOptions(input.SubscribeOptions))
 		if err != nil {
 			return err
 		}
 		defer sub.Unsubscribe()
 
+		messages, err := pubsub.Refine(req.Context, sub.Out, input.RefineOptions)
+		if err != nil {
+			return err
+		}
+
 		for {
 			select {
-			case s, ok := <-sub.Out:
+			case s, ok := <-messages:
 				if !ok {
 					// Channel is closed
 					return nil
@@ -104,6 +172,9 @@ type SubscribeAppTopicInput struct {
 	AppId    string   `json:"appId"`
 	Category []string `json:"category"`
 	Key      string   `json:"key"`
+	pubsub.SubscribeOptions
+	// See `SubscribeTopicInput.RefineOptions`
+	pubsub.RefineOptions
 }
 
 var SubscribeAppTopic = Stream[SubscribeAppTopicInput, any]{
@@ -127,6 +198,84 @@ var SubscribeAppTopic = Stream[SubscribeAppTopicInput, any]{
 		}
 
 		topicId := app.TopicId(input.Category, input.Key)
-		return PipeTopic(topicId, req)
+		input.SubscribeOptions.AppId = req.AppId
+		return PipeTopic(topicId, input.SubscribeOptions, input.RefineOptions, req)
+	},
+}
+
+func pipeTopicPattern[T any](pattern pubsub.TopicPattern, opts pubsub.SubscribeOptions, req *StreamRequest[T, any]) error {
+	sub, err := pubsub.SubscribePattern(&pubsub.Topics, pattern, streamSubscribeOptions(opts))
+	if err != nil {
+		return err
+	}
+	defer sub.Unsubscribe()
+
+	for {
+		select {
+		case s := <-sub.Out:
+			req.Send(s)
+
+		case <-req.Context.Done():
+			return nil
+		}
+	}
+}
+
+type SubscribeTopicPatternInput struct {
+	// A category pattern, e.g. `/logs/app/*`. See `pubsub.TopicPattern` for the syntax.
+	Pattern string `json:"pattern"`
+	pubsub.SubscribeOptions
+}
+
+var SubscribeTopicPattern = Stream[SubscribeTopicPatternInput, any]{
+	Name: "SubscribeTopicPattern",
+	Run: func(req *StreamRequest[SubscribeTopicPatternInput, any]) error {
+		input, err := req.ParseInput()
+		if err != nil {
+			return err
+		}
+
+		pattern, err := pubsub.ParseTopicPattern(input.Pattern)
+		if err != nil {
+			return err
+		}
+
+		input.SubscribeOptions.AppId = req.AppId
+		return pipeTopicPattern(pattern, input.SubscribeOptions, req)
+	},
+}
+
+type SubscribeAppTopicPatternInput struct {
+	AppId string `json:"appId"`
+	// A category pattern relative to the app's topics, e.g. `/builds/*`
+	Pattern string `json:"pattern"`
+	pubsub.SubscribeOptions
+}
+
+var SubscribeAppTopicPattern = Stream[SubscribeAppTopicPatternInput, any]{
+	Name: "SubscribeAppTopicPattern",
+	Run: func(req *StreamRequest[SubscribeAppTopicPatternInput, any]) error {
+		input, err := req.ParseInput()
+		if err != nil {
+			return err
+		}
+
+		app, _, err := req.Server.compiler.GetApp(input.AppId)
+		if err != nil {
+			// the error messages from GetApp() are already user-friendly
+			return err
+		}
+
+		// This doesn't use path.Join, since cleaning the path would let `..` escape the app's
+		// topics. Unclean segments are harmless here, since they're only ever matched literally.
+		appCategory := compilerServer.AppTopicCategory(app.Id)
+		patternStr := pubsub.EscapeCategory(appCategory) + "/" + strings.TrimPrefix(input.Pattern, "/")
+		pattern, err := pubsub.ParseTopicPattern(patternStr)
+		if err != nil {
+			return err
+		}
+
+		input.SubscribeOptions.AppId = req.AppId
+		return pipeTopicPattern(pattern, input.SubscribeOptions, req)
 	},
 }
@@ -0,0 +1,91 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/julienschmidt/httprouter"
+	"robinplatform.dev/internal/compilerServer"
+	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
+)
+
+// The project path can only be set once per process, so every test shares one project
+var testProjectPath string
+
+func TestMain(m *testing.M) {
+	var err error
+	testProjectPath, err = os.MkdirTemp("", "robin-server-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	files := map[string]string{
+		"robin.json":         `{"name": "test", "apps": ["./app/robin.app.json"]}`,
+		"app/robin.app.json": `{"id": "metrics-app", "name": "Metrics app", "page": "page.tsx", "pageIcon": "📈"}`,
+	}
+	for path, contents := range files {
+		path = filepath.Join(testProjectPath, filepath.FromSlash(path))
+		if err == nil {
+			err = os.MkdirAll(filepath.Dir(path), 0755)
+		}
+		if err == nil {
+			err = os.WriteFile(path, []byte(contents), 0644)
+		}
+	}
+	if err == nil {
+		_, err = project.SetProjectPath(testProjectPath)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.RemoveAll(testProjectPath)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	os.RemoveAll(testProjectPath)
+	os.Exit(code)
+}
+
+func TestPublishToTopicRecordsBytes(t *testing.T) {
+	server := &Server{router: httprouter.New()}
+	server.loadRpcMethods()
+
+	call := func(method string, body string) {
+		t.Helper()
+
+		req := httptest.NewRequest("POST", "/api/apps/rpc/"+method, strings.NewReader(body))
+		req.Header.Set(appTokenHeader, compilerServer.AppToken("metrics-app"))
+
+		res := httptest.NewRecorder()
+		server.router.ServeHTTP(res, req)
+		if res.Code != http.StatusOK {
+			t.Fatalf("%s: expected status %d, got %d: %s", method, http.StatusOK, res.Code, res.Body.String())
+		}
+	}
+
+	data := `{"status": "ok"}`
+	call("CreateTopic", `{"appId": "metrics-app", "category": ["events"], "key": "status"}`)
+	call("PublishToTopic", `{"appId": "metrics-app", "category": ["events"], "key": "status", "data": `+data+`}`)
+
+	app, _, err := server.compiler.GetApp("metrics-app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	topicId := app.TopicId([]string{"events"}, "status")
+	defer app.GetTopic(topicId).Close()
+
+	info, ok := pubsub.Topics.GetTopicInfo().Info[topicId.String()]
+	if !ok {
+		t.Fatalf("expected topic %s to exist", topicId.String())
+	}
+	if info.Published != 1 || info.Bytes != int64(len(data)) {
+		t.Fatalf("expected 1 message of %d bytes, got %d messages of %d bytes", len(data), info.Published, info.Bytes)
+	}
+}
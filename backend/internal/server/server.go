@@ -1,20 +1,30 @@
 package server
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"robinplatform.dev/internal/bridge"
 	"robinplatform.dev/internal/compilerServer"
+	"robinplatform.dev/internal/config"
 	"robinplatform.dev/internal/log"
 	"robinplatform.dev/internal/project"
+	"robinplatform.dev/internal/pubsub"
 )
 
+// The port that robin listens on unless it's told otherwise, which the CLI also
+// uses to find a running server
+const DefaultPort = 9010
+
 type Server struct {
 	BindAddress string
 	Port        int
@@ -24,10 +34,14 @@ type Server struct {
 	pprofRouter http.Handler
 	webRouter   http.Handler
 	compiler    compilerServer.Compiler
+	bridge      *bridge.Bridge
 }
 
 var logger log.Logger = log.New("server")
 
+// How often a summary of pubsub's metrics is published to `pubsub.MetricsTopic`
+const pubsubMetricsInterval = 5 * time.Second
+
 type RouterGroup struct {
 	router *httprouter.Router
 	prefix string
@@ -39,8 +53,20 @@ func (routerGroup *RouterGroup) Handle(method, path string, handler httprouter.H
 
 type InternalRpcMethod[Input any, Output any] RpcMethod[Input, Output]
 
+// Internal RPC methods can only be called by robin's own UI and CLI, never by apps
 func (method *InternalRpcMethod[Input, Output]) Register(server *Server) {
-	(*RpcMethod[Input, Output])(method).Register(server, RouterGroup{
+	run := method.Run
+	internalMethod := RpcMethod[Input, Output](*method)
+	internalMethod.Run = func(req RpcRequest[Input]) (Output, *HttpError) {
+		if err := req.Server.checkRobinCaller(req.Request); err != nil {
+			var output Output
+			return output, err
+		}
+
+		return run(req)
+	}
+
+	internalMethod.Register(server, RouterGroup{
 		router: server.router,
 		prefix: "/api/internal/rpc",
 	})
@@ -63,20 +89,32 @@ func (server *Server) loadRpcMethods() {
 	UpdateConfig.Register(server)
 
 	GetProcessLogs.Register(server)
+	GetCrashReports.Register(server)
+	SpawnProcess.Register(server)
+	KillProcess.Register(server)
+	RestartProcess.Register(server)
+	RemoveProcess.Register(server)
+	RemoveAppData.Register(server)
 
 	GetAppById.Register(server)
 	GetApps.Register(server)
-	RunAppMethod.Register(server)
 	RestartApp.Register(server)
 	ListProcesses.Register(server)
+	GetPubsubMetrics.Register(server)
 
 	// Apps RPC methods
 
 	GetAppSettingsById.Register(server)
 	UpdateAppSettings.Register(server)
+	RunAppMethod.Register(server)
 	GetTopics.Register(server)
 	CreateTopic.Register(server)
 	PublishTopic.Register(server)
+	SpawnAppProcess.Register(server)
+	ListAppProcesses.Register(server)
+	KillAppProcess.Register(server)
+	SendAppRequest.Register(server)
+	ReplyToAppRequest.Register(server)
 
 	// Streaming methods
 
@@ -85,6 +123,11 @@ func (server *Server) loadRpcMethods() {
 
 	SubscribeTopic.Register(wsHandler)
 	SubscribeAppTopic.Register(wsHandler)
+	SubscribeTopicPattern.Register(wsHandler)
+	SubscribeAppTopicPattern.Register(wsHandler)
+	SendAppRequestStream.Register(wsHandler)
+	RunProcess.Register(wsHandler)
+	SubscribeAppProcessLogs.Register(wsHandler)
 }
 
 func createErrorJs(errMessage string) string {
@@ -100,8 +143,12 @@ func (server *Server) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	if server.EnablePprof && strings.HasPrefix(req.URL.Path, "/debug/pprof/") {
 		server.pprofRouter.ServeHTTP(res, req)
 	} else if strings.HasPrefix(req.URL.Path, "/api") {
+		if allowAppPageRequest(res, req) {
+			return
+		}
 		server.router.ServeHTTP(res, req)
 	} else {
+		setRobinTokenCookie(res)
 		server.webRouter.ServeHTTP(res, req)
 	}
 }
@@ -118,6 +165,18 @@ func (server *Server) Run() error {
 	}
 	server.compiler.ServerPort = server.Port
 
+	topicsPath := filepath.Join(config.GetRobinPath(), "data", "topics")
+	if err := pubsub.Topics.RestoreDurableTopics(topicsPath); err != nil {
+		// Robin still works without durable topics, so this isn't fatal
+		logger.Err("Failed to restore durable topics", log.Ctx{
+			"err": err.Error(),
+		})
+	}
+
+	go pubsub.Topics.PublishMetrics(context.Background(), pubsubMetricsInterval)
+	server.removeOrphanedAppData()
+	server.startBridge()
+
 	if server.EnablePprof {
 		logger.Print("Running with pprof enabled", log.Ctx{})
 		mux := http.NewServeMux()
@@ -134,6 +193,7 @@ func (server *Server) Run() error {
 	server.router.GET("/api/app-resources/:id/base/*filepath", func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 		id := params.ByName("id")
 		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		res.Header().Set("Content-Security-Policy", appPageSandbox)
 
 		if err := server.compiler.RenderClient(id, res); err != nil {
 			res.Header().Set("X-Cache", "MISS")
@@ -167,6 +227,12 @@ func (server *Server) Run() error {
 		res.Write(metafileJson)
 	})
 
+	server.router.GET("/api/internal/export-logs", server.exportProcessLogs)
+	server.router.GET("/api/topics/events", server.streamTopicEvents)
+	if server.bridge != nil {
+		server.router.Handler("GET", "/api/bridge", server.bridge)
+	}
+
 	server.loadRpcMethods()
 	portBinding := fmt.Sprintf("%s:%d", server.BindAddress, server.Port)
 
@@ -184,3 +250,31 @@ func (server *Server) Run() error {
 	httpServer.Serve(listener)
 	return nil
 }
+
+// Starts mirroring topics with the robin instances in the project's bridge config. Other
+// instances can only connect to `/api/bridge` if the project configures a bridge, and
+// they send its secret.
+func (server *Server) startBridge() {
+	projectConfig, err := project.LoadProjectConfig()
+	if err != nil || projectConfig.Bridge == nil {
+		return
+	}
+
+	instance, err := bridge.New(&pubsub.Topics)
+	if err != nil {
+		logger.Err("Failed to create pubsub bridge", log.Ctx{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	// Robin still works without the bridge, so this isn't fatal
+	if err := instance.Start(context.Background(), *projectConfig.Bridge); err != nil {
+		logger.Err("Failed to start pubsub bridge", log.Ctx{
+			"err": err.Error(),
+		})
+		return
+	}
+
+	server.bridge = instance
+}
@@ -28,6 +28,10 @@ type streamRequest struct {
 	// Server is the instance serving the request
 	Server *Server
 
+	// AppId is the app that opened the websocket, which is empty for robin's own UI.
+	// Streams use it to check the app's access to topics. See `Server.callerAppId`.
+	AppId string
+
 	// Initial input to the stream
 	RawInput []byte
 
@@ -116,6 +120,7 @@ type RpcWebsocket struct {
 func (ws *RpcWebsocket) WebsocketHandler(server *Server) httprouter.Handle {
 	return func(res http.ResponseWriter, req *http.Request, params httprouter.Params) {
 		inFlightRequests := make(map[string]*streamRequest)
+		appId := server.callerAppId(req)
 
 		upgrader := websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
@@ -194,6 +199,7 @@ func (ws *RpcWebsocket) WebsocketHandler(server *Server) httprouter.Handle {
 					Method:   input.Method,
 					Id:       input.Id,
 					Server:   server,
+					AppId:    appId,
 					RawInput: input.Data,
 					output:   outputChannel,
 				}
@@ -248,13 +254,30 @@ func runMethod(method handler, rawReq *streamRequest) {
 
 	rawReq.SendRaw("methodStarted", nil)
 
-	if err := method(rawReq); err != nil {
+	if err := runHandler(method, rawReq); err != nil {
 		rawReq.SendRaw("error", err.Error())
 	}
 
 	rawReq.SendRaw("methodDone", nil)
 }
 
+// Streams run in their own goroutine, so a panic in one would take down the whole server.
+// It's reported as an error of the stream instead, like the RPC methods do.
+func runHandler(method handler, rawReq *streamRequest) (err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			logger.Err("RPC stream method panicked", log.Ctx{
+				"method": rawReq.Method,
+				"id":     rawReq.Id,
+				"panic":  fmt.Sprintf("%v", recovered),
+			})
+			err = fmt.Errorf("%v", recovered)
+		}
+	}()
+
+	return method(rawReq)
+}
+
 func (method *Stream[Input, Output]) handler(rawReq *streamRequest) error {
 	req := (*StreamRequest[Input, Output])(rawReq)
 	return method.Run(req)
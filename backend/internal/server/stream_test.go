@@ -0,0 +1,27 @@
+package server
+
+import (
+	"testing"
+)
+
+func TestPanickingStreamReportsError(t *testing.T) {
+	output := make(chan socketMessageOut, 4)
+	req := &streamRequest{Method: "Panics", Id: "1", output: output}
+
+	runMethod(func(req *streamRequest) error {
+		panic("oh no")
+	}, req)
+	close(output)
+
+	kinds := []string{}
+	for message := range output {
+		kinds = append(kinds, message.Kind)
+		if message.Kind == "error" && message.Data != "oh no" {
+			t.Errorf("expected the panic to be reported, got %v", message.Data)
+		}
+	}
+
+	if len(kinds) != 3 || kinds[1] != "error" || kinds[2] != "methodDone" {
+		t.Fatalf("expected the stream to report an error and finish, got %v", kinds)
+	}
+}
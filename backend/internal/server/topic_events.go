@@ -0,0 +1,195 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"robinplatform.dev/internal/log"
+	"robinplatform.dev/internal/pubsub"
+)
+
+// Proxies can close connections that look idle, so the event stream sends a comment
+// whenever it hasn't sent anything for this long.
+const topicEventsKeepAliveInterval = 15 * time.Second
+
+// Parses the subscription options of an event stream from the query string and headers.
+// `Last-Event-ID` is sent by `EventSource` when it reconnects, and takes precedence over
+// the other cursor parameters, so that the stream resumes after the last message it delivered.
+func parseTopicEventsRequest(req *http.Request) (pubsub.TopicId, pubsub.SubscribeOptions, error) {
+	query := req.URL.Query()
+
+	topicId := pubsub.TopicId{
+		Category: query.Get("category"),
+		Key:      query.Get("key"),
+	}
+	if topicId.Category == "" || topicId.Key == "" {
+		return topicId, pubsub.SubscribeOptions{}, fmt.Errorf("'category' and 'key' are required")
+	}
+
+	opts := pubsub.SubscribeOptions{
+		Policy: pubsub.BackpressurePolicy(query.Get("policy")),
+	}
+
+	parseInt := func(name string, value string) (int64, error) {
+		parsed, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("'%s' must be an integer, got '%s'", name, value)
+		}
+		return parsed, nil
+	}
+
+	if value := query.Get("bufferSize"); value != "" {
+		bufferSize, err := parseInt("bufferSize", value)
+		if err != nil {
+			return topicId, opts, err
+		}
+		opts.BufferSize = int(bufferSize)
+	}
+
+	if value := query.Get("fromMessageId"); value != "" {
+		fromMessageId, err := parseInt("fromMessageId", value)
+		if err != nil {
+			return topicId, opts, err
+		}
+		from := int32(fromMessageId)
+		opts.Cursor.FromMessageId = &from
+	}
+
+	if value := query.Get("last"); value != "" {
+		last, err := parseInt("last", value)
+		if err != nil {
+			return topicId, opts, err
+		}
+		opts.Cursor.Last = int(last)
+	}
+
+	if value := req.Header.Get("Last-Event-ID"); value != "" {
+		lastEventId, err := parseInt("Last-Event-ID", value)
+		if err != nil {
+			return topicId, opts, err
+		}
+		from := int32(lastEventId) + 1
+		opts.Cursor = pubsub.Cursor{FromMessageId: &from}
+	}
+
+	return topicId, opts, nil
+}
+
+// Parses the filter and rate limits of an event stream, which are given as JSON in the
+// `filter` and `throttle` query parameters, in the same shape as `pubsub.RefineOptions`.
+func parseTopicEventsRefineOptions(req *http.Request) (pubsub.RefineOptions, error) {
+	query := req.URL.Query()
+	var refine pubsub.RefineOptions
+
+	if value := query.Get("filter"); value != "" {
+		refine.Filter = &pubsub.MessageFilter{}
+		if err := json.Unmarshal([]byte(value), refine.Filter); err != nil {
+			return refine, fmt.Errorf("'filter' must be a JSON message filter: %w", err)
+		}
+	}
+
+	if value := query.Get("throttle"); value != "" {
+		if err := json.Unmarshal([]byte(value), &refine.Throttle); err != nil {
+			return refine, fmt.Errorf("'throttle' must be JSON throttle options: %w", err)
+		}
+	}
+
+	return refine, nil
+}
+
+// Streams a topic's messages as server-sent events, for clients that can't use the websocket.
+// Each event's data is a `pubsub.Message`, and its ID is the message's ID. When the topic is
+// closed, a `close` event is sent and the response ends.
+//
+// Reconnecting clients only get the messages they missed if the topic retains messages.
+func (server *Server) streamTopicEvents(res http.ResponseWriter, req *http.Request, _ httprouter.Params) {
+	topicId, opts, err := parseTopicEventsRequest(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts.AppId = server.callerAppId(req)
+
+	refine, err := parseTopicEventsRefineOptions(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	flusher, ok := res.(http.Flusher)
+	if !ok {
+		http.Error(res, "streaming is not supported by this connection", http.StatusInternalServerError)
+		return
+	}
+
+	sub, err := pubsub.SubscribeAny(&pubsub.Topics, topicId, streamSubscribeOptions(opts))
+	if err != nil {
+		switch {
+		case errors.Is(err, pubsub.ErrTopicDoesntExist), errors.Is(err, pubsub.ErrTopicClosed):
+			http.Error(res, err.Error(), http.StatusNotFound)
+		case errors.Is(err, pubsub.ErrAccessDenied):
+			http.Error(res, err.Error(), http.StatusForbidden)
+		default:
+			http.Error(res, err.Error(), http.StatusBadRequest)
+		}
+		return
+	}
+	defer sub.Unsubscribe()
+
+	messages, err := pubsub.Refine(req.Context(), sub.Out, refine)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	res.Header().Set("Content-Type", "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(topicEventsKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				fmt.Fprint(res, "event: close\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+
+			// Encoded JSON never contains newlines, so it always fits in a single data line
+			data, err := json.Marshal(message)
+			if err != nil {
+				logger.Err("Failed to encode topic message as an event", log.Ctx{
+					"topicId":   topicId.String(),
+					"messageId": message.MessageId,
+					"err":       err.Error(),
+				})
+				continue
+			}
+
+			if _, err := fmt.Fprintf(res, "id: %d\ndata: %s\n\n", message.MessageId, data); err != nil {
+				return
+			}
+			flusher.Flush()
+			keepAlive.Reset(topicEventsKeepAliveInterval)
+
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+
+		case <-req.Context().Done():
+			return
+		}
+	}
+}
@@ -95,20 +95,10 @@ function AppWindowContent({ id, setTitle }: AppWindowProps) {
 		return () => window.removeEventListener('message', onMessage);
 	}, [router, setTitle]);
 
+	// Apps run in a sandboxed origin, so their document can't be read from here. They
+	// send a 'titleUpdate' message with their title once they load instead.
 	React.useEffect(() => {
 		setTitle(id);
-
-		if (!iframeRef.current) return;
-		const iframe = iframeRef.current;
-
-		const listener = () => {
-			if (iframe.contentDocument) {
-				setTitle(iframe.contentDocument.title);
-			}
-		};
-
-		iframe.addEventListener('load', listener);
-		return () => iframe.removeEventListener('load', listener);
 	}, [id, setTitle]);
 
 	return (
@@ -12,13 +12,41 @@ export class Topic<T> {
 	private constructor(
 		private readonly category: string[],
 		private readonly key: string,
+		private readonly targetAppId?: string,
 	) {}
 
+	// Gets a topic owned by another app. Publishing to it only works if the other app
+	// grants this app the `publish` permission in the `topicAccess` of its robin.app.json.
+	public static ofApp<T>(
+		targetAppId: string,
+		category: string[],
+		key: string,
+	): Topic<T> {
+		return new Topic<T>(category, key, targetAppId);
+	}
+
 	// Creates a topic under the specified category and key, as a subcategory of
-	// `/app-topics/{app}/`.
+	// `/app-topics/{app}/`. If `retention` is set, the topic keeps its most recent
+	// messages around, so that new subscribers can ask to replay them. Durable topics
+	// also keep those messages across restarts of robin and of the app, until the app
+	// is removed from the project. If `schema` is set, it's a JSON Schema that every
+	// published message gets validated against. If `idleTtlMs` is set, the topic is
+	// closed once it has gone that long without subscribers or messages. The app's
+	// topics are also closed when its daemon stops.
 	public static async createTopic<T>(
 		category: string[],
 		key: string,
+		{
+			retention,
+			durable,
+			schema,
+			idleTtlMs,
+		}: {
+			retention?: { maxMessages?: number; maxAgeMs?: number };
+			durable?: boolean;
+			schema?: object;
+			idleTtlMs?: number;
+		} = {},
 	): Promise<Topic<T>> {
 		await request({
 			pathname: '/api/apps/rpc/CreateTopic',
@@ -27,6 +55,14 @@ export class Topic<T> {
 				appId: process.env.ROBIN_APP_ID,
 				category,
 				key,
+				retention: retention && {
+					maxMessages: retention.maxMessages,
+					// Durations are sent in nanoseconds
+					maxAge: retention.maxAgeMs && retention.maxAgeMs * 1_000_000,
+				},
+				durable,
+				schema,
+				idleTtl: idleTtlMs && idleTtlMs * 1_000_000,
 			},
 		});
 
@@ -39,6 +75,7 @@ export class Topic<T> {
 			resultType: z.object({}),
 			body: {
 				appId: process.env.ROBIN_APP_ID,
+				targetAppId: this.targetAppId,
 				category: this.category,
 				key: this.key,
 				data: t,
@@ -46,3 +83,123 @@ export class Topic<T> {
 		});
 	}
 }
+
+const AppProcess = z.object({
+	id: z.object({ category: z.string(), key: z.string() }),
+	pid: z.number(),
+	command: z.string(),
+	args: z.array(z.string()),
+});
+
+// Spawns a process that is owned by this app, under the category
+// `/app/{app}/{...category}`. Processes owned by the app get cleaned up
+// when the app is removed.
+export async function spawnProcess({
+	category = [],
+	key,
+	command,
+	args = [],
+	env = {},
+	shell,
+}: {
+	category?: string[];
+	key: string;
+	command: string;
+	args?: string[];
+	env?: Record<string, string>;
+	shell?: { shell?: string; login?: boolean };
+}) {
+	return request({
+		pathname: '/api/apps/rpc/SpawnAppProcess',
+		resultType: AppProcess,
+		body: {
+			appId: process.env.ROBIN_APP_ID,
+			category,
+			key,
+			command,
+			args,
+			env,
+			shell,
+		},
+	});
+}
+
+export async function listProcesses() {
+	return request({
+		pathname: '/api/apps/rpc/ListAppProcesses',
+		resultType: z.array(AppProcess),
+		body: {
+			appId: process.env.ROBIN_APP_ID,
+		},
+	});
+}
+
+export async function killProcess(category: string[], key: string) {
+	await request({
+		pathname: '/api/apps/rpc/KillAppProcess',
+		resultType: z.object({}),
+		body: {
+			appId: process.env.ROBIN_APP_ID,
+			category,
+			key,
+		},
+	});
+}
+
+// Sends a request to a topic owned by `targetAppId` (this app by default), and waits
+// for the app's daemon to reply with `replyToRequest`.
+export async function sendRequest<T>({
+	targetAppId,
+	category = [],
+	key,
+	data,
+	timeoutMs,
+	resultType,
+}: {
+	targetAppId?: string;
+	category?: string[];
+	key: string;
+	data: unknown;
+	timeoutMs?: number;
+	resultType: z.Schema<T>;
+}): Promise<T> {
+	return request({
+		pathname: '/api/apps/rpc/SendAppRequest',
+		resultType,
+		body: {
+			appId: process.env.ROBIN_APP_ID,
+			targetAppId,
+			category,
+			key,
+			data,
+			timeoutMs,
+		},
+	});
+}
+
+// The message that `sendRequest` publishes to the target topic. A message of kind
+// `cancel` means the requester stopped waiting for a reply.
+export const RequestMessage = z.object({
+	kind: z.enum(['request', 'cancel']),
+	correlationId: z.string(),
+	replyTo: z.object({ category: z.string(), key: z.string() }),
+	deadline: z.string(),
+	data: z.unknown().optional(),
+});
+
+export async function replyToRequest(
+	req: z.infer<typeof RequestMessage>,
+	reply: { data?: unknown; error?: string },
+) {
+	await request({
+		pathname: '/api/apps/rpc/ReplyToAppRequest',
+		resultType: z.object({}),
+		body: {
+			appId: process.env.ROBIN_APP_ID,
+			replyTo: req.replyTo,
+			correlationId: req.correlationId,
+			data: reply.data,
+			error: reply.error,
+		},
+	});
+}
@@ -1,5 +1,6 @@
 import fetch from 'isomorphic-fetch';
 import { z } from 'zod';
+import { getAppToken } from './internal/token';
 
 const isBrowser = (function () {
 	try {
@@ -11,6 +12,7 @@ const isBrowser = (function () {
 
 const ROBIN_SERVER_PORT = Number(process.env.ROBIN_SERVER_PORT ?? 9010);
 const ROBIN_APP_ID = process.env.ROBIN_APP_ID;
+const ROBIN_APP_TOKEN = getAppToken();
 
 if (!ROBIN_APP_ID) {
 	throw new Error('ROBIN_APP_ID must be set - was this app compiled by Robin?');
@@ -35,6 +37,7 @@ export async function request<T>({
 		method: 'POST',
 		headers: {
 			'Content-Type': 'application/json',
+			...(ROBIN_APP_TOKEN ? { 'X-Robin-App-Token': ROBIN_APP_TOKEN } : {}),
 			...(overrides?.headers ?? {}),
 		},
 		redirect: 'follow',
@@ -87,7 +90,7 @@ export const runAppMethod = Object.assign(
 		resultType: z.Schema<T>;
 	}) {
 		return request({
-			pathname: '/api/internal/rpc/RunAppMethod',
+			pathname: '/api/apps/rpc/RunAppMethod',
 			resultType,
 			body: {
 				appId: ROBIN_APP_ID,
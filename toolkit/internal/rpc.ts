@@ -1,3 +1,5 @@
+import { getAppToken } from './token';
+
 const rpcBaseUrl = `${window.location.protocol}//${window.location.host}`;
 
 /**
@@ -15,9 +17,11 @@ export function createRpcMethod(
 ) {
 	return Object.assign(
 		async function rpcMethodWrapper(data: unknown) {
-			const url = new URL('/api/internal/rpc/RunAppMethod', rpcBaseUrl);
+			const url = new URL('/api/apps/rpc/RunAppMethod', rpcBaseUrl);
+			const appToken = getAppToken();
 			const res = await fetch(url.toString(), {
 				method: 'POST',
+				headers: appToken ? { 'X-Robin-App-Token': appToken } : {},
 				body: JSON.stringify({ appId, serverFile, methodName, data }),
 				keepalive: true,
 			});
@@ -0,0 +1,14 @@
+/**
+ * Returns the token robin identifies this app by, instead of the app ID it sends. Daemons get
+ * it in their environment, and pages get it in a meta tag that robin adds when serving them.
+ */
+export function getAppToken(): string | undefined {
+	if (typeof document !== 'undefined') {
+		return (
+			document.querySelector<HTMLMetaElement>('meta[name="robin-app-token"]')
+				?.content ?? undefined
+		);
+	}
+
+	return process.env.ROBIN_APP_TOKEN;
+}
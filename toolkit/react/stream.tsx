@@ -45,24 +45,66 @@ export function useAppTopicQuery<State, Output>({
 	});
 }
 
+// A filter that the server applies before sending messages. `contains` and `match` only
+// keep string messages, and `where` tests values inside messages by their JSON path,
+// e.g. `{ path: '$.level', op: 'eq', value: 'error' }`.
+export type TopicFilter = {
+	contains?: string;
+	match?: string;
+	where?: {
+		path: string;
+		op:
+			| 'exists'
+			| 'eq'
+			| 'ne'
+			| 'lt'
+			| 'lte'
+			| 'gt'
+			| 'gte'
+			| 'contains'
+			| 'matches';
+		value?: unknown;
+	}[];
+};
+
+export type TopicThrottle = {
+	sampleEvery?: number;
+	maxPerSecond?: number;
+	debounceMs?: number;
+};
+
 // Subscribe to a topic and track the messages received in relation
-// to state.
+// to state. Filtering or throttling messages means that the reducer only
+// sees some of them.
 export function useTopicQuery<State, Output>({
 	topicId,
 	fetchState,
 	reducer,
 	resultType,
 	skip,
+	filter,
+	throttle,
 }: {
 	resultType: z.Schema<Output>;
 	topicId?: { category: string; key: string };
 	fetchState: () => Promise<{ state: State; counter: number }>;
 	reducer: (s: State, o: Output) => State;
 	skip?: boolean;
+	filter?: TopicFilter;
+	throttle?: TopicThrottle;
 }) {
 	return useTopicQueryInternal<State, Output>({
 		methodName: 'SubscribeTopic',
-		data: { id: topicId },
+		data: {
+			id: topicId,
+			filter,
+			throttle: throttle && {
+				sampleEvery: throttle.sampleEvery,
+				maxPerSecond: throttle.maxPerSecond,
+				// Durations are sent in nanoseconds
+				debounce: throttle.debounceMs && throttle.debounceMs * 1_000_000,
+			},
+		},
 		skip: skip || !topicId,
 		resultType,
 		reducer,
@@ -1,3 +1,5 @@
+import { getAppToken } from './internal/token';
+
 let _ws: Promise<WebSocket> | null = null;
 const inFlight: Map<string, Stream> = new Map();
 
@@ -12,8 +14,13 @@ async function getWs(): Promise<WebSocket> {
 	const newWs = new Promise<WebSocket>((res) => (resolveWs = res));
 	_ws = newWs;
 
+	// Apps identify themselves with the token robin gave them, so that robin can check
+	// their access to topics. Robin's own UI is identified by a cookie instead.
+	const appToken = getAppToken();
+	const query = appToken ? `?appToken=${encodeURIComponent(appToken)}` : '';
+
 	const ws = new WebSocket(
-		`ws://${window.location.hostname}:9010/api/websocket`,
+		`ws://${window.location.hostname}:9010/api/websocket${query}`,
 	);
 	ws.onclose = (evt) => {
 		console.log('close', evt.code);